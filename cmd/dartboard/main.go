@@ -17,21 +17,38 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/rancher/dartboard/cmd/dartboard/subcommands"
 	cli "github.com/urfave/cli/v2"
 )
 
 func appCommands() []*cli.Command {
+	targetFlag := &cli.StringSliceFlag{
+		Name:  subcommands.ArgTarget,
+		Usage: "only act on `TARGET`: upstream, tester, a downstream cluster name or template=<prefix>, can be repeated",
+	}
+
+	skipPreflightFlag := &cli.BoolFlag{
+		Name:        subcommands.ArgSkipPreflight,
+		Value:       false,
+		Usage:       "skip pre-flight checks of ports, credentials and provider prerequisites, see the doctor command",
+		DefaultText: "false",
+	}
+
 	return []*cli.Command{
 		{
 			Name:        "apply",
 			Usage:       "Runs `tofu apply`",
 			Description: "runs `tofu apply` to prepare infrastructure and Kubernetes clusters for tests",
 			Action:      subcommands.Apply,
+			Flags:       []cli.Flag{skipPreflightFlag},
 		},
 		{
 			Name:        "deploy",
@@ -57,6 +74,13 @@ func appCommands() []*cli.Command {
 					Usage:       "skip refresh phase for tofu resources, assume resources are refreshed and up-to-date",
 					DefaultText: "false",
 				},
+				&cli.BoolFlag{
+					Name:        subcommands.ArgRetryFailed,
+					Value:       false,
+					Usage:       "only retry downstream clusters that failed in a previous run, implies --skip-apply and --skip-charts",
+					DefaultText: "false",
+				},
+				skipPreflightFlag,
 			},
 		},
 		{
@@ -65,29 +89,176 @@ func appCommands() []*cli.Command {
 			Description: "Loads ConfigMaps and Secrets on all the deployed K8s cluster; Roles, Users and Projects on the Rancher cluster",
 			Action:      subcommands.Load,
 		},
+		{
+			Name:        "fixtures",
+			Usage:       "Creates the users, projects and role bindings of the dart's fixtures section",
+			Description: "creates RBAC fixtures through the Rancher API in batches, skipping the ones that already exist",
+			Action:      subcommands.Fixtures,
+		},
+		{
+			Name:        "chaos",
+			Usage:       "Injects the failures scheduled in the dart's chaos section",
+			Description: "kills Rancher pods, restarts datastores, cordons nodes, partitions downstream agents or throttles the upstream API at the times set in the dart, annotating Grafana; the same schedule also runs during load",
+			Action:      subcommands.Chaos,
+		},
+		{
+			Name:        "doctor",
+			Usage:       "Checks prerequisites of the dart's provider",
+			Description: "checks local ports, docker, ssh keys, cloud credentials and Harvester capacity, printing a pass/warn/fail table; the same checks run before apply and deploy",
+			Action:      subcommands.Doctor,
+		},
+		{
+			Name:        "mirror",
+			Usage:       "Pushes all images of the dart's charts to its air-gapped registry",
+			Description: "downloads charts and Rancher's image list to the air-gapped cache, then pulls the images of Rancher, its charts and k6 and pushes them to the local registry; run it with internet access after apply and before deploy",
+			Action:      subcommands.Mirror,
+		},
 		{
 			Name:        "get-access",
 			Usage:       "Retrieves information to access the deployed clusters",
 			Description: "print out links and access information for the deployed clusters",
 			Action:      subcommands.GetAccess,
 		},
+		{
+			Name:        "scale",
+			Usage:       "Adds or removes downstream clusters to match cluster_count values in the dart",
+			Description: "compares the dart with the current environment, removes the highest-numbered clusters from Rancher and infrastructure, or creates and onboards new ones",
+			Action:      subcommands.Scale,
+		},
+		{
+			Name:        "upgrade",
+			Usage:       "Upgrades Rancher and measures how long each phase takes",
+			Description: "runs `helm upgrade` of Rancher on the upstream cluster, then waits for the rollout and for all downstream agents to reconnect",
+			Action:      subcommands.Upgrade,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     subcommands.ArgRancherVersion,
+					Required: true,
+					Usage:    "Rancher chart version to upgrade to",
+				},
+				&cli.StringFlag{
+					Name:  subcommands.ArgImageTag,
+					Usage: "Rancher image tag to upgrade to, defaults to v<rancher-version>",
+				},
+				&cli.StringFlag{
+					Name:  subcommands.ArgK6Script,
+					Usage: "k6 script to run during the upgrade, eg. tests/rancher_availability.js",
+				},
+				&cli.DurationFlag{
+					Name:  subcommands.ArgK6Duration,
+					Value: 30 * time.Minute,
+					Usage: "how long the k6 script should run for, passed as the DURATION variable",
+				},
+			},
+		},
+		{
+			Name:        "upgrade-kubernetes",
+			Usage:       "Upgrades Kubernetes on provisioned and custom downstream clusters in batches",
+			Description: "changes the Kubernetes version of downstream clusters through Rancher's provisioning API, recording the duration of each upgrade",
+			Action:      subcommands.UpgradeKubernetes,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     subcommands.ArgKubernetesVersion,
+					Required: true,
+					Usage:    "Kubernetes version to upgrade to, eg. v1.31.4+rke2r1",
+				},
+				&cli.IntFlag{
+					Name:  subcommands.ArgCount,
+					Usage: "only upgrade the first `N` selected clusters, 0 means all",
+				},
+				&cli.DurationFlag{
+					Name:  subcommands.ArgTimeout,
+					Value: time.Hour,
+					Usage: "how long each cluster gets to complete its upgrade",
+				},
+				targetFlag,
+			},
+		},
+		{
+			Name:        "fleet",
+			Usage:       "Measures how long Fleet takes to distribute bundles to downstream clusters",
+			Description: "creates GitRepos, served by a git server on the tester cluster, or Bundles targeting downstream clusters and reports latency percentiles of their BundleDeployments getting ready",
+			Action:      subcommands.Fleet,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  subcommands.ArgSource,
+					Value: "gitrepo",
+					Usage: "what to distribute, gitrepo or bundle (inline resources, no git)",
+				},
+				&cli.IntFlag{
+					Name:  subcommands.ArgBundles,
+					Value: 1,
+					Usage: "number of GitRepos or Bundles to distribute",
+				},
+				&cli.IntFlag{
+					Name:  subcommands.ArgConfigMaps,
+					Value: 10,
+					Usage: "number of 1 KiB ConfigMaps in each bundle",
+				},
+				&cli.IntFlag{
+					Name:  subcommands.ArgCount,
+					Usage: "only target the first `N` selected clusters, 0 means all",
+				},
+				&cli.DurationFlag{
+					Name:  subcommands.ArgTimeout,
+					Value: 30 * time.Minute,
+					Usage: "how long clusters get to have all bundles ready",
+				},
+				&cli.BoolFlag{
+					Name:  subcommands.ArgKeep,
+					Usage: "keep GitRepos or Bundles after the benchmark instead of deleting them",
+				},
+				targetFlag,
+			},
+		},
+		{
+			Name:        "status",
+			Usage:       "Shows the state of the test environment",
+			Description: "combines tofu outputs, the cluster state file and live Rancher data into a per-cluster summary",
+			Action:      subcommands.Status,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    subcommands.ArgWatch,
+					Aliases: []string{"w"},
+					Value:   false,
+					Usage:   "refresh the status continuously",
+				},
+				&cli.StringFlag{
+					Name:    subcommands.ArgOutput,
+					Aliases: []string{"o"},
+					Value:   "table",
+					Usage:   "output format, table or json",
+				},
+			},
+		},
 		{
 			Name:        "destroy",
 			Usage:       "Tears down the test environment (all the clusters)",
 			Description: "runs `tofu destroy` to destroy all the provisioned clusters",
 			Action:      subcommands.Destroy,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        subcommands.ArgRancherOnly,
+					Value:       false,
+					Usage:       "only delete downstream clusters from Rancher and reset their state, keeping the infrastructure",
+					DefaultText: "false",
+				},
+				targetFlag,
+			},
 		},
 		{
 			Name:        "reapply",
 			Usage:       "Tears down the test environment (all the clusters) and re-runs `tofu apply`",
 			Description: "runs `tofu destroy` and then `tofu apply`",
 			Action:      subcommands.Reapply,
+			Flags:       []cli.Flag{targetFlag, skipPreflightFlag},
 		},
 		{
 			Name:        "redeploy",
 			Usage:       "Tears down the test environment (all the clusters) and redeploys them from scratch",
 			Description: "runs `tofu destroy` and then deploys all the provisioned clusters",
 			Action:      subcommands.Redeploy,
+			Flags:       []cli.Flag{targetFlag, skipPreflightFlag},
 		},
 		{
 			Name:        "summarize",
@@ -154,7 +325,23 @@ func main() {
 		Commands: appCommands(),
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	// the first Ctrl-C cancels the root context: running tofu, helm and kubectl processes are interrupted,
+	// no new batch jobs are started and in-flight ones are given a chance to finish. A second Ctrl-C kills immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-interrupts
+		log.Print("Interrupted, stopping new work and waiting for in-flight operations (press Ctrl-C again to force)")
+		// restore the default handling, so that the next signal kills the process
+		signal.Stop(interrupts)
+		cancel()
+	}()
+
+	if err := app.RunContext(ctx, os.Args); err != nil {
 		if exitErr, ok := err.(cli.ExitCoder); ok {
 			log.Print(err)
 			os.Exit(exitErr.ExitCode())
@@ -0,0 +1,152 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/rancher/dartboard/internal/airgap"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/helm"
+	"github.com/rancher/dartboard/internal/kubectl"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/sirupsen/logrus"
+)
+
+// workloadKinds are waited for with `kubectl rollout status` rather than a condition
+var workloadKinds = []string{"deployment", "deployments", "daemonset", "daemonsets", "statefulset", "statefulsets"}
+
+// installAddons installs the dart's addons of stage on their clusters, in dart order
+func installAddons(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster, stage string) error {
+	for _, addon := range r.Addons {
+		if addon.Stage != stage {
+			continue
+		}
+
+		names, err := addonClusters(addon, clusters)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			cluster := clusters[name]
+			if err := installAddonPhase(ctx, r.Airgap, addon, name, &cluster); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// installAddonPhase installs one addon on the cluster called name, annotating Grafana with the install
+func installAddonPhase(ctx context.Context, cfg airgap.Config, addon dart.Addon, name string, cluster *tofu.Cluster) error {
+	err := grafana.Phase(ctx, shared.Grafana(), "install addon "+addon.Name, []string{"addon", "cluster:" + name}, func() error {
+		return installAddon(ctx, cfg, addon, cluster)
+	})
+	if err != nil {
+		return fmt.Errorf("addon %s on cluster %s: %w", addon.Name, name, err)
+	}
+
+	return nil
+}
+
+// installAddon installs one addon on cluster and waits for its resources, if requested
+func installAddon(ctx context.Context, cfg airgap.Config, addon dart.Addon, cluster *tofu.Cluster) error {
+	var opts []helm.Option
+
+	if addon.Repo != "" {
+		opts = append(opts, helm.WithRepo(addon.Repo))
+	}
+
+	if addon.Version != "" {
+		opts = append(opts, helm.WithVersion(addon.Version))
+	}
+
+	if addon.ValuesFile != "" {
+		opts = append(opts, helm.WithValuesFiles(addon.ValuesFile))
+	}
+
+	if addon.Wait {
+		opts = append(opts, helm.WithWait(addon.Timeout))
+	}
+
+	err := chartInstall(ctx, cfg, cluster.Kubeconfig, chart{addon.Name, addon.Namespace, addon.Chart}, addon.Values, opts...)
+	if err != nil {
+		return err
+	}
+
+	minutes := int(math.Ceil(addon.Timeout.Minutes()))
+
+	for _, resource := range addon.WaitFor {
+		kind, name, _ := strings.Cut(resource, "/")
+
+		logrus.Infof("Waiting for %s of addon %s", resource, addon.Name)
+
+		if slices.Contains(workloadKinds, strings.ToLower(kind)) {
+			err = kubectl.RolloutStatus(ctx, cluster.Kubeconfig, kind, name, addon.Namespace, minutes)
+		} else {
+			err = kubectl.WaitForReadyCondition(ctx, cluster.Kubeconfig, kind, name, addon.Namespace, addon.WaitCondition, minutes)
+		}
+
+		if err != nil {
+			return fmt.Errorf("waiting for %s: %w", resource, err)
+		}
+	}
+
+	return nil
+}
+
+// addonClusters returns the sorted names of the clusters an addon is installed on
+func addonClusters(addon dart.Addon, clusters map[string]tofu.Cluster) ([]string, error) {
+	selected := map[string]bool{}
+
+	for _, spec := range addon.Clusters {
+		if prefix, ok := strings.CutPrefix(spec, targetTemplatePrefix); ok {
+			for name, cluster := range clusters {
+				if !strings.HasPrefix(name, prefix+"-") {
+					continue
+				}
+
+				// unlike clusters named explicitly, ones matched by a template are skipped if they cannot be reached
+				if len(cluster.Kubeconfig) == 0 {
+					logrus.Warnf("addon %s: cluster %s has no kubeconfig, skipping it", addon.Name, name)
+					continue
+				}
+
+				selected[name] = true
+			}
+
+			continue
+		}
+
+		if cluster, ok := clusters[spec]; !ok || len(cluster.Kubeconfig) == 0 {
+			return nil, fmt.Errorf("addon %s: cluster %q does not exist or has no kubeconfig", addon.Name, spec)
+		}
+
+		selected[spec] = true
+	}
+
+	return slices.Sorted(maps.Keys(selected)), nil
+}
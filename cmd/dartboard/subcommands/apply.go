@@ -16,21 +16,45 @@ limitations under the License.
 
 package subcommands
 
-import cli "github.com/urfave/cli/v2"
+import (
+	"fmt"
+
+	cli "github.com/urfave/cli/v2"
+)
 
 func Apply(cli *cli.Context) error {
-	tf, _, err := prepare(cli)
+	tf, r, err := prepare(cli)
 	if err != nil {
 		return err
 	}
 
-	if err = tf.PrintVersion(); err != nil {
+	if err = checkPreflight(cli, r); err != nil {
+		return err
+	}
+
+	if err = tf.PrintVersion(cli.Context); err != nil {
 		return err
 	}
 
 	skipRefresh := cli.Bool(ArgSkipRefresh)
 
-	if err = tf.Apply(skipRefresh); err != nil {
+	var targets []string
+
+	if specs := cli.StringSlice(ArgTarget); len(specs) > 0 {
+		selection, err := resolveTargets(r, nil, specs)
+		if err != nil {
+			return err
+		}
+
+		if len(selection.addresses) == 0 {
+			fmt.Println("No tofu resources targeted, nothing to apply")
+			return nil
+		}
+
+		targets = selection.addresses
+	}
+
+	if err = tf.Apply(cli.Context, skipRefresh, targets...); err != nil {
 		return err
 	}
 
@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/rancher/dartboard/internal/chaos"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/tofu"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Chaos injects the failures scheduled in the dart, eg. while load is generated by other means
+func Chaos(cli *cli.Context) error {
+	tf, r, err := prepare(cli)
+	if err != nil {
+		return err
+	}
+
+	if len(r.Chaos) == 0 {
+		return fmt.Errorf("no chaos events in the dart")
+	}
+
+	ctx := cli.Context
+
+	clusters, _, err := tf.ParseOutputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	setupGrafana(ctx, r, clusters)
+
+	schedule := startChaos(ctx, r, clusters)
+
+	return schedule.Wait()
+}
+
+// startChaos starts the dart's chaos schedule in the background, or returns nil if there is none
+func startChaos(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster) *chaos.Schedule {
+	if len(r.Chaos) == 0 {
+		return nil
+	}
+
+	log.Printf("Starting chaos schedule with %d events\n", len(r.Chaos))
+
+	return chaos.Start(ctx, r.Chaos, chaos.Environment{Clusters: clusters})
+}
@@ -17,19 +17,27 @@ limitations under the License.
 package subcommands
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/rancher/dartboard/internal/airgap"
 	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/grafana"
 	"github.com/rancher/dartboard/internal/helm"
 	"github.com/rancher/dartboard/internal/kubectl"
+	"github.com/rancher/dartboard/internal/readiness"
+	"github.com/rancher/dartboard/internal/shared"
 	"github.com/rancher/dartboard/internal/tofu"
 	"github.com/rancher/shepherd/clients/rancher"
 	"github.com/rancher/shepherd/pkg/session"
@@ -74,171 +82,395 @@ func Deploy(cli *cli.Context) error {
 		return err
 	}
 
+	ctx := cli.Context
+
+	unlock, err := lockClusterState(r)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	r.RetryFailedOnly = cli.Bool(ArgRetryFailed)
+	skipCharts := cli.Bool(ArgSkipCharts) || r.RetryFailedOnly
+
+	// checks are about creating infrastructure
+	if !cli.Bool(ArgSkipApply) && !r.RetryFailedOnly {
+		if err = checkPreflight(cli, r); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+
 	if err = applyTofuChanges(cli, tf); err != nil {
 		return err
 	}
 
-	clusters, custom_clusters, err := tf.ParseOutputs()
+	applied := time.Now()
+
+	clusters, custom_clusters, err := tf.ParseOutputs(ctx)
 	if err != nil {
 		return err
 	}
 
+	if err = addExternalClusters(r, clusters); err != nil {
+		return err
+	}
+
 	// Helm charts
-	tester := clusters["tester"]
-	if len(tester.Kubeconfig) > 0 && !cli.Bool(ArgSkipCharts) {
-		if err = installTesterCharts(tester, r); err != nil {
-			return err
-		}
+	if err = setupTester(ctx, r, clusters, skipCharts, start, applied); err != nil {
+		return err
 	}
 
 	upstream := clusters["upstream"]
-	rancherVersion := r.ChartVariables.RancherVersion
 
-	rancherImageTag := "v" + rancherVersion
+	rancherImageTag := defaultRancherImageTag(r)
 	if r.ChartVariables.RancherImageTagOverride != "" {
-		rancherImageTag = r.ChartVariables.RancherImageTagOverride
-
 		image := "rancher/rancher"
 		if r.ChartVariables.RancherImageOverride != "" {
 			image = r.ChartVariables.RancherImageOverride
 		}
 
-		err = importImageIntoK3d(tf, image+":"+rancherImageTag, upstream)
+		err = importImageIntoK3d(ctx, tf, image+":"+rancherImageTag, upstream)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !skipCharts {
+		err = grafana.Phase(ctx, shared.Grafana(), "install upstream charts", []string{"charts", "cluster:upstream"}, func() error {
+			return installUpstreamCharts(ctx, r, rancherImageTag, clusters)
+		})
 		if err != nil {
 			return err
 		}
+
+		if err = installAddons(ctx, r, clusters, dart.AddonAfterRancher); err != nil {
+			return err
+		}
+	}
+
+	rancherClient, rancherConfig, err := setupRancherClient(ctx, r, upstream)
+	if err != nil {
+		return err
 	}
 
-	if !cli.Bool(ArgSkipCharts) {
-		if err = installUpstreamCharts(r, rancherImageTag, &upstream); err != nil {
+	if !skipCharts {
+		if err = applyRancherConfig(ctx, r, clusters, rancherClient, &rancherConfig); err != nil {
 			return err
 		}
 	}
 
-	// Setup rancher client
-	upstreamAdd, err := getAppAddressFor(upstream)
+	err = deployDownstreamClusters(ctx, r, clusters, custom_clusters, rancherClient, &rancherConfig)
+
+	if summaryErr := printImportDurations(r, applied); summaryErr != nil {
+		logrus.Errorf("could not summarize import durations: %v", summaryErr)
+	}
+
+	// failed clusters are listed even if the run was aborted, so they can be retried
+	failed, summaryErr := printFailedClusters(r)
+	if summaryErr != nil {
+		logrus.Errorf("could not summarize failed clusters: %v", summaryErr)
+	}
+
 	if err != nil {
 		return err
 	}
 
+	if !skipCharts {
+		if err = installDownstreamCharts(ctx, r, clusters, rancherClient); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d downstream clusters failed, run `dartboard deploy --%s` to retry them", failed, ArgRetryFailed)
+	}
+
+	return GetAccess(cli)
+}
+
+// setupRancherClient waits for Rancher's readiness gates, then logs in and completes Rancher's first-run setup
+func setupRancherClient(ctx context.Context, r *dart.Dart, upstream tofu.Cluster) (*rancher.Client, rancher.Config, error) {
+	// charts may have been installed by a previous run, Rancher can still be starting up
+	if err := waitRancherReady(ctx, upstream); err != nil {
+		return nil, rancher.Config{}, err
+	}
+
 	rancherSession := session.NewSession()
 	rancherSession.CleanupEnabled = false
 
 	logrus.Info("Setting up Rancher Client's Config")
 
-	rancherHost := strings.Split(upstreamAdd.Local.HTTPSURL, "://")[1]
-	rancherConfig := actions.NewRancherConfig(rancherHost, "", r.ChartVariables.AdminPassword, true)
+	rancherConfig, err := rancherConfigFor(ctx, r, upstream)
+	if err != nil {
+		return nil, rancher.Config{}, err
+	}
 
 	logrus.Info("Setting up Rancher Client")
 
-	rancherClient, err := actions.SetupRancherClient(&rancherConfig, r.ChartVariables.AdminPassword, rancherSession)
+	rancherClient, err := actions.SetupRancherClient(ctx, &rancherConfig, r.ChartVariables.AdminPassword, rancherSession)
+	if err != nil {
+		return nil, rancher.Config{}, err
+	}
+
+	return rancherClient, rancherConfig, nil
+}
+
+// waitRancherReady waits until the Rancher on upstream passes all readiness gates, see readiness.RancherGates
+func waitRancherReady(ctx context.Context, upstream tofu.Cluster) error {
+	upstreamAdd, err := getAppAddressFor(ctx, upstream)
+	if err != nil {
+		return err
+	}
+
+	target, err := readiness.NewTarget(upstream.Kubeconfig, upstreamAdd.Local.HTTPSURL)
 	if err != nil {
 		return err
 	}
 
+	return readiness.Wait(ctx, target, readiness.RancherGates())
+}
+
+// deployDownstreamClusters imports, registers and provisions all downstream clusters into Rancher
+func deployDownstreamClusters(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster, customClusters []tofu.CustomCluster,
+	rancherClient *rancher.Client, rancherConfig *rancher.Config,
+) error {
 	if len(clusters) > 0 {
-		if err = importDownstreamClusters(r, clusters, rancherClient, &rancherConfig); err != nil {
+		err := grafana.Phase(ctx, shared.Grafana(), "import downstream clusters", []string{"import"}, func() error {
+			return importDownstreamClusters(ctx, r, clusters, rancherClient, rancherConfig)
+		})
+		if err != nil {
 			return err
 		}
 	}
 
 	logrus.Debugf("\nBEFORE CUSTOM CLUSTER LOGIC\n")
 
-	if len(custom_clusters) > 0 {
+	if len(customClusters) > 0 {
 		logrus.Debugf("\nIN CUSTOM CLUSTER LOGIC\n")
 
-		if err := actions.RegisterCustomClusters(r, custom_clusters, rancherClient, &rancherConfig); err != nil {
+		err := grafana.Phase(ctx, shared.Grafana(), "register custom clusters", []string{"register"}, func() error {
+			return actions.RegisterCustomClusters(ctx, r, customClusters, rancherClient, rancherConfig)
+		})
+		if err != nil {
 			return err
 		}
 	}
 
 	if len(r.ClusterTemplates) > 0 {
-		if err = setupHarvesterAndProvision(r, rancherClient); err != nil {
+		if err := setupHarvesterAndProvision(r, rancherClient); err != nil {
 			return err
 		}
 
 		logrus.Info("Provisioning Downstream Clusters")
 
-		if err = actions.ProvisionDownstreamClusters(r, r.ClusterTemplates, rancherClient); err != nil {
+		err := grafana.Phase(ctx, shared.Grafana(), "provision downstream clusters", []string{"provision"}, func() error {
+			return actions.ProvisionDownstreamClusters(ctx, r, r.ClusterTemplates, rancherClient)
+		})
+		if err != nil {
 			return err
 		}
 	}
 
-	return GetAccess(cli)
+	return nil
+}
+
+// printFailedClusters prints a summary of downstream clusters whose last attempt failed, returning their count
+func printFailedClusters(r *dart.Dart) (int, error) {
+	statePath := clusterStatePath(r)
+	if _, err := os.Stat(statePath); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	statuses, err := actions.LoadClusterState(statePath)
+	if err != nil {
+		return 0, err
+	}
+
+	failed := actions.FailedClusterStatuses(statuses)
+	if len(failed) == 0 {
+		return 0, nil
+	}
+
+	fmt.Printf("*** %d FAILED CLUSTERS\n", len(failed))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTAGE\tATTEMPTS\tERROR")
+
+	for _, cs := range failed {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", cs.Name, cs.Stage, cs.Attempts, strings.Join(strings.Fields(cs.Error), " "))
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+
+	fmt.Println()
+
+	return len(failed), nil
+}
+
+// printImportDurations prints percentiles of the time clusters imported since took from creation to being imported,
+// to compare import strategies
+func printImportDurations(r *dart.Dart, since time.Time) error {
+	statePath := clusterStatePath(r)
+	if _, err := os.Stat(statePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	statuses, err := actions.LoadClusterState(statePath)
+	if err != nil {
+		return err
+	}
+
+	var durations []time.Duration
+
+	for _, cs := range statuses {
+		var created, imported time.Time
+
+		for _, event := range cs.History {
+			switch event.Stage {
+			case actions.StageCreated:
+				created = event.Time
+			case actions.StageImported:
+				imported = event.Time
+			}
+		}
+
+		if !created.IsZero() && imported.After(since) {
+			durations = append(durations, imported.Sub(created).Round(time.Second))
+		}
+	}
+
+	if len(durations) == 0 {
+		return nil
+	}
+
+	slices.Sort(durations)
+
+	// nearest rank
+	rank := func(p int) time.Duration { return durations[max((p*len(durations)+99)/100-1, 0)] }
+
+	fmt.Printf("*** %d CLUSTERS IMPORTED WITH STRATEGY %q\n", len(durations), r.ImportStrategy)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "P50\tP90\tP99\tMAX")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rank(50), rank(90), rank(99), durations[len(durations)-1])
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+
+	return nil
 }
 
 // applyTofuChanges applies or outputs Terraform/Tofu changes
 func applyTofuChanges(cli *cli.Context, tf *tofu.Tofu) error {
 	skipRefresh := cli.Bool(ArgSkipRefresh)
 
-	if !cli.Bool(ArgSkipApply) {
-		if err := tf.PrintVersion(); err != nil {
+	if !cli.Bool(ArgSkipApply) && !cli.Bool(ArgRetryFailed) {
+		if err := tf.PrintVersion(cli.Context); err != nil {
+			return err
+		}
+
+		return tf.Apply(cli.Context, skipRefresh)
+	}
+
+	return tf.Output(cli.Context, nil, false)
+}
+
+// setupTester installs charts on the tester cluster unless skipCharts, then starts annotating its Grafana. As Grafana
+// only answers once installed, the tofu apply that ran from start to applied is annotated retroactively
+func setupTester(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster, skipCharts bool, start, applied time.Time) error {
+	tester := clusters["tester"]
+	installCharts := len(tester.Kubeconfig) > 0 && !skipCharts
+
+	if installCharts {
+		if err := installTesterCharts(ctx, tester, r); err != nil {
 			return err
 		}
+	}
+
+	setupGrafana(ctx, r, clusters)
+	grafana.Annotate(ctx, shared.Grafana(), grafana.Annotation{Time: start, End: applied, Tags: []string{"phase", "tofu_apply"}, Text: "tofu apply"})
 
-		return tf.Apply(skipRefresh)
+	if installCharts {
+		grafana.Annotate(ctx, shared.Grafana(), grafana.Annotation{
+			Time: applied, End: time.Now(), Tags: []string{"phase", "charts", "cluster:tester"}, Text: "install tester charts",
+		})
 	}
 
-	return tf.Output(nil, false)
+	return nil
 }
 
 // installTesterCharts installs required charts on the tester cluster
-func installTesterCharts(tester tofu.Cluster, r *dart.Dart) error {
-	if err := chartInstall(tester.Kubeconfig, chart{chartNameK6Files, nsTester, chartNameK6Files}, nil); err != nil {
+func installTesterCharts(ctx context.Context, tester tofu.Cluster, r *dart.Dart) error {
+	if err := chartInstall(ctx, r.Airgap, tester.Kubeconfig, chart{chartNameK6Files, nsTester, chartNameK6Files}, nil); err != nil {
 		return err
 	}
 
-	if err := chartInstall(tester.Kubeconfig, chart{chartNameMimir, nsTester, chartNameMimir}, nil); err != nil {
+	if err := chartInstall(ctx, r.Airgap, tester.Kubeconfig, chart{chartNameMimir, nsTester, chartNameMimir}, getLocalChartValsJSON(r.Airgap)); err != nil {
 		return err
 	}
 
-	if err := chartInstall(tester.Kubeconfig, chart{chartNameGrafanaDashboards, nsTester, chartNameGrafanaDashboards}, nil); err != nil {
+	if err := chartInstall(ctx, r.Airgap, tester.Kubeconfig, chart{chartNameGrafanaDashboards, nsTester, chartNameGrafanaDashboards}, nil); err != nil {
 		return err
 	}
 
-	return chartInstallGrafana(r, &tester)
+	return chartInstallGrafana(ctx, r, &tester)
 }
 
-// installUpstreamCharts installs Rancher and related charts on the upstream cluster
-func installUpstreamCharts(r *dart.Dart, rancherImageTag string, upstream *tofu.Cluster) error {
-	if err := chartInstallCertManager(r, upstream); err != nil {
+// installUpstreamCharts installs Rancher and related charts on the upstream cluster, and addons that Rancher
+// should find installed
+func installUpstreamCharts(ctx context.Context, r *dart.Dart, rancherImageTag string, clusters map[string]tofu.Cluster) error {
+	upstreamCluster := clusters["upstream"]
+	upstream := &upstreamCluster
+
+	if err := chartInstallCertManager(ctx, r, upstream); err != nil {
+		return err
+	}
+
+	if err := installAddons(ctx, r, clusters, dart.AddonBeforeRancher); err != nil {
 		return err
 	}
 
-	if err := chartInstallRancher(r, rancherImageTag, upstream); err != nil {
+	if err := chartInstallRancher(ctx, r, rancherImageTag, upstream); err != nil {
 		return err
 	}
 
-	if err := chartInstallRancherIngress(upstream); err != nil {
+	if err := chartInstallRancherIngress(ctx, r.Airgap, upstream); err != nil {
 		return err
 	}
 
-	if err := chartInstallCgroupsExporter(upstream); err != nil {
+	if err := chartInstallCgroupsExporter(ctx, r.Airgap, upstream); err != nil {
 		return err
 	}
 
-	// Wait for Rancher deployments to be complete, or subsequent steps may fail
-	if err := kubectl.WaitRancher(upstream.Kubeconfig); err != nil {
+	// Wait for Rancher to be fully up, or subsequent steps may fail
+	if err := waitRancherReady(ctx, *upstream); err != nil {
 		return err
 	}
 
-	if err := chartInstallRancherMonitoring(r, upstream); err != nil {
+	if err := chartInstallRancherMonitoring(ctx, r, upstream); err != nil {
 		return err
 	}
 
-	if err := updateMonitoringProject(upstream); err != nil {
+	if err := updateMonitoringProject(ctx, upstream); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// importDownstreamClusters imports all downstream clusters into Rancher
-func importDownstreamClusters(r *dart.Dart, clusters map[string]tofu.Cluster, rancherClient *rancher.Client, rancherConfig *rancher.Config) error {
+// importDownstreamClusters imports all downstream clusters, created by tofu or external, into Rancher
+func importDownstreamClusters(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster, rancherClient *rancher.Client, rancherConfig *rancher.Config) error {
 	downstreamClusters := []tofu.Cluster{}
 
 	for k, v := range clusters {
-		if strings.HasPrefix(k, "downstream") {
+		if isDownstream(r, k) {
 			v.Name = k
 			downstreamClusters = append(downstreamClusters, v)
 		}
@@ -255,7 +487,7 @@ func importDownstreamClusters(r *dart.Dart, clusters map[string]tofu.Cluster, ra
 
 	logrus.Info("Importing Downstream Clusters")
 
-	return actions.ImportDownstreamClusters(r, downstreamClusters, rancherClient, rancherConfig)
+	return actions.ImportDownstreamClusters(ctx, r, downstreamClusters, rancherClient, rancherConfig)
 }
 
 // setupHarvesterAndProvision sets up Harvester client if needed
@@ -295,35 +527,45 @@ func setupHarvesterAndProvision(r *dart.Dart, rancherClient *rancher.Client) err
 	return harvesterClient.ImportCluster()
 }
 
-func chartInstall(kubeConf string, chart chart, vals map[string]any, extraArgs ...string) error {
+func chartInstall(ctx context.Context, cfg airgap.Config, kubeConf string, chart chart, vals map[string]any, opts ...helm.Option) error {
 	var err error
 
 	name := chart.name
 	namespace := chart.namespace
 	path := chart.path
 
-	// Pull from local `charts/` dir if not using remote chart
-	if !strings.HasPrefix(path, "http") {
-		path = filepath.Join("charts", path)
+	// Pull from local `charts/` dir if it has the chart, remote charts come from the cache when air-gapped.
+	// Other references, eg. oci:// or chart names with helm.WithRepo, are passed to helm as they are
+	if local := filepath.Join("charts", path); !strings.Contains(path, "://") && isDir(local) {
+		path = local
+	} else if cfg.Enabled() && strings.HasPrefix(path, "http") {
+		if path, err = cfg.CachedChart(path); err != nil {
+			return fmt.Errorf("chart %s: %w", name, err)
+		}
 	}
 
 	logrus.Infof("Installing chart %q (%s)", namespace+"/"+name, path)
 
-	if err = helm.Install(kubeConf, path, name, namespace, vals, extraArgs...); err != nil {
+	if err = helm.Install(ctx, kubeConf, path, name, namespace, vals, opts...); err != nil {
 		return fmt.Errorf("chart %s: %w", name, err)
 	}
 
 	return nil
 }
 
-func chartInstallGrafana(r *dart.Dart, cluster *tofu.Cluster) error {
-	chartGrafana := chart{
+// grafanaChart returns the Grafana chart installed on the tester cluster
+func grafanaChart(r *dart.Dart) chart {
+	return chart{
 		name:      chartNameGrafana,
 		namespace: nsTester,
 		path:      fmt.Sprintf("https://github.com/grafana/helm-charts/releases/download/grafana-%[1]s/grafana-%[1]s.tgz", r.ChartVariables.TesterGrafanaVersion),
 	}
+}
+
+func chartInstallGrafana(ctx context.Context, r *dart.Dart, cluster *tofu.Cluster) error {
+	chartGrafana := grafanaChart(r)
 
-	clusterAdd, err := getAppAddressFor(*cluster)
+	clusterAdd, err := getAppAddressFor(ctx, *cluster)
 	if err != nil {
 		return fmt.Errorf("chart %s: %w", chartGrafana.name, err)
 	}
@@ -332,20 +574,52 @@ func chartInstallGrafana(r *dart.Dart, cluster *tofu.Cluster) error {
 	grafanaURL := clusterAdd.Local.HTTPURL
 	chartVals := getGrafanaValsJSON(r, grafanaName, grafanaURL, cluster.IngressClassName)
 
-	return chartInstall(cluster.Kubeconfig, chartGrafana, chartVals)
+	return chartInstall(ctx, r.Airgap, cluster.Kubeconfig, chartGrafana, chartVals)
 }
 
-func chartInstallCertManager(r *dart.Dart, cluster *tofu.Cluster) error {
-	chartCertManager := chart{
+// certManagerChart returns the cert-manager chart installed on the upstream cluster
+func certManagerChart(r *dart.Dart) chart {
+	return chart{
 		name:      chartNameCertManager,
 		namespace: nsCertManager,
 		path:      fmt.Sprintf("https://charts.jetstack.io/charts/cert-manager-v%s.tgz", r.ChartVariables.CertManagerVersion),
 	}
+}
+
+func chartInstallCertManager(ctx context.Context, r *dart.Dart, cluster *tofu.Cluster) error {
+	return chartInstall(ctx, r.Airgap, cluster.Kubeconfig, certManagerChart(r), getCertManagerValsJSON(r.Airgap))
+}
+
+// getCertManagerValsJSON points all cert-manager images to the air-gapped registry, if any
+func getCertManagerValsJSON(cfg airgap.Config) map[string]any {
+	vals := map[string]any{"installCRDs": true}
+
+	if !cfg.Enabled() {
+		return vals
+	}
+
+	components := map[string]string{
+		"":                "cert-manager-controller",
+		"webhook":         "cert-manager-webhook",
+		"cainjector":      "cert-manager-cainjector",
+		"startupapicheck": "cert-manager-startupapicheck",
+		"acmesolver":      "cert-manager-acmesolver",
+	}
+
+	for component, image := range components {
+		imageVals := map[string]any{"registry": "", "repository": cfg.Image("quay.io/jetstack/" + image)}
+		if component == "" {
+			vals["image"] = imageVals
+		} else {
+			vals[component] = map[string]any{"image": imageVals}
+		}
+	}
 
-	return chartInstall(cluster.Kubeconfig, chartCertManager, map[string]any{"installCRDs": true})
+	return vals
 }
 
-func chartInstallRancher(r *dart.Dart, rancherImageTag string, cluster *tofu.Cluster) error {
+// rancherChart returns the Rancher chart of the dart's version, from the Prime, alpha, latest or overridden repo
+func rancherChart(r *dart.Dart) chart {
 	var rancherRepo string
 
 	if r.ChartVariables.RancherChartRepoOverride != "" {
@@ -366,13 +640,26 @@ func chartInstallRancher(r *dart.Dart, rancherImageTag string, cluster *tofu.Clu
 		}
 	}
 
-	chartRancher := chart{
+	return chart{
 		name:      chartNameRancher,
 		namespace: nsCattleSystem,
 		path:      rancherRepo + r.ChartVariables.RancherVersion + ".tgz",
 	}
+}
+
+// defaultRancherImageTag returns the Rancher image tag to deploy, by default the one of the Rancher version
+func defaultRancherImageTag(r *dart.Dart) string {
+	if r.ChartVariables.RancherImageTagOverride != "" {
+		return r.ChartVariables.RancherImageTagOverride
+	}
+
+	return "v" + r.ChartVariables.RancherVersion
+}
 
-	clusterAdd, err := getAppAddressFor(*cluster)
+func chartInstallRancher(ctx context.Context, r *dart.Dart, rancherImageTag string, cluster *tofu.Cluster) error {
+	chartRancher := rancherChart(r)
+
+	clusterAdd, err := getAppAddressFor(ctx, *cluster)
 	if err != nil {
 		return fmt.Errorf("chart %s: %w", chartRancher.name, err)
 	}
@@ -398,9 +685,10 @@ func chartInstallRancher(r *dart.Dart, rancherImageTag string, cluster *tofu.Clu
 	}
 	extraEnv = append(extraEnv, r.ChartVariables.ExtraEnvironmentVariables...)
 
-	chartVals := getRancherValsJSON(r.ChartVariables.RancherImageOverride, rancherImageTag, r.ChartVariables.AdminPassword, rancherClusterName, extraEnv, r.ChartVariables.RancherReplicas)
+	chartVals := getRancherValsJSON(r.ChartVariables.RancherImageOverride, rancherImageTag, r.ChartVariables.AdminPassword, rancherClusterName, extraEnv,
+		r.ChartVariables.RancherReplicas, r.Airgap.Registry)
 
-	var extraArgs []string
+	var opts []helm.Option
 
 	if r.ChartVariables.RancherValues != "" {
 		p, err := writeValuesFile(r.ChartVariables.RancherValues)
@@ -409,7 +697,7 @@ func chartInstallRancher(r *dart.Dart, rancherImageTag string, cluster *tofu.Clu
 		}
 		defer os.Remove(p)
 
-		extraArgs = append(extraArgs, "-f", p)
+		opts = append(opts, helm.WithValuesFiles(p))
 	}
 
 	logrus.Debug("RANCHER CHART VALS:")
@@ -418,7 +706,7 @@ func chartInstallRancher(r *dart.Dart, rancherImageTag string, cluster *tofu.Clu
 		logrus.Debugf("\t%s = %v", key, value)
 	}
 
-	return chartInstall(cluster.Kubeconfig, chartRancher, chartVals, extraArgs...)
+	return chartInstall(ctx, r.Airgap, cluster.Kubeconfig, chartRancher, chartVals, opts...)
 }
 
 func writeValuesFile(content string) (string, error) {
@@ -434,14 +722,14 @@ func writeValuesFile(content string) (string, error) {
 	return p.Name(), nil
 }
 
-func chartInstallRancherIngress(cluster *tofu.Cluster) error {
+func chartInstallRancherIngress(ctx context.Context, cfg airgap.Config, cluster *tofu.Cluster) error {
 	chartRancherIngress := chart{
 		name:      chartNameRancherIngress,
 		namespace: nsDefault,
 		path:      chartNameRancherIngress,
 	}
 
-	clusterAdd, err := getAppAddressFor(*cluster)
+	clusterAdd, err := getAppAddressFor(ctx, *cluster)
 	if err != nil {
 		return fmt.Errorf("chart %s: %w", chartRancherIngress.name, err)
 	}
@@ -459,7 +747,7 @@ func chartInstallRancherIngress(cluster *tofu.Cluster) error {
 	if len(sans) == 0 {
 		logrus.Infof("No additional SANs needed, uninstalling chart %q if present", chartRancherIngress.namespace+"/"+chartRancherIngress.name)
 
-		if err := helm.UninstallIfPresent(cluster.Kubeconfig, chartRancherIngress.name, chartRancherIngress.namespace); err != nil {
+		if err := helm.UninstallIfPresent(ctx, cluster.Kubeconfig, chartRancherIngress.name, chartRancherIngress.namespace); err != nil {
 			return fmt.Errorf("chart %s: uninstall: %w", chartRancherIngress.name, err)
 		}
 
@@ -471,10 +759,11 @@ func chartInstallRancherIngress(cluster *tofu.Cluster) error {
 		"ingressClassName": cluster.IngressClassName,
 	}
 
-	return chartInstall(cluster.Kubeconfig, chartRancherIngress, chartVals)
+	return chartInstall(ctx, cfg, cluster.Kubeconfig, chartRancherIngress, chartVals)
 }
 
-func chartInstallRancherMonitoring(r *dart.Dart, cluster *tofu.Cluster) error {
+// rancherMonitoringCharts returns the rancher-monitoring CRD and main charts of the dart's version
+func rancherMonitoringCharts(r *dart.Dart) (chart, chart) {
 	rancherMinorVersion := strings.Join(strings.Split(r.ChartVariables.RancherVersion, ".")[0:2], ".")
 
 	const chartPrefix = "https://github.com/rancher/charts/raw/release-v"
@@ -492,22 +781,6 @@ func chartInstallRancherMonitoring(r *dart.Dart, cluster *tofu.Cluster) error {
 		path:      fmt.Sprintf("%s/%s-%s.tgz", chartPath, chartRancherMonitoringCRDRoute, r.ChartVariables.RancherMonitoringVersion),
 	}
 
-	chartVals := map[string]any{
-		"global": map[string]any{
-			"cattle": map[string]any{
-				"clusterId":             "local",
-				"clusterName":           "local",
-				"systemDefaultRegistry": "",
-			},
-		},
-		"systemDefaultRegistry": "",
-	}
-
-	err := chartInstall(cluster.Kubeconfig, chartRancherMonitoringCRD, chartVals)
-	if err != nil {
-		return err
-	}
-
 	chartRancherMonitoringRoute := "assets/rancher-monitoring/rancher-monitoring"
 	chartRancherMonitoring := chart{
 		name:      chartNameRancherMonitoring,
@@ -515,25 +788,47 @@ func chartInstallRancherMonitoring(r *dart.Dart, cluster *tofu.Cluster) error {
 		path:      fmt.Sprintf("%s/%s-%s.tgz", chartPath, chartRancherMonitoringRoute, r.ChartVariables.RancherMonitoringVersion),
 	}
 
-	clusterAdd, err := getAppAddressFor(*cluster)
+	return chartRancherMonitoringCRD, chartRancherMonitoring
+}
+
+func chartInstallRancherMonitoring(ctx context.Context, r *dart.Dart, cluster *tofu.Cluster) error {
+	clusterAdd, err := getAppAddressFor(ctx, *cluster)
 	if err != nil {
-		return fmt.Errorf("chart %s: %w", chartRancherMonitoring.name, err)
+		return fmt.Errorf("chart %s: %w", chartNameRancherMonitoring, err)
 	}
 
 	mimirURL := clusterAdd.Public.HTTPURL + "/mimir/api/v1/push"
 
-	chartVals = getRancherMonitoringValsJSON(cluster.ReserveNodeForMonitoring, mimirURL)
+	return chartInstallMonitoring(ctx, r, cluster, "local", "local", mimirURL)
+}
+
+// chartInstallMonitoring installs rancher-monitoring on a cluster Rancher knows as clusterID, remote-writing to mimirURL
+func chartInstallMonitoring(ctx context.Context, r *dart.Dart, cluster *tofu.Cluster, clusterID, clusterName, mimirURL string) error {
+	chartRancherMonitoringCRD, chartRancherMonitoring := rancherMonitoringCharts(r)
+	registry := r.Airgap.Registry
+
+	chartVals := map[string]any{
+		"global":                getCattleGlobalVals(clusterID, clusterName, registry),
+		"systemDefaultRegistry": registry,
+	}
+
+	err := chartInstall(ctx, r.Airgap, cluster.Kubeconfig, chartRancherMonitoringCRD, chartVals)
+	if err != nil {
+		return err
+	}
 
-	return chartInstall(cluster.Kubeconfig, chartRancherMonitoring, chartVals)
+	chartVals = getRancherMonitoringValsJSON(cluster.ReserveNodeForMonitoring, mimirURL, registry, clusterID, clusterName)
+
+	return chartInstall(ctx, r.Airgap, cluster.Kubeconfig, chartRancherMonitoring, chartVals)
 }
 
-func chartInstallCgroupsExporter(cluster *tofu.Cluster) error {
+func chartInstallCgroupsExporter(ctx context.Context, cfg airgap.Config, cluster *tofu.Cluster) error {
 	var b strings.Builder
-	if err := kubectl.Exec(cluster.Kubeconfig, &b, "get", "nodes", "-o", "jsonpath={.items[*].status.nodeInfo.osImage}"); err != nil {
+	if err := kubectl.Exec(ctx, cluster.Kubeconfig, &b, "get", "nodes", "-o", "jsonpath={.items[*].status.nodeInfo.osImage}"); err != nil {
 		return fmt.Errorf("failed to get node os images: %w", err)
 	}
 
-	vals := map[string]any{}
+	vals := getLocalChartValsJSON(cfg)
 
 	osImages := strings.ToLower(b.String())
 	if strings.Contains(osImages, "suse linux micro") || strings.Contains(osImages, "opensuse leap micro") {
@@ -542,10 +837,30 @@ func chartInstallCgroupsExporter(cluster *tofu.Cluster) error {
 		logrus.Infof("Disabling mountHostSys for cgroups-exporter due to detected OS: %s", b.String())
 	}
 
-	return chartInstall(cluster.Kubeconfig, chart{chartNameCgroupsExporter, nsCattleMonitoringSystem, chartNameCgroupsExporter}, vals)
+	return chartInstall(ctx, cfg, cluster.Kubeconfig, chart{chartNameCgroupsExporter, nsCattleMonitoringSystem, chartNameCgroupsExporter}, vals)
+}
+
+// getLocalChartValsJSON points the image of charts in the `charts/` dir to the air-gapped registry, if any
+func getLocalChartValsJSON(cfg airgap.Config) map[string]any {
+	if !cfg.Enabled() {
+		return map[string]any{}
+	}
+
+	return map[string]any{"image": map[string]any{"registry": cfg.Registry}}
+}
+
+// getCattleGlobalVals returns the global values Rancher sets on the charts it installs on a cluster
+func getCattleGlobalVals(clusterID, clusterName, registry string) map[string]any {
+	return map[string]any{
+		"cattle": map[string]any{
+			"clusterId":             clusterID,
+			"clusterName":           clusterName,
+			"systemDefaultRegistry": registry,
+		},
+	}
 }
 
-func getRancherMonitoringValsJSON(reserveNodeForMonitoring bool, mimirURL string) map[string]any {
+func getRancherMonitoringValsJSON(reserveNodeForMonitoring bool, mimirURL, registry, clusterID, clusterName string) map[string]any {
 	nodeSelector := map[string]any{}
 	tolerations := []any{}
 	monitoringRestrictions := map[string]any{}
@@ -583,6 +898,8 @@ func getRancherMonitoringValsJSON(reserveNodeForMonitoring bool, mimirURL string
 				"resources":          map[string]any{"limits": map[string]any{"memory": "10000Mi"}},
 				"retentionSize":      "50GiB",
 				"scrapeInterval":     "1m",
+				// tells apart series of all clusters writing to the same Mimir
+				"externalLabels": map[string]any{"cluster": clusterName},
 
 				"additionalScrapeConfigs": []any{
 					map[string]any{
@@ -623,22 +940,16 @@ func getRancherMonitoringValsJSON(reserveNodeForMonitoring bool, mimirURL string
 				"remoteWrite": remoteWrite,
 			},
 		},
-		"prometheus-adapter": monitoringRestrictions,
-		"kube-state-metrics": monitoringRestrictions,
-		"prometheusOperator": monitoringRestrictions,
-		"global": map[string]any{
-			"cattle": map[string]any{
-				"clusterId":             "local",
-				"clusterName":           "local",
-				"systemDefaultRegistry": "",
-			},
-		},
-		"systemDefaultRegistry": "",
+		"prometheus-adapter":    monitoringRestrictions,
+		"kube-state-metrics":    monitoringRestrictions,
+		"prometheusOperator":    monitoringRestrictions,
+		"global":                getCattleGlobalVals(clusterID, clusterName, registry),
+		"systemDefaultRegistry": registry,
 	}
 }
 
 func getGrafanaValsJSON(r *dart.Dart, name, url, ingressClass string) map[string]any {
-	return map[string]any{
+	vals := map[string]any{
 		"datasources": map[string]any{
 			"datasources.yaml": map[string]any{
 				"apiVersion": 1,
@@ -679,9 +990,19 @@ func getGrafanaValsJSON(r *dart.Dart, name, url, ingressClass string) map[string
 		},
 		"adminPassword": r.ChartVariables.AdminPassword,
 	}
+
+	// repositories include the registry, which works for chart versions with and without separate registry values
+	if cfg := r.Airgap; cfg.Enabled() {
+		vals["image"] = map[string]any{"registry": "", "repository": cfg.Image("grafana/grafana")}
+		vals["initChownData"] = map[string]any{"image": map[string]any{"registry": "", "repository": cfg.Image("busybox")}}
+		vals["downloadDashboardsImage"] = map[string]any{"registry": "", "repository": cfg.Image("curlimages/curl")}
+		vals["sidecar"] = map[string]any{"image": map[string]any{"registry": "", "repository": cfg.Image("quay.io/kiwigrid/k8s-sidecar")}}
+	}
+
+	return vals
 }
 
-func getRancherValsJSON(rancherImageOverride, rancherImageTag, bootPwd, hostname string, extraEnv []map[string]any, replicas int) map[string]any {
+func getRancherValsJSON(rancherImageOverride, rancherImageTag, bootPwd, hostname string, extraEnv []map[string]any, replicas int, registry string) map[string]any {
 	result := map[string]any{
 		"bootstrapPassword": bootPwd,
 		"hostname":          hostname,
@@ -700,6 +1021,12 @@ func getRancherValsJSON(rancherImageOverride, rancherImageTag, bootPwd, hostname
 		result["rancherImage"] = rancherImageOverride
 	}
 
+	// Rancher prefixes its own image, agents and system charts with the air-gapped registry
+	if registry != "" {
+		result["systemDefaultRegistry"] = registry
+		result["useBundledSystemChart"] = true
+	}
+
 	return result
 }
 
@@ -739,14 +1066,14 @@ func SortItemsNaturally[T any](items []T, getName func(T) string) {
 	})
 }
 
-func updateMonitoringProject(cluster *tofu.Cluster) error {
+func updateMonitoringProject(ctx context.Context, cluster *tofu.Cluster) error {
 	var b strings.Builder
 
 	args := []string{
 		"get", "namespace", nsCattleSystem,
 		"-o", "jsonpath={.metadata.annotations.field\\.cattle\\.io/projectId}",
 	}
-	if err := kubectl.Exec(cluster.Kubeconfig, &b, args...); err != nil {
+	if err := kubectl.Exec(ctx, cluster.Kubeconfig, &b, args...); err != nil {
 		return fmt.Errorf("failed to read projectId from cattle-system: %w", err)
 	}
 
@@ -755,7 +1082,7 @@ func updateMonitoringProject(cluster *tofu.Cluster) error {
 		return fmt.Errorf("no projectId found")
 	}
 
-	if err := kubectl.Exec(cluster.Kubeconfig, os.Stdout,
+	if err := kubectl.Exec(ctx, cluster.Kubeconfig, os.Stdout,
 		"annotate", "namespace", nsCattleMonitoringSystem,
 		"field.cattle.io/projectId="+projID, "--overwrite"); err != nil {
 		return fmt.Errorf("failed to annotate cattle-monitoring-system: %w", err)
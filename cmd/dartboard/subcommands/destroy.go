@@ -17,24 +17,180 @@ limitations under the License.
 package subcommands
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/rancher/shepherd/pkg/session"
 	cli "github.com/urfave/cli/v2"
 )
 
+const ArgRancherOnly = "rancher-only"
+
 func Destroy(cli *cli.Context) error {
 	tf, r, err := prepare(cli)
 	if err != nil {
 		return err
 	}
 
-	clusterStatePath := fmt.Sprintf("%s/%s", r.TofuWorkspaceStatePath, actions.ClustersStateFile)
-	// TODO: Implement a flag to -only- destroy ClusterStatus state + Clusters registered in Rancher
-	err = actions.DestroyClusterState(clusterStatePath)
+	unlock, err := lockClusterState(r)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	targets := cli.StringSlice(ArgTarget)
+	if len(targets) > 0 || cli.Bool(ArgRancherOnly) {
+		return destroySelected(cli.Context, tf, r, targets, cli.Bool(ArgRancherOnly))
+	}
+
+	if err := deleteExternalClusters(cli.Context, tf, r); err != nil {
+		return err
+	}
+
+	err = actions.DestroyClusterState(clusterStatePath(r))
+	if err != nil {
+		return err
+	}
+
+	return tf.Destroy(cli.Context)
+}
+
+// destroySelected deletes the downstream clusters selected by targets, or all of them if there are no targets, from
+// Rancher and the Cluster state file. Then, unless rancherOnly is set, it destroys the targeted tofu resources
+func destroySelected(ctx context.Context, tf *tofu.Tofu, r *dart.Dart, targets []string, rancherOnly bool) error {
+	statePath := clusterStatePath(r)
+
+	statuses, err := actions.LoadClusterState(statePath)
+	if err != nil {
+		return err
+	}
+
+	selection := targetSelection{clusters: slices.Collect(maps.Keys(statuses))}
+	if len(targets) > 0 {
+		if selection, err = resolveTargets(r, statuses, targets); err != nil {
+			return err
+		}
+	}
+
+	if rancherOnly && (slices.Contains(targets, "upstream") || slices.Contains(targets, "tester")) {
+		return fmt.Errorf("--%s only applies to downstream clusters", ArgRancherOnly)
+	}
+
+	// only clusters Rancher knows about need to be deleted from it
+	registered := slices.DeleteFunc(slices.Clone(selection.clusters), func(name string) bool {
+		_, ok := statuses[name]
+		return !ok
+	})
+
+	switch {
+	case selection.upstream && !rancherOnly:
+		// Rancher goes away with the upstream cluster, and all registrations with it. External clusters outlive it,
+		// so their agents are removed first
+		if err := deleteExternalClusters(ctx, tf, r); err != nil {
+			return err
+		}
+
+		if err := actions.DestroyClusterState(statePath); err != nil {
+			return err
+		}
+	case len(registered) > 0:
+		clusters, _, err := tf.ParseOutputs(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := addExternalClusters(r, clusters); err != nil {
+			return err
+		}
+
+		client, err := newRancherClientFor(ctx, r, clusters)
+		if err != nil {
+			return err
+		}
+
+		if !rancherOnly {
+			// no point in waiting for agents to be removed from clusters about to be destroyed, but external
+			// clusters are not destroyed by tofu
+			maps.DeleteFunc(clusters, func(name string, _ tofu.Cluster) bool { return !isExternal(r, name) })
+		}
+
+		if err := actions.DeleteRancherClusters(ctx, r, client, clusters, statePath, registered); err != nil {
+			return err
+		}
+	}
+
+	if rancherOnly || len(selection.addresses) == 0 {
+		return nil
+	}
+
+	return tf.Destroy(ctx, selection.addresses...)
+}
+
+// deleteExternalClusters deletes the dart's external clusters found in the Cluster state file from Rancher, and
+// waits for Rancher to remove its agents from them. It must run before the upstream cluster is destroyed, as external
+// clusters are not destroyed with it and would otherwise keep agents of a Rancher that is gone
+func deleteExternalClusters(ctx context.Context, tf *tofu.Tofu, r *dart.Dart) error {
+	statePath := clusterStatePath(r)
+
+	statuses, err := actions.LoadClusterState(statePath)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+
+	for _, external := range r.ExternalClusters {
+		if _, ok := statuses[external.Name]; ok {
+			names = append(names, external.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	clusters, _, err := tf.ParseOutputs(ctx)
 	if err != nil {
 		return err
 	}
 
-	return tf.Destroy()
+	if err := addExternalClusters(r, clusters); err != nil {
+		return err
+	}
+
+	client, err := newRancherClientFor(ctx, r, clusters)
+	if err != nil {
+		return fmt.Errorf("error while removing external clusters from Rancher, remove them from external_clusters to destroy anyway: %w", err)
+	}
+
+	return actions.DeleteRancherClusters(ctx, r, client, clusters, statePath, names)
+}
+
+// newRancherClientFor logs into the Rancher instance running on the upstream cluster
+func newRancherClientFor(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster) (*rancher.Client, error) {
+	upstream, ok := clusters["upstream"]
+	if !ok {
+		return nil, fmt.Errorf("upstream cluster not found in tofu outputs")
+	}
+
+	rancherConfig, err := rancherConfigFor(ctx, r, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	rancherSession := session.NewSession()
+	rancherSession.CleanupEnabled = false
+
+	client, err := actions.NewRancherClient(&rancherConfig, r.ChartVariables.AdminPassword, rancherSession)
+	if err != nil {
+		return nil, fmt.Errorf("error while logging into Rancher: %w", err)
+	}
+
+	return client, nil
 }
@@ -0,0 +1,95 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/preflight"
+	"github.com/rancher/dartboard/internal/tofu"
+	cli "github.com/urfave/cli/v2"
+)
+
+const ArgSkipPreflight = "skip-preflight"
+
+// Doctor runs the pre-flight checks of the dart's provider and prints their results
+func Doctor(cli *cli.Context) error {
+	r, err := prepareDart(cli)
+	if err != nil {
+		return err
+	}
+
+	return runPreflight(cli.Context, r)
+}
+
+// checkPreflight runs pre-flight checks, unless skipped from the command line
+func checkPreflight(cli *cli.Context, r *dart.Dart) error {
+	if cli.Bool(ArgSkipPreflight) {
+		return nil
+	}
+
+	return runPreflight(cli.Context, r)
+}
+
+// runPreflight prints a pass/warn/fail table of pre-flight checks, returning an error if any failed
+func runPreflight(ctx context.Context, r *dart.Dart) error {
+	applied, err := tofu.HasResources(r.TofuMainDirectory, r.TofuWorkspace)
+	if err != nil {
+		return err
+	}
+
+	defaults, err := tofu.VariableDefaults(r.TofuMainDirectory)
+	if err != nil {
+		return err
+	}
+
+	opts := preflight.Options{
+		Provider:  filepath.Base(r.TofuMainDirectory),
+		Variables: r.TofuVariables,
+		Defaults:  defaults,
+		Applied:   applied,
+	}
+
+	results := preflight.Run(ctx, opts, preflight.Checks(opts))
+
+	fmt.Printf("\n*** PRE-FLIGHT CHECKS (%s)\n", opts.Provider)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tMESSAGE")
+
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Check, strings.ToUpper(string(result.Status)), result.Message)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+
+	if failed := preflight.Count(results, preflight.Fail); failed > 0 {
+		return fmt.Errorf("%d pre-flight checks failed, fix them or skip them with --%s", failed, ArgSkipPreflight)
+	}
+
+	return nil
+}
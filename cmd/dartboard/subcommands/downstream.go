@@ -0,0 +1,236 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync/atomic"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/sirupsen/logrus"
+)
+
+// downstreamCluster is a downstream cluster onboarded to Rancher that charts can be installed on
+type downstreamCluster struct {
+	tofu.Cluster
+	// id is the management Cluster ID, eg. c-m-abcd1234
+	id string
+}
+
+// installDownstreamCharts installs rancher-monitoring, if downstream_rancher_monitoring is set, and after_downstream
+// addons onto downstream clusters, at most cluster_batch_size clusters at a time, within onboarding limits. Clusters
+// created by tofu are reached through their kubeconfigs, clusters Rancher provisioned through Rancher's proxy
+func installDownstreamCharts(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster, rancherClient *rancher.Client) error {
+	monitoring := r.ChartVariables.DownstreamRancherMonitoring
+	if !monitoring && !slices.ContainsFunc(r.Addons, func(a dart.Addon) bool { return a.Stage == dart.AddonAfterDownstream }) {
+		return nil
+	}
+
+	downstreams, cleanup, err := rancherDownstreamClusters(r, clusters, rancherClient)
+	defer cleanup()
+
+	if err != nil {
+		return err
+	}
+
+	// addons can target any cluster, including the ones only Rancher has a kubeconfig for
+	targets := maps.Clone(clusters)
+	for name, downstream := range downstreams {
+		targets[name] = downstream.Cluster
+	}
+
+	addons := map[string][]dart.Addon{}
+
+	for _, addon := range r.Addons {
+		if addon.Stage != dart.AddonAfterDownstream {
+			continue
+		}
+
+		names, err := addonClusters(addon, targets)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			addons[name] = append(addons[name], addon)
+		}
+	}
+
+	var mimirURL string
+
+	if tester, ok := clusters["tester"]; monitoring && ok && len(tester.Kubeconfig) > 0 {
+		testerAdd, err := getAppAddressFor(ctx, tester)
+		if err != nil {
+			return fmt.Errorf("chart %s: %w", chartNameRancherMonitoring, err)
+		}
+
+		mimirURL = testerAdd.Public.HTTPURL + "/mimir/api/v1/push"
+	} else if monitoring {
+		logrus.Warn("No tester cluster, downstream monitoring will not write to Mimir")
+	}
+
+	if monitoring {
+		for name := range downstreams {
+			if _, ok := addons[name]; !ok {
+				addons[name] = nil
+			}
+		}
+	}
+
+	names := slices.Collect(maps.Keys(addons))
+	SortItemsNaturally(names, func(name string) string { return name })
+
+	install := func(name string) error {
+		cluster := targets[name]
+
+		if downstream, ok := downstreams[name]; ok && monitoring {
+			if err := chartInstallDownstreamMonitoring(ctx, r, downstream, mimirURL); err != nil {
+				return err
+			}
+		}
+
+		for _, addon := range addons[name] {
+			if err := installAddonPhase(ctx, r.Airgap, addon, name, &cluster); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var done, failed atomic.Int32
+
+	// with the fail_fast failure policy no cluster is started after the first error, otherwise all errors are returned
+	failFast := r.FailurePolicy.Mode == dart.FailFast
+
+	return grafana.Phase(ctx, shared.Grafana(), "install downstream charts", []string{"charts", "downstream"}, func() error {
+		return shared.Limiter().Each(ctx, r.ClusterBatchSize, len(names), failFast, func(i int) error {
+			err := install(names[i])
+			if err != nil {
+				failed.Add(1)
+				err = fmt.Errorf("cluster %s: %w", names[i], err)
+			}
+
+			logrus.Infof("Installed downstream charts on %d/%d clusters, %d failed", done.Add(1), len(names), failed.Load())
+
+			return err
+		})
+	})
+}
+
+// chartInstallDownstreamMonitoring installs rancher-monitoring and cgroups-exporter onto a downstream cluster,
+// remote-writing metrics to mimirURL
+func chartInstallDownstreamMonitoring(ctx context.Context, r *dart.Dart, downstream downstreamCluster, mimirURL string) error {
+	cluster := &downstream.Cluster
+
+	if err := chartInstallCgroupsExporter(ctx, r.Airgap, cluster); err != nil {
+		return err
+	}
+
+	if err := chartInstallMonitoring(ctx, r, cluster, downstream.id, downstream.Name, mimirURL); err != nil {
+		return err
+	}
+
+	return updateMonitoringProject(ctx, cluster)
+}
+
+// rancherDownstreamClusters returns the downstream clusters in the Cluster state file that are ready in Rancher, by
+// name. Clusters without a kubeconfig from tofu get one generated by Rancher, in a temporary file deleted by the
+// returned function
+func rancherDownstreamClusters(r *dart.Dart, clusters map[string]tofu.Cluster, rancherClient *rancher.Client) (map[string]downstreamCluster, func(), error) {
+	var generated []string
+
+	cleanup := func() {
+		for _, path := range generated {
+			os.Remove(path)
+		}
+	}
+
+	statuses, err := actions.LoadClusterState(clusterStatePath(r))
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	health, err := actions.ListClusterHealth(rancherClient)
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	result := map[string]downstreamCluster{}
+
+	for name, cs := range statuses {
+		// Rancher knows provisioned clusters by the name it generated for them
+		h, ok := health[cs.ProvisioningName()]
+		if !ok {
+			logrus.Warnf("Cluster %s is not known to Rancher, skipping its charts", name)
+			continue
+		}
+
+		if !h.Ready {
+			logrus.Warnf("Cluster %s is not ready, skipping its charts", name)
+			continue
+		}
+
+		cluster := clusters[name]
+		cluster.Name = name
+
+		if len(cluster.Kubeconfig) == 0 {
+			path, err := writeRancherKubeconfig(rancherClient, name, h.ID)
+			if err != nil {
+				return nil, cleanup, err
+			}
+
+			generated = append(generated, path)
+			cluster.Kubeconfig = path
+		}
+
+		result[name] = downstreamCluster{Cluster: cluster, id: h.ID}
+	}
+
+	return result, cleanup, nil
+}
+
+// writeRancherKubeconfig writes a kubeconfig going through Rancher's proxy for the cluster with management ID id
+// to a temporary file, returning its path
+func writeRancherKubeconfig(rancherClient *rancher.Client, name, id string) (string, error) {
+	content, err := actions.GetKubeconfigForClusterID(rancherClient, id)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "kubeconfig-"+name+"-*.yaml")
+	if err != nil {
+		return "", fmt.Errorf("creating kubeconfig file for cluster %s: %w", name, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(content); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing kubeconfig file for cluster %s: %w", name, err)
+	}
+
+	return f.Name(), nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/tofu"
+)
+
+// addExternalClusters adds the dart's external_clusters to clusters parsed from tofu outputs. Each gets a kubeconfig
+// with only its context, written to the workspace directory, so that helm and kubectl use the right cluster
+func addExternalClusters(r *dart.Dart, clusters map[string]tofu.Cluster) error {
+	for _, external := range r.ExternalClusters {
+		if _, ok := clusters[external.Name]; ok {
+			return fmt.Errorf("external cluster %s has the same name as a cluster created by tofu", external.Name)
+		}
+
+		if err := os.MkdirAll(r.TofuWorkspaceStatePath, 0o755); err != nil {
+			return fmt.Errorf("failed to create workspace directory: %w", err)
+		}
+
+		path := filepath.Join(r.TofuWorkspaceStatePath, "external-"+external.Name+".yaml")
+		kubeContext, err := actions.WriteContextKubeconfig(external.Kubeconfig, external.Context, path)
+		if err != nil {
+			return fmt.Errorf("external cluster %s: %w", external.Name, err)
+		}
+
+		clusters[external.Name] = tofu.Cluster{
+			Name:       external.Name,
+			Kubeconfig: path,
+			Context:    kubeContext,
+		}
+	}
+
+	return nil
+}
+
+// isDownstream returns true if name is a downstream cluster to import, created by tofu or external
+func isDownstream(r *dart.Dart, name string) bool {
+	return strings.HasPrefix(name, "downstream") || isExternal(r, name)
+}
+
+// isExternal returns true if name is one of the dart's external_clusters
+func isExternal(r *dart.Dart, name string) bool {
+	return slices.ContainsFunc(r.ExternalClusters, func(c dart.ExternalCluster) bool { return c.Name == name })
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/shepherd/pkg/session"
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Fixtures creates the users, projects and role bindings of the dart's fixtures section through the Rancher API.
+// Objects that already exist are kept, so it can be run again after changing counts
+func Fixtures(cli *cli.Context) error {
+	tf, r, err := prepare(cli)
+	if err != nil {
+		return err
+	}
+
+	ctx := cli.Context
+
+	f := r.Fixtures
+	if f.Users == 0 && f.ProjectsPerCluster == 0 {
+		return errors.New("the dart's fixtures section has no users nor projects")
+	}
+
+	clusters, _, err := tf.ParseOutputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	setupGrafana(ctx, r, clusters)
+
+	rancherConfig, err := rancherConfigFor(ctx, r, clusters["upstream"])
+	if err != nil {
+		return err
+	}
+
+	rancherSession := session.NewSession()
+	rancherSession.CleanupEnabled = false
+
+	rancherClient, err := actions.NewRancherClient(&rancherConfig, r.ChartVariables.AdminPassword, rancherSession)
+	if err != nil {
+		return fmt.Errorf("error while logging into Rancher: %w", err)
+	}
+
+	var summaries []actions.FixtureSummary
+
+	err = grafana.Phase(ctx, shared.Grafana(), "create fixtures", []string{"fixtures"}, func() error {
+		summaries, err = actions.CreateFixtures(ctx, r, rancherClient)
+		return err
+	})
+
+	if summaryErr := printFixtureSummaries(summaries); summaryErr != nil {
+		logrus.Errorf("could not summarize fixtures: %v", summaryErr)
+	}
+
+	return err
+}
+
+// printFixtureSummaries prints how many objects of each kind were created and found
+func printFixtureSummaries(summaries []actions.FixtureSummary) error {
+	fmt.Println("\n*** FIXTURES")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tCREATED\tEXISTING")
+
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", s.Kind, s.Created, s.Existing)
+	}
+
+	return w.Flush()
+}
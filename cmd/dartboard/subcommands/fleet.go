@@ -0,0 +1,204 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/airgap"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/fleet"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/kubectl"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+	yaml "gopkg.in/yaml.v3"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	ArgSource     = "source"
+	ArgBundles    = "bundles"
+	ArgConfigMaps = "configmaps"
+	ArgKeep       = "keep"
+
+	chartNameGitServer = "git-server"
+
+	gitServerRolloutMinutes = 10
+)
+
+// Fleet distributes GitRepos or Bundles to downstream clusters through Fleet and reports how long clusters take to
+// have them ready
+func Fleet(cli *cli.Context) error {
+	tf, r, err := prepare(cli)
+	if err != nil {
+		return err
+	}
+
+	ctx := cli.Context
+
+	clusters, _, err := tf.ParseOutputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	setupGrafana(ctx, r, clusters)
+
+	restConfig, err := actions.GetRESTConfigFromPath(clusters["upstream"].Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	names, err := fleetClusters(ctx, cli, r, restConfig)
+	if err != nil {
+		return err
+	}
+
+	b := fleet.Benchmark{
+		Name:       "fleet-bench-" + time.Now().Format("0102-150405"),
+		Source:     cli.String(ArgSource),
+		Bundles:    cli.Int(ArgBundles),
+		ConfigMaps: cli.Int(ArgConfigMaps),
+		Clusters:   names,
+		Timeout:    cli.Duration(ArgTimeout),
+		Keep:       cli.Bool(ArgKeep),
+	}
+
+	if b.Source == fleet.SourceGitRepo {
+		if b.GitURL, err = installGitServer(ctx, r.Airgap, clusters, b); err != nil {
+			return err
+		}
+	}
+
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	var report *fleet.Report
+
+	text := fmt.Sprintf("fleet %s benchmark: %d bundles to %d clusters", b.Source, b.Bundles, len(b.Clusters))
+
+	err = grafana.Phase(ctx, shared.Grafana(), text, []string{"fleet"}, func() error {
+		report, err = fleet.Run(ctx, restConfig, b)
+		return err
+	})
+
+	if report != nil {
+		if reportErr := saveFleetReport(r, report); reportErr != nil {
+			logrus.Errorf("could not save fleet report: %v", reportErr)
+		}
+	}
+
+	return err
+}
+
+// fleetClusters returns the names of ready Fleet clusters selected by --target and --count
+func fleetClusters(ctx context.Context, cli *cli.Context, r *dart.Dart, restConfig *rest.Config) ([]string, error) {
+	names, err := fleet.ReadyClusters(ctx, restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if targets := cli.StringSlice(ArgTarget); len(targets) > 0 {
+		statuses, err := actions.LoadClusterState(clusterStatePath(r))
+		if err != nil {
+			return nil, err
+		}
+
+		selection, err := resolveTargets(r, statuses, targets)
+		if err != nil {
+			return nil, err
+		}
+
+		names = slices.DeleteFunc(names, func(name string) bool { return !slices.Contains(selection.clusters, name) })
+	}
+
+	SortItemsNaturally(names, func(name string) string { return name })
+
+	if count := cli.Int(ArgCount); count > 0 && count < len(names) {
+		names = names[:count]
+	}
+
+	return names, nil
+}
+
+// installGitServer installs the git server on the tester cluster with the benchmark's repositories, returning the
+// base URL of the repositories as seen from the upstream cluster
+func installGitServer(ctx context.Context, cfg airgap.Config, clusters map[string]tofu.Cluster, b fleet.Benchmark) (string, error) {
+	tester, ok := clusters["tester"]
+	if !ok || len(tester.Kubeconfig) == 0 {
+		return "", fmt.Errorf("--%s %s requires a tester cluster to run the git server", ArgSource, fleet.SourceGitRepo)
+	}
+
+	vals := getLocalChartValsJSON(cfg)
+	vals["repos"] = b.Bundles
+	vals["configMaps"] = b.ConfigMaps
+
+	if err := chartInstall(ctx, cfg, tester.Kubeconfig, chart{chartNameGitServer, nsTester, chartNameGitServer}, vals); err != nil {
+		return "", err
+	}
+
+	if err := kubectl.RolloutStatus(ctx, tester.Kubeconfig, "deployment", chartNameGitServer, nsTester, gitServerRolloutMinutes); err != nil {
+		return "", err
+	}
+
+	testerAdd, err := getAppAddressFor(ctx, tester)
+	if err != nil {
+		return "", err
+	}
+
+	return testerAdd.Public.HTTPURL + "/git", nil
+}
+
+// saveFleetReport prints the report and saves it next to the Cluster state file
+func saveFleetReport(r *dart.Dart, report *fleet.Report) error {
+	fmt.Printf("\n*** FLEET %s BENCHMARK: %d bundles of %d ConfigMaps to %d clusters\n", report.Source, report.Bundles,
+		report.ConfigMaps, len(report.Clusters))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "P50\tP90\tP95\tP99\tMAX\tNOT READY")
+
+	l := report.Latency
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", l.P50, l.P90, l.P95, l.P99, l.Max, report.NotReady)
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fleet report: %w", err)
+	}
+
+	path := filepath.Join(r.TofuWorkspaceStatePath, report.Name+".yaml")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write fleet report: %w", err)
+	}
+
+	fmt.Printf("Per-cluster timings saved to %s\n\n", path)
+
+	return nil
+}
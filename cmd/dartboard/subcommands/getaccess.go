@@ -30,23 +30,27 @@ func GetAccess(cli *cli.Context) error {
 		return err
 	}
 
-	clusters, _, err := tf.ParseOutputs()
+	clusters, _, err := tf.ParseOutputs(cli.Context)
 	if err != nil {
 		return err
 	}
 
+	if err = addExternalClusters(r, clusters); err != nil {
+		return err
+	}
+
 	upstream := clusters["upstream"]
 	tester := clusters["tester"]
 
 	downstreams := make(map[string]tofu.Cluster)
 
 	for k, v := range clusters {
-		if strings.HasPrefix(k, "downstream") {
+		if isDownstream(r, k) {
 			downstreams[k] = v
 		}
 	}
 
-	upstreamAddresses, err := getAppAddressFor(upstream)
+	upstreamAddresses, err := getAppAddressFor(cli.Context, upstream)
 
 	rancherURL := ""
 	if err == nil {
@@ -17,14 +17,18 @@ limitations under the License.
 package subcommands
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/grafana"
 	"github.com/rancher/dartboard/internal/kubectl"
+	"github.com/rancher/dartboard/internal/shared"
 	"github.com/rancher/dartboard/internal/tofu"
 	cli "github.com/urfave/cli/v2"
 )
@@ -47,17 +51,35 @@ func Load(cli *cli.Context) error {
 		return err
 	}
 
-	clusters, _, err := tf.ParseOutputs()
+	ctx := cli.Context
+
+	clusters, _, err := tf.ParseOutputs(ctx)
 	if err != nil {
 		return err
 	}
 
+	setupGrafana(ctx, r, clusters)
+
+	start := time.Now()
+	defer func() {
+		grafana.Annotate(ctx, shared.Grafana(), grafana.Annotation{Time: start, End: time.Now(), Tags: []string{"phase", "load"}, Text: "load"})
+	}()
+
 	// Refresh k6 files
 	tester := clusters["tester"]
-	if err := chartInstall(tester.Kubeconfig, chart{"k6-files", "tester", "k6-files"}, nil); err != nil {
+	if err := chartInstall(ctx, r.Airgap, tester.Kubeconfig, chart{"k6-files", "tester", "k6-files"}, nil); err != nil {
 		return err
 	}
 
+	// failures scheduled after the last k6 run are not injected
+	if schedule := startChaos(ctx, r, clusters); schedule != nil {
+		defer func() {
+			if err := schedule.Stop(); err != nil {
+				log.Printf("WARNING: chaos events failed: %v\n", err)
+			}
+		}()
+	}
+
 	var thresholdsCrossed bool
 
 	// Create ConfigMaps and Secrets on Rancher and all the downstream clusters
@@ -67,7 +89,7 @@ func Load(cli *cli.Context) error {
 			continue
 		}
 
-		if err := loadConfigMapAndSecrets(r, tester.Kubeconfig, clusterName, clusterData); err != nil {
+		if err := loadConfigMapAndSecrets(ctx, r, tester.Kubeconfig, clusterName, clusterData); err != nil {
 			if errors.Is(err, kubectl.ErrK6ThresholdsCrossed) {
 				thresholdsCrossed = true
 			} else {
@@ -77,7 +99,7 @@ func Load(cli *cli.Context) error {
 	}
 
 	// Create Users and Roles
-	if err := loadRolesAndUsers(r, tester.Kubeconfig, "upstream", clusters["upstream"]); err != nil {
+	if err := loadRolesAndUsers(ctx, r, tester.Kubeconfig, "upstream", clusters["upstream"]); err != nil {
 		if errors.Is(err, kubectl.ErrK6ThresholdsCrossed) {
 			thresholdsCrossed = true
 		} else {
@@ -85,7 +107,7 @@ func Load(cli *cli.Context) error {
 		}
 	}
 	// Create Projects
-	if err := loadProjects(r, tester.Kubeconfig, "upstream", clusters["upstream"]); err != nil {
+	if err := loadProjects(ctx, r, tester.Kubeconfig, "upstream", clusters["upstream"]); err != nil {
 		if errors.Is(err, kubectl.ErrK6ThresholdsCrossed) {
 			thresholdsCrossed = true
 		} else {
@@ -103,7 +125,7 @@ func Load(cli *cli.Context) error {
 	return nil
 }
 
-func loadConfigMapAndSecrets(r *dart.Dart, kubeconfig string, clusterName string, clusterData tofu.Cluster) error {
+func loadConfigMapAndSecrets(ctx context.Context, r *dart.Dart, kubeconfig string, clusterName string, clusterData tofu.Cluster) error {
 	configMapCount := strconv.Itoa(r.TestVariables.TestConfigMaps)
 	secretCount := strconv.Itoa(r.TestVariables.TestSecrets)
 
@@ -123,18 +145,18 @@ func loadConfigMapAndSecrets(r *dart.Dart, kubeconfig string, clusterName string
 
 	log.Printf("Load resources on cluster %q (#ConfigMaps: %s, #Secrets: %s)\n", clusterName, configMapCount, secretCount)
 
-	if err := kubectl.K6run(kubeconfig, "generic/create_k8s_resources.js", envVars, tags, true, clusterData.KubernetesAddresses.Tunnel, false); err != nil {
+	if err := kubectl.K6run(ctx, kubeconfig, r.Airgap.Image(kubectl.K6Image), "generic/create_k8s_resources.js", envVars, tags, true, clusterData.KubernetesAddresses.Tunnel, false); err != nil {
 		return fmt.Errorf("failed loading ConfigMaps and Secrets on cluster %q: %w", clusterName, err)
 	}
 
 	return nil
 }
 
-func loadRolesAndUsers(r *dart.Dart, kubeconfig string, clusterName string, clusterData tofu.Cluster) error {
+func loadRolesAndUsers(ctx context.Context, r *dart.Dart, kubeconfig string, clusterName string, clusterData tofu.Cluster) error {
 	roleCount := strconv.Itoa(r.TestVariables.TestRoles)
 	userCount := strconv.Itoa(r.TestVariables.TestUsers)
 
-	clusterAdd, err := getAppAddressFor(clusterData)
+	clusterAdd, err := getAppAddressFor(ctx, clusterData)
 	if err != nil {
 		return fmt.Errorf("failed loading Roles and Users on cluster %q: %w", clusterName, err)
 	}
@@ -156,17 +178,17 @@ func loadRolesAndUsers(r *dart.Dart, kubeconfig string, clusterName string, clus
 
 	log.Printf("Load resources on cluster %q (#Roles: %s, #Users: %s)\n", clusterName, roleCount, userCount)
 
-	if err := kubectl.K6run(kubeconfig, "generic/create_roles_users.js", envVars, tags, true, clusterAdd.Local.HTTPSURL, false); err != nil {
+	if err := kubectl.K6run(ctx, kubeconfig, r.Airgap.Image(kubectl.K6Image), "generic/create_roles_users.js", envVars, tags, true, clusterAdd.Local.HTTPSURL, false); err != nil {
 		return fmt.Errorf("failed loading Roles and Users on cluster %q: %w", clusterName, err)
 	}
 
 	return nil
 }
 
-func loadProjects(r *dart.Dart, kubeconfig string, clusterName string, clusterData tofu.Cluster) error {
+func loadProjects(ctx context.Context, r *dart.Dart, kubeconfig string, clusterName string, clusterData tofu.Cluster) error {
 	projectCount := strconv.Itoa(r.TestVariables.TestProjects)
 
-	clusterAdd, err := getAppAddressFor(clusterData)
+	clusterAdd, err := getAppAddressFor(ctx, clusterData)
 	if err != nil {
 		return fmt.Errorf("failed loading Projects on cluster %q: %w", clusterName, err)
 	}
@@ -185,7 +207,7 @@ func loadProjects(r *dart.Dart, kubeconfig string, clusterName string, clusterDa
 
 	log.Printf("Load resources on cluster %q (#Projects: %s)\n", clusterName, projectCount)
 
-	if err := kubectl.K6run(kubeconfig, "generic/create_projects.js", envVars, tags, true, clusterAdd.Local.HTTPSURL, false); err != nil {
+	if err := kubectl.K6run(ctx, kubeconfig, r.Airgap.Image(kubectl.K6Image), "generic/create_projects.js", envVars, tags, true, clusterAdd.Local.HTTPSURL, false); err != nil {
 		return fmt.Errorf("failed loading Projects on cluster %q: %w", clusterName, err)
 	}
 
@@ -0,0 +1,121 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/dartboard/internal/airgap"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/kubectl"
+	"github.com/rancher/dartboard/internal/vendored"
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+)
+
+// templatedChart is a chart rendered to find the images it needs
+type templatedChart struct {
+	chart chart
+	vals  map[string]any
+}
+
+// Mirror downloads the dart's charts to the air-gapped cache and pushes all images they need to the air-gapped registry
+func Mirror(cli *cli.Context) error {
+	r, err := prepareDart(cli)
+	if err != nil {
+		return err
+	}
+
+	if err := vendored.ExtractBinaries(); err != nil {
+		return err
+	}
+
+	cfg := r.Airgap
+	if !cfg.Enabled() {
+		return errors.New("no air-gapped registry configured, see the airgap section of darts/k3d.yaml")
+	}
+
+	images, err := mirroredImages(cli.Context, r, cfg)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Mirroring images to %s", cfg.Registry)
+
+	return cfg.Push(cli.Context, images)
+}
+
+// mirroredImages downloads remote charts to the cache and returns the images of Rancher, of all charts and of k6
+func mirroredImages(ctx context.Context, r *dart.Dart, cfg airgap.Config) ([]string, error) {
+	// Rancher's list comes first, so its Prime images win over the same images rendered from charts
+	images, err := cfg.RancherImages(ctx, r.ChartVariables.RancherVersion, r.ChartVariables.ForcePrimeRegistry)
+	if err != nil {
+		return nil, err
+	}
+
+	images = append(images, kubectl.K6Image)
+
+	monitoringCRD, monitoring := rancherMonitoringCharts(r)
+
+	// values only matter as far as they change which images are rendered
+	templated := []templatedChart{
+		{certManagerChart(r), getCertManagerValsJSON(airgap.Config{})},
+		{rancherChart(r), getRancherValsJSON(r.ChartVariables.RancherImageOverride, defaultRancherImageTag(r), "", "rancher.invalid", nil, 1, "")},
+		{monitoringCRD, nil},
+		{monitoring, getRancherMonitoringValsJSON(false, "", "", "local", "local")},
+		{grafanaChart(r), nil},
+		{chart{chartNameMimir, nsTester, chartNameMimir}, nil},
+		{chart{chartNameCgroupsExporter, nsCattleMonitoringSystem, chartNameCgroupsExporter}, nil},
+		{chart{chartNameGitServer, nsTester, chartNameGitServer}, nil},
+		{chart{chartNameOpenLDAP, nsTester, chartNameOpenLDAP}, nil},
+		{chart{chartNameKeycloak, nsTester, chartNameKeycloak}, nil},
+	}
+
+	for _, addon := range r.Addons {
+		if addon.Repo != "" || strings.HasPrefix(addon.Chart, "oci://") {
+			logrus.Warnf("addon %s: only .tgz URL and charts/ addons are mirrored, it will not install without internet access", addon.Name)
+			continue
+		}
+
+		templated = append(templated, templatedChart{chart{addon.Name, addon.Namespace, addon.Chart}, addon.Values})
+	}
+
+	for _, t := range templated {
+		path := filepath.Join("charts", t.chart.path)
+
+		if strings.HasPrefix(t.chart.path, "http") {
+			if path, err = cfg.Fetch(ctx, t.chart.path); err != nil {
+				return nil, fmt.Errorf("chart %s: %w", t.chart.name, err)
+			}
+		}
+
+		chartImages, err := airgap.ChartImages(ctx, path, t.chart.name, t.chart.namespace, t.vals)
+		if err != nil {
+			// images of Rancher's own charts are in its list anyway
+			logrus.Warnf("chart %s: could not list images: %v", t.chart.name, err)
+			continue
+		}
+
+		images = append(images, chartImages...)
+	}
+
+	return images, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/kubectl"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/rancher/shepherd/clients/rancher"
+)
+
+const (
+	chartNameOpenLDAP = "openldap"
+	chartNameKeycloak = "keycloak"
+
+	authProviderRolloutMinutes = 10
+)
+
+// applyRancherConfig deploys the auth provider of rancher_config onto the tester cluster, if any, then applies
+// rancher_config to Rancher
+func applyRancherConfig(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster, rancherClient *rancher.Client,
+	rancherConfig *rancher.Config,
+) error {
+	cfg := r.RancherConfig
+	if len(cfg.Settings) == 0 && len(cfg.Features) == 0 && len(cfg.GlobalRoles) == 0 && cfg.AuthProvider == nil {
+		return nil
+	}
+
+	return grafana.Phase(ctx, shared.Grafana(), "apply rancher config", []string{"rancher-config"}, func() error {
+		var server actions.AuthServer
+
+		if cfg.AuthProvider != nil {
+			var err error
+			if server, err = installAuthProvider(ctx, r, clusters, *cfg.AuthProvider); err != nil {
+				return err
+			}
+
+			server.RancherURL = "https://" + rancherConfig.Host
+		}
+
+		return actions.ApplyRancherConfig(ctx, rancherClient, cfg, server)
+	})
+}
+
+// installAuthProvider installs OpenLDAP or Keycloak on the tester cluster, seeded with the provider's users and
+// groups, returning where the upstream cluster can reach it
+func installAuthProvider(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster, provider dart.AuthProvider) (actions.AuthServer, error) {
+	tester, ok := clusters["tester"]
+	if !ok || len(tester.Kubeconfig) == 0 {
+		return actions.AuthServer{}, errors.New("rancher_config: auth_provider requires a tester cluster to run on")
+	}
+
+	testerAdd, err := getAppAddressFor(ctx, tester)
+	if err != nil {
+		return actions.AuthServer{}, err
+	}
+
+	vals := getLocalChartValsJSON(r.Airgap)
+	vals["users"] = provider.Users
+	vals["groups"] = provider.Groups
+	vals["userPassword"] = r.ChartVariables.UserPassword
+	vals["adminPassword"] = r.ChartVariables.AdminPassword
+
+	name := chartNameOpenLDAP
+	// LDAP is not HTTP, the openldap chart exposes a LoadBalancer Service on the tester's cluster network address
+	server := actions.AuthServer{Address: testerAdd.Public.Name, Secret: r.ChartVariables.AdminPassword}
+
+	if provider.Type == dart.AuthKeycloak {
+		name = chartNameKeycloak
+		// Keycloak issues tokens for the URL it is reached at, Rancher and browsers must both use it
+		server.Address = testerAdd.Public.HTTPURL + "/keycloak"
+		vals["hostname"] = server.Address
+		vals["clientSecret"] = server.Secret
+	}
+
+	if err := chartInstall(ctx, r.Airgap, tester.Kubeconfig, chart{name, nsTester, name}, vals); err != nil {
+		return actions.AuthServer{}, err
+	}
+
+	if err := kubectl.RolloutStatus(ctx, tester.Kubeconfig, "deployment", name, nsTester, authProviderRolloutMinutes); err != nil {
+		return actions.AuthServer{}, err
+	}
+
+	return server, nil
+}
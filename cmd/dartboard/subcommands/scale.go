@@ -0,0 +1,190 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/rancher/shepherd/pkg/session"
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Scale brings the number of downstream clusters in line with cluster_count values in the dart, without touching
+// other clusters. Removed tofu clusters are the highest-numbered ones and removed Rancher-provisioned clusters the
+// newest ones, added ones get names not in use yet
+func Scale(cli *cli.Context) error {
+	tf, r, err := prepare(cli)
+	if err != nil {
+		return err
+	}
+
+	ctx := cli.Context
+
+	unlock, err := lockClusterState(r)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	clusters, customClusters, err := tf.ParseOutputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	setupGrafana(ctx, r, clusters)
+
+	before, err := tofu.AppliedDownstreamClusters(r.TofuVariables, clusters, customClusters)
+	if err != nil {
+		return err
+	}
+
+	after, err := tofu.DownstreamClusters(r.TofuVariables)
+	if err != nil {
+		return err
+	}
+
+	statePath := clusterStatePath(r)
+
+	statuses, err := actions.LoadClusterState(statePath)
+	if err != nil {
+		return err
+	}
+
+	removed, removedAddresses, added := scaleDelta(r, before, after, statuses)
+
+	if len(removed) == 0 && len(added) == 0 {
+		fmt.Println("Downstream cluster counts already match the dart, nothing to scale")
+		return nil
+	}
+
+	logrus.Infof("Scaling downstream clusters: removing %v, adding %v", removed, added)
+
+	rancherConfig, err := rancherConfigFor(ctx, r, clusters["upstream"])
+	if err != nil {
+		return err
+	}
+
+	rancherSession := session.NewSession()
+	rancherSession.CleanupEnabled = false
+
+	rancherClient, err := actions.SetupRancherClient(ctx, &rancherConfig, r.ChartVariables.AdminPassword, rancherSession)
+	if err != nil {
+		return err
+	}
+
+	registered := slices.DeleteFunc(slices.Clone(removed), func(name string) bool {
+		_, ok := statuses[name]
+		return !ok
+	})
+
+	if len(registered) > 0 {
+		// infrastructure is destroyed right after, no need to wait for agents to be removed
+		if err := actions.DeleteRancherClusters(ctx, r, rancherClient, nil, statePath, registered); err != nil {
+			return err
+		}
+	}
+
+	if len(removedAddresses) > 0 {
+		if err := tf.Destroy(ctx, removedAddresses...); err != nil {
+			return err
+		}
+	}
+
+	// clusters are count-indexed in tofu, renumber the remaining ones so that tofu does not recreate them
+	moves := tofu.StateMoves(before, after)
+	if len(moves) > 0 {
+		logrus.Warnf("Renumbering %d tofu resources: local ports of the affected clusters change", len(moves))
+	}
+
+	for _, move := range moves {
+		if err := tf.MoveState(ctx, move); err != nil {
+			return err
+		}
+	}
+
+	if err := tf.Apply(ctx, false); err != nil {
+		return err
+	}
+
+	clusters, customClusters, err = tf.ParseOutputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	// already onboarded clusters are skipped
+	err = deployDownstreamClusters(ctx, r, clusters, customClusters, rancherClient, &rancherConfig)
+
+	failed, summaryErr := printFailedClusters(r)
+	if summaryErr != nil {
+		logrus.Errorf("could not summarize failed clusters: %v", summaryErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d downstream clusters failed, run `dartboard deploy --%s` to retry them", failed, ArgRetryFailed)
+	}
+
+	return nil
+}
+
+// scaleDelta returns names of downstream clusters to remove and add, and addresses of tofu resources to destroy
+func scaleDelta(r *dart.Dart, before, after []tofu.DownstreamCluster, statuses map[string]*actions.ClusterStatus,
+) (removed, removedAddresses, added []string) {
+	// downstream clusters created by tofu
+	for _, cluster := range before {
+		if !slices.ContainsFunc(after, func(c tofu.DownstreamCluster) bool { return c.Name == cluster.Name }) {
+			removed = append(removed, cluster.Name)
+			removedAddresses = append(removedAddresses, cluster.Addresses...)
+		}
+	}
+
+	for _, cluster := range after {
+		if !slices.ContainsFunc(before, func(c tofu.DownstreamCluster) bool { return c.Name == cluster.Name }) {
+			added = append(added, cluster.Name)
+		}
+	}
+
+	// downstream clusters provisioned by Rancher, by the names recorded in statuses so that cluster_batch_size can change
+	for _, template := range r.ClusterTemplates {
+		wanted := actions.ProvisionedClusterNames(r, template, statuses)
+
+		for _, name := range actions.RecordedProvisionedClusterNames(template, statuses) {
+			if !slices.Contains(wanted, name) {
+				removed = append(removed, name)
+			}
+		}
+
+		for _, name := range wanted {
+			if _, ok := statuses[name]; !ok {
+				added = append(added, name)
+			}
+		}
+	}
+
+	SortItemsNaturally(removed, func(name string) string { return name })
+	SortItemsNaturally(added, func(name string) string { return name })
+
+	return removed, removedAddresses, added
+}
@@ -0,0 +1,302 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/rancher/shepherd/pkg/session"
+	cli "github.com/urfave/cli/v2"
+)
+
+const (
+	ArgWatch  = "watch"
+	ArgOutput = "output"
+
+	outputTable = "table"
+	outputJSON  = "json"
+
+	statusWatchInterval = 10 * time.Second
+)
+
+// environmentStatus is the combined state of tofu outputs, the Cluster state file and Rancher
+type environmentStatus struct {
+	Time       time.Time          `json:"time"`
+	RancherURL string             `json:"rancherUrl,omitempty"`
+	RancherErr string             `json:"rancherError,omitempty"`
+	TofuErr    string             `json:"tofuError,omitempty"`
+	Clusters   []clusterStatusRow `json:"clusters"`
+}
+
+type clusterStatusRow struct {
+	Rancher  *actions.ClusterHealth `json:"rancher,omitempty"`
+	Name     string                 `json:"name"`
+	Role     string                 `json:"role"`
+	Stage    string                 `json:"stage,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Attempts int                    `json:"attempts,omitempty"`
+	Failed   bool                   `json:"failed,omitempty"`
+	// InTofu is true if the cluster infrastructure is in the tofu outputs
+	InTofu bool `json:"inTofu"`
+}
+
+func Status(cli *cli.Context) error {
+	output := cli.String(ArgOutput)
+	if output != outputTable && output != outputJSON {
+		return fmt.Errorf("unsupported --%s %q, use %q or %q", ArgOutput, output, outputTable, outputJSON)
+	}
+
+	// keep stdout clean for scripts: messages from tofu and other tools go to stderr instead
+	var progress io.Writer = os.Stdout
+	if output == outputJSON {
+		progress = os.Stderr
+	}
+
+	tf, r, err := prepareTo(cli, progress)
+	if err != nil {
+		return err
+	}
+
+	ctx := cli.Context
+
+	var (
+		clusters      map[string]tofu.Cluster
+		outputsRead   bool
+		rancherClient *rancher.Client
+		rancherURL    string
+	)
+
+	for {
+		status := environmentStatus{Time: time.Now()}
+
+		// re-read outputs on every refresh, as clusters may be added or removed while watching. If tofu is busy,
+		// eg. applying in another terminal, the last outputs read are shown instead
+		latest, _, err := tf.ParseOutputs(ctx)
+
+		switch {
+		case err == nil:
+			clusters, outputsRead = latest, true
+		case !outputsRead:
+			return err
+		default:
+			status.TofuErr = err.Error()
+		}
+
+		// (re)connect to Rancher on every refresh until it succeeds
+		if rancherClient == nil {
+			rancherClient, rancherURL, err = newStatusRancherClient(ctx, r, clusters["upstream"])
+			if err != nil {
+				status.RancherErr = err.Error()
+			}
+		}
+
+		status.RancherURL = rancherURL
+
+		if err := collectStatus(r, clusters, rancherClient, &status); err != nil {
+			return err
+		}
+
+		if status.RancherErr != "" {
+			rancherClient = nil
+		}
+
+		if cli.Bool(ArgWatch) && output == outputTable {
+			// clear the terminal
+			fmt.Print("\033[H\033[2J")
+		}
+
+		if err := printStatus(os.Stdout, output, &status); err != nil {
+			return err
+		}
+
+		if !cli.Bool(ArgWatch) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(statusWatchInterval):
+		}
+	}
+}
+
+// newStatusRancherClient logs into Rancher without retrying, as status is expected to work while Rancher is down.
+// It returns the client and Rancher's URL
+func newStatusRancherClient(ctx context.Context, r *dart.Dart, upstream tofu.Cluster) (*rancher.Client, string, error) {
+	if len(upstream.Kubeconfig) == 0 {
+		return nil, "", fmt.Errorf("upstream cluster not found in tofu outputs")
+	}
+
+	rancherConfig, err := rancherConfigFor(ctx, r, upstream)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rancherURL := "https://" + rancherConfig.Host
+
+	rancherSession := session.NewSession()
+	rancherSession.CleanupEnabled = false
+
+	client, err := actions.NewRancherClient(&rancherConfig, r.ChartVariables.AdminPassword, rancherSession)
+
+	return client, rancherURL, err
+}
+
+// collectStatus fills status with a row per cluster found in tofu outputs, the Cluster state file or Rancher
+func collectStatus(r *dart.Dart, clusters map[string]tofu.Cluster, rancherClient *rancher.Client, status *environmentStatus) error {
+	rows := map[string]*clusterStatusRow{}
+
+	row := func(name string) *clusterStatusRow {
+		if rows[name] == nil {
+			rows[name] = &clusterStatusRow{Name: name, Role: "downstream"}
+		}
+
+		return rows[name]
+	}
+
+	for name := range clusters {
+		row(name).InTofu = true
+	}
+
+	for _, role := range []string{"upstream", "tester"} {
+		if _, ok := rows[role]; ok {
+			rows[role].Role = role
+		}
+	}
+
+	// Rancher names provisioned clusters differently than the state file, see ClusterStatus.ProvisioningName
+	rancherNames := map[string]string{"local": "upstream"}
+
+	if _, err := os.Stat(clusterStatePath(r)); err == nil {
+		statuses, err := actions.LoadClusterState(clusterStatePath(r))
+		if err != nil {
+			return err
+		}
+
+		for name, cs := range statuses {
+			entry := row(name)
+			entry.Stage = cs.Stage.String()
+			entry.Failed = cs.Failed
+			entry.Error = strings.Join(strings.Fields(cs.Error), " ")
+			entry.Attempts = cs.Attempts
+
+			rancherNames[cs.ProvisioningName()] = name
+		}
+	}
+
+	if rancherClient != nil {
+		health, err := actions.ListClusterHealth(rancherClient)
+		if err != nil {
+			status.RancherErr = err.Error()
+		}
+
+		for name, h := range health {
+			if stateName, ok := rancherNames[name]; ok {
+				name = stateName
+			}
+
+			row(name).Rancher = &h
+		}
+	}
+
+	for _, entry := range rows {
+		status.Clusters = append(status.Clusters, *entry)
+	}
+
+	roleOrder := map[string]int{"upstream": 0, "tester": 1, "downstream": 2}
+
+	slices.SortFunc(status.Clusters, func(a, b clusterStatusRow) int {
+		if roleOrder[a.Role] != roleOrder[b.Role] {
+			return roleOrder[a.Role] - roleOrder[b.Role]
+		}
+
+		if naturalCompare(a.Name, b.Name) {
+			return -1
+		}
+
+		return 1
+	})
+
+	return nil
+}
+
+// printStatus writes status to w in the given output format
+func printStatus(w io.Writer, output string, status *environmentStatus) error {
+	if output == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(status)
+	}
+
+	fmt.Fprintf(w, "Status at %s\n", status.Time.Format(time.RFC3339))
+
+	if status.TofuErr != "" {
+		fmt.Fprintf(w, "Tofu outputs could not be refreshed, showing the last ones read: %s\n", status.TofuErr)
+	}
+
+	if status.RancherErr != "" {
+		fmt.Fprintf(w, "Rancher %s: unreachable: %s\n\n", status.RancherURL, status.RancherErr)
+	} else {
+		fmt.Fprintf(w, "Rancher %s: reachable\n\n", status.RancherURL)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tROLE\tTOFU\tSTAGE\tATTEMPTS\tRANCHER ID\tREADY\tCONNECTED\tNODES\tPROBLEMS")
+
+	for _, row := range status.Clusters {
+		id, ready, connected, nodes := "-", "-", "-", "-"
+
+		var problems []string
+
+		if row.Rancher != nil {
+			id = row.Rancher.ID
+			ready = strconv.FormatBool(row.Rancher.Ready)
+			connected = strconv.FormatBool(row.Rancher.Connected)
+			nodes = strconv.FormatInt(row.Rancher.Nodes, 10)
+			problems = append(problems, row.Rancher.Conditions...)
+		}
+
+		if row.Failed {
+			problems = append(problems, "last attempt failed: "+row.Error)
+		}
+
+		stage := row.Stage
+		if stage == "" {
+			stage = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			row.Name, row.Role, row.InTofu, stage, row.Attempts, id, ready, connected, nodes, strings.Join(problems, "; "))
+	}
+
+	return tw.Flush()
+}
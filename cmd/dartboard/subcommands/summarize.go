@@ -90,7 +90,7 @@ func Summarize(cli *cli.Context) error {
 		return err
 	}
 
-	clusters, _, err := tf.ParseOutputs()
+	clusters, _, err := tf.ParseOutputs(cli.Context)
 	if err != nil {
 		return err
 	}
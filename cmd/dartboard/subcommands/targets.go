@@ -0,0 +1,111 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/tofu"
+)
+
+const (
+	ArgTarget = "target"
+
+	targetTemplatePrefix = "template="
+)
+
+// targetSelection is the part of the environment selected by --target flags
+type targetSelection struct {
+	// addresses are tofu resource addresses to pass to -target
+	addresses []string
+	// clusters are names of selected downstream clusters
+	clusters []string
+	upstream bool
+}
+
+// resolveTargets translates --target values into a targetSelection. Accepted values are "upstream", "tester",
+// a downstream cluster name, or "template=<prefix>" for all downstream clusters named "<prefix>-*".
+// Downstream clusters are looked up in tofu variables and in statuses. If statuses is nil, as when applying after
+// a destroy already cleaned it up, names not created by tofu are ignored instead of rejected
+func resolveTargets(r *dart.Dart, statuses map[string]*actions.ClusterStatus, specs []string) (targetSelection, error) {
+	downstreams, err := tofu.DownstreamClusters(r.TofuVariables)
+	if err != nil {
+		return targetSelection{}, err
+	}
+
+	addresses := map[string][]string{}
+	for _, downstream := range downstreams {
+		addresses[downstream.Name] = downstream.Addresses
+	}
+
+	known := slices.Collect(maps.Keys(addresses))
+	for name := range statuses {
+		if _, ok := addresses[name]; !ok {
+			known = append(known, name)
+		}
+	}
+
+	result := targetSelection{}
+	selected := map[string]bool{}
+
+	for _, spec := range specs {
+		switch {
+		case spec == "upstream":
+			result.upstream = true
+			result.addresses = append(result.addresses, tofu.UpstreamAddresses...)
+		case spec == "tester":
+			result.addresses = append(result.addresses, tofu.TesterAddresses...)
+		case strings.HasPrefix(spec, targetTemplatePrefix):
+			prefix := strings.TrimPrefix(spec, targetTemplatePrefix)
+			if prefix == "" {
+				return targetSelection{}, fmt.Errorf("invalid --%s %q: missing template prefix", ArgTarget, spec)
+			}
+
+			matched := false
+
+			for _, name := range known {
+				if strings.HasPrefix(name, prefix+"-") {
+					selected[name] = true
+					matched = true
+				}
+			}
+
+			if !matched && statuses != nil {
+				return targetSelection{}, fmt.Errorf("--%s %q does not match any downstream cluster", ArgTarget, spec)
+			}
+		case slices.Contains(known, spec):
+			selected[spec] = true
+		case statuses == nil:
+			fmt.Printf("Skipping --%s %s: not created by tofu\n", ArgTarget, spec)
+		default:
+			return targetSelection{}, fmt.Errorf("unknown --%s %q: expected upstream, tester, a downstream cluster name or %s<prefix>",
+				ArgTarget, spec, targetTemplatePrefix)
+		}
+	}
+
+	result.clusters = slices.Sorted(maps.Keys(selected))
+	for _, name := range result.clusters {
+		result.addresses = append(result.addresses, addresses[name]...)
+	}
+
+	return result, nil
+}
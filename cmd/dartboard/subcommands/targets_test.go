@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+)
+
+func TestResolveTargets(t *testing.T) {
+	r := &dart.Dart{TofuVariables: map[string]any{
+		"downstream_cluster_templates": []any{
+			map[string]any{"cluster_count": 2, "server_count": 1},
+			map[string]any{"cluster_count": 1, "server_count": 2, "is_custom_cluster": true},
+		},
+	}}
+
+	const module = "module.test_environment.module."
+
+	// besides clusters created by tofu, statuses record clusters provisioned by Rancher
+	statuses := map[string]*actions.ClusterStatus{
+		"downstream-0-0": {Name: "downstream-0-0"},
+		"prov-0":         {Name: "prov-0"},
+		"prov-1":         {Name: "prov-1"},
+		"provider-0":     {Name: "provider-0"},
+	}
+
+	tests := []struct {
+		name     string
+		statuses map[string]*actions.ClusterStatus
+		specs    []string
+		want     targetSelection
+		wantErr  bool
+	}{
+		{
+			name:     "upstream and tester",
+			statuses: statuses,
+			specs:    []string{"upstream", "tester"},
+			want: targetSelection{
+				addresses: []string{module + "upstream_cluster", module + "upstream_postgres", module + "tester_cluster"},
+				upstream:  true,
+			},
+		},
+		{
+			name:     "cluster created by tofu",
+			statuses: statuses,
+			specs:    []string{"downstream-0-1"},
+			want: targetSelection{
+				addresses: []string{module + "downstream_clusters[1]"},
+				clusters:  []string{"downstream-0-1"},
+			},
+		},
+		{
+			name:     "custom cluster maps to its nodes",
+			statuses: statuses,
+			specs:    []string{"downstream-custom-1-0"},
+			want: targetSelection{
+				addresses: []string{module + "nodes[0]", module + "nodes[1]"},
+				clusters:  []string{"downstream-custom-1-0"},
+			},
+		},
+		{
+			name:     "template matches the exact prefix only",
+			statuses: statuses,
+			specs:    []string{"template=prov"},
+			want:     targetSelection{clusters: []string{"prov-0", "prov-1"}},
+		},
+		{
+			name:     "template of tofu clusters",
+			statuses: statuses,
+			specs:    []string{"template=downstream-0"},
+			want: targetSelection{
+				addresses: []string{module + "downstream_clusters[0]", module + "downstream_clusters[1]"},
+				clusters:  []string{"downstream-0-0", "downstream-0-1"},
+			},
+		},
+		{
+			name:     "duplicates are selected once",
+			statuses: statuses,
+			specs:    []string{"prov-0", "template=prov"},
+			want:     targetSelection{clusters: []string{"prov-0", "prov-1"}},
+		},
+		{name: "unknown cluster", statuses: statuses, specs: []string{"nope"}, wantErr: true},
+		{name: "template without match", statuses: statuses, specs: []string{"template=nope"}, wantErr: true},
+		{name: "template without prefix", statuses: statuses, specs: []string{"template="}, wantErr: true},
+		{
+			name:     "unknown cluster after destroy",
+			statuses: nil,
+			specs:    []string{"prov-0", "template=prov", "downstream-0-0"},
+			want: targetSelection{
+				addresses: []string{module + "downstream_clusters[0]"},
+				clusters:  []string{"downstream-0-0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveTargets(r, tt.statuses, tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveTargets() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
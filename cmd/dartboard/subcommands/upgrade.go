@@ -0,0 +1,259 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/kubectl"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/rancher/shepherd/pkg/session"
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	ArgRancherVersion = "rancher-version"
+	ArgImageTag       = "image-tag"
+	ArgK6Script       = "k6-script"
+	ArgK6Duration     = "k6-duration"
+
+	rancherRolloutMinutes = 20
+	agentUpgradeTimeout   = 30 * time.Minute
+)
+
+// upgradeReport records how long each phase of a Rancher upgrade took
+type upgradeReport struct {
+	From    string         `yaml:"from"`
+	To      string         `yaml:"to"`
+	Phases  []upgradePhase `yaml:"phases"`
+	Error   string         `yaml:"error,omitempty"`
+	K6Error string         `yaml:"k6_error,omitempty"`
+}
+
+type upgradePhase struct {
+	Start    time.Time     `yaml:"start"`
+	Name     string        `yaml:"name"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Upgrade upgrades Rancher on the upstream cluster and waits until downstream clusters are back, timing each phase
+func Upgrade(cli *cli.Context) error {
+	tf, r, err := prepare(cli)
+	if err != nil {
+		return err
+	}
+
+	ctx := cli.Context
+
+	unlock, err := lockClusterState(r)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	clusters, _, err := tf.ParseOutputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	setupGrafana(ctx, r, clusters)
+
+	upstream := clusters["upstream"]
+	report := upgradeReport{From: r.ChartVariables.RancherVersion}
+
+	r.ChartVariables.SetRancherVersion(cli.String(ArgRancherVersion))
+
+	rancherImageTag := "v" + r.ChartVariables.RancherVersion
+	if tag := cli.String(ArgImageTag); tag != "" {
+		rancherImageTag = tag
+		r.ChartVariables.RancherImageTagOverride = tag
+	}
+
+	report.To = r.ChartVariables.RancherVersion + " (" + rancherImageTag + ")"
+
+	// log in before the upgrade, so that downstream agents can be checked while Rancher restarts
+	rancherConfig, err := rancherConfigFor(ctx, r, upstream)
+	if err != nil {
+		return err
+	}
+
+	rancherSession := session.NewSession()
+	rancherSession.CleanupEnabled = false
+
+	rancherClient, err := actions.NewRancherClient(&rancherConfig, r.ChartVariables.AdminPassword, rancherSession)
+	if err != nil {
+		return fmt.Errorf("error while logging into Rancher: %w", err)
+	}
+
+	k6Ctx, cancelK6 := context.WithCancel(ctx)
+	defer cancelK6()
+
+	var k6Done chan error
+
+	if script := cli.String(ArgK6Script); script != "" {
+		if k6Done, err = startUpgradeK6(k6Ctx, r, clusters, script, cli.Duration(ArgK6Duration), &report); err != nil {
+			return err
+		}
+	}
+
+	phase := func(name string, f func() error) error {
+		logrus.Infof("Upgrade phase %q started", name)
+
+		start := time.Now()
+		err := grafana.Phase(ctx, shared.Grafana(), "Rancher upgrade: "+name, []string{"upgrade"}, f)
+		report.Phases = append(report.Phases, upgradePhase{Start: start, Name: name, Duration: time.Since(start).Round(time.Second)})
+
+		return err
+	}
+
+	err = phase("helm upgrade", func() error {
+		image := "rancher/rancher"
+		if r.ChartVariables.RancherImageOverride != "" {
+			image = r.ChartVariables.RancherImageOverride
+		}
+
+		if err := importImageIntoK3d(ctx, tf, image+":"+rancherImageTag, upstream); err != nil {
+			return err
+		}
+
+		return chartInstallRancher(ctx, r, rancherImageTag, &upstream)
+	})
+	if err == nil {
+		err = phase("rancher rollout", func() error {
+			return kubectl.RolloutStatus(ctx, upstream.Kubeconfig, "deployment", "rancher", nsCattleSystem, rancherRolloutMinutes)
+		})
+	}
+
+	if err == nil {
+		err = phase("rancher readiness gates", func() error {
+			return waitRancherReady(ctx, upstream)
+		})
+	}
+
+	if err == nil {
+		err = phase("downstream agents reconnected", func() error {
+			return actions.WaitForAgentsUpgraded(ctx, rancherClient, rancherImageTag, agentUpgradeTimeout)
+		})
+	}
+
+	if err != nil {
+		report.Error = err.Error()
+		// no point in measuring availability any further
+		cancelK6()
+	}
+
+	if k6Done != nil {
+		logrus.Info("Waiting for k6 to finish")
+
+		if k6Err := <-k6Done; k6Err != nil {
+			report.K6Error = k6Err.Error()
+		}
+	}
+
+	if reportErr := saveUpgradeReport(r, &report); reportErr != nil {
+		logrus.Errorf("could not save upgrade report: %v", reportErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rancher upgraded to %s, set rancher_version in the dart accordingly before running deploy again\n", report.To)
+
+	return nil
+}
+
+// startUpgradeK6 runs a k6 script against Rancher in the background, returning a channel that gets its result
+func startUpgradeK6(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster, script string, duration time.Duration,
+	report *upgradeReport,
+) (chan error, error) {
+	tester, ok := clusters["tester"]
+	if !ok || len(tester.Kubeconfig) == 0 {
+		return nil, fmt.Errorf("--%s requires a tester cluster", ArgK6Script)
+	}
+
+	// Refresh k6 files
+	if err := chartInstall(ctx, r.Airgap, tester.Kubeconfig, chart{chartNameK6Files, nsTester, chartNameK6Files}, nil); err != nil {
+		return nil, err
+	}
+
+	clusterAdd, err := getAppAddressFor(ctx, clusters["upstream"])
+	if err != nil {
+		return nil, err
+	}
+
+	envVars := map[string]string{
+		"BASE_URL": clusterAdd.Public.HTTPSURL,
+		"USERNAME": "admin",
+		"PASSWORD": r.ChartVariables.AdminPassword,
+		"DURATION": duration.String(),
+	}
+	tags := map[string]string{
+		"test":         filepath.Base(script),
+		"upgrade_from": report.From,
+		"upgrade_to":   r.ChartVariables.RancherVersion,
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- kubectl.K6run(ctx, tester.Kubeconfig, r.Airgap.Image(kubectl.K6Image), script, envVars, tags, true, clusterAdd.Local.HTTPSURL, false)
+	}()
+
+	return done, nil
+}
+
+// saveUpgradeReport prints the report and saves it next to the Cluster state file
+func saveUpgradeReport(r *dart.Dart, report *upgradeReport) error {
+	fmt.Printf("\n*** RANCHER UPGRADE %s -> %s\n", report.From, report.To)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PHASE\tSTART\tDURATION")
+
+	for _, phase := range report.Phases {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", phase.Name, phase.Start.Format(time.RFC3339), phase.Duration)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upgrade report: %w", err)
+	}
+
+	path := filepath.Join(r.TofuWorkspaceStatePath, fmt.Sprintf("upgrade-%s.yaml", time.Now().Format("20060102-150405")))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write upgrade report: %w", err)
+	}
+
+	fmt.Printf("Upgrade timings saved to %s\n\n", path)
+
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package subcommands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rancher/dartboard/internal/actions"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/shepherd/pkg/session"
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+)
+
+const (
+	ArgKubernetesVersion = "kubernetes-version"
+	ArgCount             = "count"
+	ArgTimeout           = "timeout"
+)
+
+// UpgradeKubernetes changes the Kubernetes version of provisioned and custom downstream clusters in batches
+func UpgradeKubernetes(cli *cli.Context) error {
+	tf, r, err := prepare(cli)
+	if err != nil {
+		return err
+	}
+
+	ctx := cli.Context
+
+	unlock, err := lockClusterState(r)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	statuses, err := actions.LoadClusterState(clusterStatePath(r))
+	if err != nil {
+		return err
+	}
+
+	// imported clusters are not managed by Rancher's provisioning API
+	var names []string
+
+	for name, cs := range statuses {
+		if cs.Reached(actions.StageProvisioned) || cs.Reached(actions.StageRegistered) {
+			names = append(names, name)
+		}
+	}
+
+	if targets := cli.StringSlice(ArgTarget); len(targets) > 0 {
+		selection, err := resolveTargets(r, statuses, targets)
+		if err != nil {
+			return err
+		}
+
+		selected := map[string]bool{}
+		for _, name := range selection.clusters {
+			selected[name] = true
+		}
+
+		var filtered []string
+
+		for _, name := range names {
+			if selected[name] {
+				filtered = append(filtered, name)
+			}
+		}
+
+		names = filtered
+	}
+
+	SortItemsNaturally(names, func(name string) string { return name })
+
+	if count := cli.Int(ArgCount); count > 0 && count < len(names) {
+		names = names[:count]
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("no provisioned or custom downstream clusters selected")
+	}
+
+	version := cli.String(ArgKubernetesVersion)
+	logrus.Infof("Upgrading %d clusters to Kubernetes %s", len(names), version)
+
+	clusters, _, err := tf.ParseOutputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	setupGrafana(ctx, r, clusters)
+
+	rancherConfig, err := rancherConfigFor(ctx, r, clusters["upstream"])
+	if err != nil {
+		return err
+	}
+
+	rancherSession := session.NewSession()
+	rancherSession.CleanupEnabled = false
+
+	rancherClient, err := actions.NewRancherClient(&rancherConfig, r.ChartVariables.AdminPassword, rancherSession)
+	if err != nil {
+		return fmt.Errorf("error while logging into Rancher: %w", err)
+	}
+
+	err = grafana.Phase(ctx, shared.Grafana(), "upgrade Kubernetes to "+version, []string{"kubernetes_upgrade"}, func() error {
+		return actions.UpgradeKubernetesInBatches(ctx, r, rancherClient, names, version, cli.Duration(ArgTimeout))
+	})
+
+	if summaryErr := printKubernetesUpgrades(clusterStatePath(r), names, version); summaryErr != nil {
+		logrus.Errorf("could not summarize Kubernetes upgrades: %v", summaryErr)
+	}
+
+	return err
+}
+
+// printKubernetesUpgrades prints the last upgrade to version of each named cluster
+func printKubernetesUpgrades(statePath string, names []string, version string) error {
+	statuses, err := actions.LoadClusterState(statePath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n*** KUBERNETES UPGRADES TO %s\n", version)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFROM\tSTART\tDURATION\tERROR")
+
+	failed := 0
+
+	for _, name := range names {
+		cs := statuses[name]
+		if cs == nil {
+			continue
+		}
+
+		for i := len(cs.KubernetesUpgrades) - 1; i >= 0; i-- {
+			upgrade := cs.KubernetesUpgrades[i]
+			if upgrade.To != version {
+				continue
+			}
+
+			if upgrade.Error != "" {
+				failed++
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, upgrade.From, upgrade.Start.Format("15:04:05"), upgrade.Duration,
+				strings.Join(strings.Fields(upgrade.Error), " "))
+
+			break
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d failed\n\n", failed)
+
+	return nil
+}
@@ -17,14 +17,24 @@ limitations under the License.
 package subcommands
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/rancher/dartboard/internal/docker"
+	"github.com/rancher/dartboard/internal/grafana"
 	"github.com/rancher/dartboard/internal/k3d"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/dartboard/internal/throttle"
 	"github.com/rancher/dartboard/internal/vendored"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
 
+	"github.com/rancher/dartboard/internal/actions"
 	"github.com/rancher/dartboard/internal/dart"
 	"github.com/rancher/dartboard/internal/kubectl"
 	"github.com/rancher/dartboard/internal/tofu"
@@ -35,6 +45,7 @@ const (
 	ArgSkipApply   = "skip-apply"
 	ArgSkipCharts  = "skip-charts"
 	ArgSkipRefresh = "skip-refresh"
+	ArgRetryFailed = "retry-failed"
 )
 
 type clusterAddress struct {
@@ -50,11 +61,41 @@ type clusterAddresses struct {
 
 // prepare prepares tofu for execution and parses a dart file from the command line context
 func prepare(cli *cli.Context) (*tofu.Tofu, *dart.Dart, error) {
+	return prepareTo(cli, os.Stdout)
+}
+
+// prepareTo is like prepare, but writes progress messages and tofu output to out
+func prepareTo(cli *cli.Context, out io.Writer) (*tofu.Tofu, *dart.Dart, error) {
+	d, err := prepareDartTo(cli, out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = vendored.ExtractBinaries()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tf, err := tofu.New(cli.Context, d.TofuVariables, d.TofuMainDirectory, d.TofuWorkspace, d.TofuParallelism, out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tf, d, nil
+}
+
+// prepareDart parses a dart file from the command line context and applies its package-wide policies
+func prepareDart(cli *cli.Context) (*dart.Dart, error) {
+	return prepareDartTo(cli, os.Stdout)
+}
+
+// prepareDartTo is like prepareDart, but writes progress messages to out
+func prepareDartTo(cli *cli.Context, out io.Writer) (*dart.Dart, error) {
 	dartPath := cli.String(ArgDart)
 
 	d, err := dart.Parse(dartPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	if d.TofuWorkspace == "" {
@@ -65,26 +106,30 @@ func prepare(cli *cli.Context) (*tofu.Tofu, *dart.Dart, error) {
 
 	absPath, err := filepath.Abs(tofuWorkspaceStatePath)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	d.TofuWorkspaceStatePath = absPath
 
-	fmt.Printf("Using dart: %s\n", dartPath)
-	fmt.Printf("OpenTofu main directory: %s\n", d.TofuMainDirectory)
-	fmt.Printf("Using Tofu workspace: %s\n", d.TofuWorkspace)
+	shared.SetRetry(d.RetryPolicy)
+	shared.SetLimiter(throttle.New(d.Onboarding))
 
-	err = vendored.ExtractBinaries()
-	if err != nil {
-		return nil, nil, err
-	}
+	fmt.Fprintf(out, "Using dart: %s\n", dartPath)
+	fmt.Fprintf(out, "OpenTofu main directory: %s\n", d.TofuMainDirectory)
+	fmt.Fprintf(out, "Using Tofu workspace: %s\n", d.TofuWorkspace)
 
-	tf, err := tofu.New(d.TofuVariables, d.TofuMainDirectory, d.TofuWorkspace, d.TofuParallelism, true)
-	if err != nil {
-		return nil, nil, err
-	}
+	return d, nil
+}
 
-	return tf, d, nil
+// clusterStatePath returns the path of the file tracking downstream cluster state for the dart's workspace
+func clusterStatePath(r *dart.Dart) string {
+	return filepath.Join(r.TofuWorkspaceStatePath, actions.ClustersStateFile)
+}
+
+// lockClusterState prevents other dartboard processes from working on the dart's workspace until the
+// returned function is called
+func lockClusterState(r *dart.Dart) (func(), error) {
+	return actions.LockClusterState(clusterStatePath(r))
 }
 
 // printAccessDetails prints to console addresses and kubeconfig file paths of a cluster for user convenience
@@ -107,13 +152,13 @@ func printAccessDetails(r *dart.Dart, name string, cluster tofu.Cluster, rancher
 }
 
 // getAppAddressFor returns local cluster address data, public cluster address data and an error
-func getAppAddressFor(cluster tofu.Cluster) (clusterAddresses, error) {
+func getAppAddressFor(ctx context.Context, cluster tofu.Cluster) (clusterAddresses, error) {
 	add := cluster.AppAddresses
 
 	addresses := clusterAddresses{}
 
 	// ignore error if we are not able to get the Rancher FQDN from the LoadBalancer
-	loadBalancerName, _ := kubectl.GetRancherFQDNFromLoadBalancer(cluster.Kubeconfig)
+	loadBalancerName, _ := kubectl.GetRancherFQDNFromLoadBalancer(ctx, cluster.Kubeconfig)
 
 	// addresses meant to be resolved from the machine running Tofu
 	// use tunnel if available, otherwise public, otherwise go through the load balancer
@@ -178,18 +223,52 @@ func getAppAddressFor(cluster tofu.Cluster) (clusterAddresses, error) {
 	return addresses, nil
 }
 
+// rancherConfigFor returns the configuration for a Rancher client connecting to the upstream cluster from this machine
+func rancherConfigFor(ctx context.Context, r *dart.Dart, upstream tofu.Cluster) (rancher.Config, error) {
+	upstreamAdd, err := getAppAddressFor(ctx, upstream)
+	if err != nil {
+		return rancher.Config{}, err
+	}
+
+	rancherHost := strings.Split(upstreamAdd.Local.HTTPSURL, "://")[1]
+
+	return actions.NewRancherConfig(rancherHost, "", r.ChartVariables.AdminPassword, true), nil
+}
+
+// setupGrafana makes the tester cluster's Grafana the target of annotations, if it can be reached
+func setupGrafana(ctx context.Context, r *dart.Dart, clusters map[string]tofu.Cluster) {
+	tester, ok := clusters["tester"]
+	if !ok || len(tester.Kubeconfig) == 0 {
+		return
+	}
+
+	testerAdd, err := getAppAddressFor(ctx, tester)
+	if err != nil {
+		logrus.Warnf("Grafana will not be annotated: %v", err)
+		return
+	}
+
+	client := grafana.NewClient(testerAdd.Local.HTTPURL+"/grafana", "admin", r.ChartVariables.AdminPassword)
+	if err := client.Health(ctx); err != nil {
+		logrus.Warnf("Grafana will not be annotated: %v", err)
+		return
+	}
+
+	shared.SetGrafana(client)
+}
+
 // importImageIntoK3d uses k3d import to import the specified image in the specified cluster, if such image
 // is known by the docker installation. This is for testing custom Rancher images (built via make quick) locally
 // in k3d
-func importImageIntoK3d(tf *tofu.Tofu, image string, cluster tofu.Cluster) error {
+func importImageIntoK3d(ctx context.Context, tf *tofu.Tofu, image string, cluster tofu.Cluster) error {
 	if tf.IsK3d() {
-		images, err := docker.Images(image)
+		images, err := docker.Images(ctx, image)
 		if err != nil {
 			return err
 		}
 
 		if len(images) > 0 {
-			if err := k3d.ImageImport(cluster.Name, images[0]); err != nil {
+			if err := k3d.ImageImport(ctx, cluster.Name, images[0]); err != nil {
 				return err
 			}
 		}
@@ -197,3 +276,9 @@ func importImageIntoK3d(tf *tofu.Tofu, image string, cluster tofu.Cluster) error
 
 	return nil
 }
+
+// isDir returns true if path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
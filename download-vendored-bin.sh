@@ -6,8 +6,6 @@ set -e
 OPENTOFU_VERSION=1.11.5
 # renovate-local: kubectl
 KUBECTL_VERSION=1.35.3
-# renovate-local: helm
-HELM_VERSION=4.1.3
 # renovate: datasource=github-release-attachments depName=k3d-io/k3d
 K3D_VERSION=5.8.3
 
@@ -106,21 +104,6 @@ curl --output ${KUBECTL_SHA256_FILE} --location --fail ${KUBECTL_SHA256_URL}
 verify_sha256_digest "${KUBECTL_BINARY}" "${KUBECTL_SHA256_FILE}"
 rm -f "${KUBECTL_SHA256_FILE}"
 
-echo Downloading and unpacking Helm...
-HELM_FILENAME="helm-v${HELM_VERSION}-${GOOS}-${GOARCH}"
-HELM_ARCHIVE="${HELM_FILENAME}.tar.gz"
-HELM_URL="https://get.helm.sh/${HELM_ARCHIVE}"
-HELM_SHA256SUM_FILE="${HELM_ARCHIVE}.sha256sum"
-HELM_SHA256SUM_URL="https://get.helm.sh/${HELM_SHA256SUM_FILE}"
-curl --output "${HELM_ARCHIVE}" --location --fail "${HELM_URL}"
-curl --output "${HELM_SHA256SUM_FILE}" --location --fail "${HELM_SHA256SUM_URL}"
-verify_sha256 "${HELM_ARCHIVE}" "${HELM_SHA256SUM_FILE}"
-rm -f "${HELM_SHA256SUM_FILE}"
-tar xvf "${HELM_ARCHIVE}"
-mv "${GOOS}-${GOARCH}"/helm .
-rm -rf "${HELM_ARCHIVE}"*
-rm -rf "${GOOS}"-"${GOARCH}"*
-
 echo Downloading k3d...
 K3D_BINARY="k3d-${GOOS}-${GOARCH}"
 K3D_URL="https://github.com/k3d-io/k3d/releases/download/v${K3D_VERSION}/${K3D_BINARY}"
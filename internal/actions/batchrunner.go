@@ -1,28 +1,41 @@
 package actions
 
 import (
+	"context"
 	"fmt"
-	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/shared"
 	"github.com/rancher/dartboard/internal/tofu"
 	"github.com/rancher/shepherd/clients/rancher"
 	shepherddefaults "github.com/rancher/shepherd/extensions/defaults"
 	"github.com/sirupsen/logrus"
 )
 
-var maxWorkers = runtime.GOMAXPROCS(0) * 2
-
 // Mutex to sync map[string]*ClusterStatus mutations and state file writes
 var stateMutex sync.Mutex
 
-// stateUpdate is a simple "signaling" struct for the file writer goroutine to persist state
+// stateUpdate is a simple "signaling" struct for the file writer goroutine to persist state, see record
 type stateUpdate struct {
 	Completed time.Time
 	Name      string
-	Stage     Stage
+	// Err is the error of a finished attempt, empty if it succeeded. Only meaningful if Finished is set
+	Err      string
+	Stage    Stage
+	Finished bool
+	// RancherName, if set, is the name of the provisioning Cluster created in Rancher
+	RancherName string
+	// Template, if set, is the name_prefix of the cluster template the cluster is provisioned from
+	Template string
+	// Upgrade, if set, is appended to the cluster's Kubernetes upgrade history instead of changing its Stage
+	Upgrade *KubernetesUpgrade
+
+	// done receives the outcome of applying and persisting the update
+	done chan error
 }
 
 type jobResult struct {
@@ -31,7 +44,7 @@ type jobResult struct {
 }
 
 type JobDataTypes interface {
-	tofu.Cluster | dart.ClusterTemplate | tofu.CustomCluster
+	tofu.Cluster | dart.ClusterTemplate | tofu.CustomCluster | KubernetesUpgradeJob
 }
 
 // SequencedBatchRunner contains all the channels and WaitGroups needed
@@ -49,15 +62,38 @@ type SequencedBatchRunner[J JobDataTypes] struct {
 	// WaitGroups for Job workers and the Updates channel which sequences writes to the ClustarStatus state file
 	wgWorkers sync.WaitGroup
 	wgWriter  sync.WaitGroup
+
+	failurePolicy dart.FailurePolicy
+	// failures counts the clusters that failed during this run of dartboard, it is shared by the runners of all
+	// batches. Failures recorded in the state file by earlier runs are not counted
+	failures *atomic.Int64
+	// retryFailedOnly skips all jobs but the ones for clusters marked as failed
+	retryFailedOnly bool
+	// importStrategy is how imported clusters get Rancher's agent, see dart.ImportJob and dart.ImportManifest
+	importStrategy string
+	// watcher, if not nil, is waited on for cluster conditions instead of polling Rancher
+	watcher *ClusterWatcher
+	// annotations posts the Grafana annotations of jobs in the background, see jobAnnotation
+	annotations *grafana.Queue
 }
 
-// NewSequencedBatchRunner constructs a new runner for one batch
-func NewSequencedBatchRunner[J JobDataTypes](batchSize int) *SequencedBatchRunner[J] {
+// NewSequencedBatchRunner constructs a new runner for one batch. failures is the count of clusters that failed
+// during this run so far, see dart.Dart.Failures, nil to count only the failures of this batch
+func NewSequencedBatchRunner[J JobDataTypes](batchSize int, failurePolicy dart.FailurePolicy, failures *atomic.Int64,
+	retryFailedOnly bool,
+) *SequencedBatchRunner[J] {
+	if failures == nil {
+		failures = new(atomic.Int64)
+	}
+
 	br := &SequencedBatchRunner[J]{
-		Updates: make(chan stateUpdate, batchSize*3),
-		seqCh:   make(chan struct{}, 1),
-		Jobs:    make(chan J, batchSize),
-		Results: make(chan jobResult, batchSize),
+		Updates:         make(chan stateUpdate, batchSize*4),
+		seqCh:           make(chan struct{}, 1),
+		Jobs:            make(chan J, batchSize),
+		Results:         make(chan jobResult, batchSize),
+		failurePolicy:   failurePolicy,
+		failures:        failures,
+		retryFailedOnly: retryFailedOnly,
 	}
 	// seed the sequencer
 	br.seqCh <- struct{}{}
@@ -65,21 +101,25 @@ func NewSequencedBatchRunner[J JobDataTypes](batchSize int) *SequencedBatchRunne
 	return br
 }
 
-// Run executes the batch: starts the file writer, workers, enqueues jobs, collects results
-func (br *SequencedBatchRunner[J]) Run(batch []J,
+// Run executes the batch: starts the file writer, workers, enqueues jobs, collects results.
+// Once ctx is done no new job is started, jobs in flight are interrupted at their next wait,
+// and all state updates they already sent are persisted before returning
+func (br *SequencedBatchRunner[J]) Run(ctx context.Context, batch []J,
 	statuses map[string]*ClusterStatus, statePath string, client *rancher.Client,
 	config *rancher.Config,
 ) error {
+	br.annotations = grafana.NewQueue(shared.Grafana(), len(batch))
+
 	// Start writer
 	br.wgWriter.Add(1)
 
 	go br.writer(statuses, statePath)
 
-	// Spawn workers
-	for range maxWorkers {
+	// Spawn workers, the limiter decides how many of them actually work at the same time
+	for range min(shared.Limiter().MaxInFlight(), len(batch)) {
 		br.wgWorkers.Add(1)
 
-		go br.worker(statuses, client, config)
+		go br.worker(ctx, statuses, client, config)
 	}
 
 	// Enqueue and close jobs
@@ -91,14 +131,21 @@ func (br *SequencedBatchRunner[J]) Run(batch []J,
 
 	// Reset skip count for this batch
 	numSkipped := 0
+	numFailed := 0
 	sleepAfter := false
 	// Collect results
 	for range batch {
 		res := <-br.Results
 		if res.err != nil {
-			// Clean up in case of error
-			br.Wait()
-			return fmt.Errorf("error during batch run: %w", res.err)
+			if br.failurePolicy.Mode == dart.FailFast || ctx.Err() != nil {
+				// Clean up in case of error
+				br.Wait()
+				return fmt.Errorf("error during batch run: %w", res.err)
+			}
+
+			logrus.Errorf("error during batch run, continuing with the other clusters: %v", res.err)
+
+			numFailed++
 		}
 
 		if res.skipped {
@@ -108,11 +155,25 @@ func (br *SequencedBatchRunner[J]) Run(batch []J,
 		sleepAfter = numSkipped < len(batch)/2
 	}
 
+	if br.maxFailuresReached() {
+		br.Wait()
+
+		return fmt.Errorf("%d clusters failed during this run, reaching max_failures (%d)", br.failures.Load(),
+			br.failurePolicy.MaxFailures)
+	}
+
 	// After finishing this batch:
-	if sleepAfter {
+	if shared.Limiter().Paced() {
+		// The limiter already enforces the target rate
+		logrus.Infof("Batch done: %d/%d skipped.", numSkipped, len(batch))
+	} else if sleepAfter {
 		// If fewer than half were skipped, sleep briefly
 		logrus.Infof("Batch done: %d/%d skipped; sleeping before next batch.", numSkipped, len(batch))
-		time.Sleep(shepherddefaults.TwoMinuteTimeout)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(shepherddefaults.TwoMinuteTimeout):
+		}
 	} else {
 		// Otherwise, go straight into the next batch
 		logrus.Infof("Batch done: %d/%d skipped; continuing without sleep.", numSkipped, len(batch))
@@ -121,17 +182,32 @@ func (br *SequencedBatchRunner[J]) Run(batch []J,
 	// Clean up
 	br.Wait()
 
+	if numFailed > 0 {
+		logrus.Warnf("Batch done: %d/%d failed.", numFailed, len(batch))
+	}
+
 	return nil
 }
 
+// maxFailuresReached returns true once the clusters that failed during this run reach max_failures
+func (br *SequencedBatchRunner[J]) maxFailuresReached() bool {
+	return br.failurePolicy.MaxFailures > 0 && br.failures.Load() >= int64(br.failurePolicy.MaxFailures)
+}
+
 func (br *SequencedBatchRunner[J]) Wait() {
 	br.wgWorkers.Wait()
+
+	if br.annotations != nil {
+		br.annotations.Close()
+	}
+
 	close(br.Updates)
 	br.wgWriter.Wait()
 	close(br.Results)
 }
 
-// writer serializes all state updates and persists immediately
+// writer serializes all state updates and persists immediately. An update that cannot be applied, eg. an invalid
+// stage transition, is not persisted and its error is returned to the sender
 func (br *SequencedBatchRunner[J]) writer(statuses map[string]*ClusterStatus, statePath string) {
 	defer br.wgWriter.Done()
 
@@ -139,34 +215,65 @@ func (br *SequencedBatchRunner[J]) writer(statuses map[string]*ClusterStatus, st
 		stateMutex.Lock()
 		logrus.Debugf("\nIN WRITER\n")
 		logrus.Debugf("\n%v\n", statuses)
-		cs := statuses[u.Name]
-
-		cs.Stage = u.Stage
-		switch u.Stage {
-		case StageNew:
-			cs.New = true
-		case StageInfra:
-			cs.Infra = true
-		case StageCreated:
-			cs.Created = true
-		case StageImported:
-			cs.Imported = true
-		case StageProvisioned:
-			cs.Provisioned = true
-		case StageRegistered:
-			cs.Registered = true
-		}
 
-		if err := SaveClusterState(statePath, statuses); err != nil {
-			logrus.Errorf("failed to save state for %s:%s: %v", u.Name, u.Stage, err)
+		err := applyUpdate(statuses[u.Name], u)
+		if err == nil {
+			if err = SaveClusterState(statePath, statuses); err != nil {
+				err = fmt.Errorf("failed to save state for %s:%s: %w", u.Name, u.Stage, err)
+			}
 		}
 
 		stateMutex.Unlock()
+
+		u.done <- err
+	}
+}
+
+// applyUpdate applies u to cs
+func applyUpdate(cs *ClusterStatus, u stateUpdate) error {
+	if cs == nil {
+		return fmt.Errorf("no state for Cluster %s", u.Name)
+	}
+
+	switch {
+	case u.Upgrade != nil:
+		cs.KubernetesUpgrades = append(cs.KubernetesUpgrades, *u.Upgrade)
+	case u.Finished:
+		cs.Attempts++
+		cs.Failed = u.Err != ""
+		cs.Error = u.Err
+	default:
+		if err := cs.Advance(u.Stage, u.Completed); err != nil {
+			return fmt.Errorf("failed to update state: %w", err)
+		}
+
+		if u.RancherName != "" {
+			cs.RancherName = u.RancherName
+		}
+
+		if u.Template != "" {
+			cs.Template = u.Template
+		}
 	}
+
+	return nil
+}
+
+// record sends u to the writer, in sequence with the other workers, and waits for it to be persisted
+func (br *SequencedBatchRunner[J]) record(u stateUpdate) error {
+	u.done = make(chan error, 1)
+
+	<-br.seqCh
+
+	br.Updates <- u
+
+	br.seqCh <- struct{}{}
+
+	return <-u.done
 }
 
 // worker consumes Jobs, calls the proper handler based on the Job Type, signals Updates and Results
-func (br *SequencedBatchRunner[J]) worker(statuses map[string]*ClusterStatus,
+func (br *SequencedBatchRunner[J]) worker(ctx context.Context, statuses map[string]*ClusterStatus,
 	client *rancher.Client, config *rancher.Config,
 ) {
 	defer br.wgWorkers.Done()
@@ -176,23 +283,135 @@ func (br *SequencedBatchRunner[J]) worker(statuses map[string]*ClusterStatus,
 			skipped bool
 			err     error
 		)
-		// Use type assertion to determine which function to call
 
+		// Do not start new jobs after cancellation, but still report a result for each of them
+		if ctx.Err() != nil {
+			br.Results <- jobResult{err: ctx.Err()}
+			continue
+		}
+
+		name := jobName(job)
+
+		// Do not start new jobs once max_failures is reached, Run reports it after the jobs in flight finish
+		if br.maxFailuresReached() {
+			logrus.Infof("max_failures reached, not starting Cluster %s", name)
+
+			br.Results <- jobResult{skipped: true}
+
+			continue
+		}
+
+		if br.retryFailedOnly && !isFailed(statuses, name) {
+			logrus.Infof("Cluster %s has not failed, skipping...", name)
+
+			br.Results <- jobResult{skipped: true}
+
+			continue
+		}
+
+		start := time.Now()
+
+		// Use type assertion to determine which function to call
 		switch typedJob := any(job).(type) {
 		case tofu.Cluster:
-			skipped, err = importClusterWithRunner(br, typedJob, statuses, client, config)
+			skipped, err = importClusterWithRunner(ctx, br, typedJob, statuses, client, config)
 		case dart.ClusterTemplate:
-			skipped, err = provisionClusterWithRunner(br, typedJob, statuses, client)
+			skipped, err = provisionClusterWithRunner(ctx, br, typedJob, statuses, client)
 		case tofu.CustomCluster:
-			skipped, err = registerCustomClusterWithRunner(br, typedJob, statuses, client, config)
+			skipped, err = registerCustomClusterWithRunner(ctx, br, typedJob, statuses, client, config)
+		case KubernetesUpgradeJob:
+			skipped, err = upgradeKubernetesWithRunner(ctx, br, typedJob, client)
 		default:
 			err = fmt.Errorf("unsupported job type: %T", job)
 		}
 
+		// interrupted jobs are not failures of the cluster, they are resumed on the next run anyway.
+		// Kubernetes upgrades keep their own history, and do not affect onboarding attempts
+		if _, upgrade := any(job).(KubernetesUpgradeJob); !upgrade && !skipped && name != "" && ctx.Err() == nil {
+			br.finishAttempt(name, err)
+		}
+
+		if !skipped && name != "" && ctx.Err() == nil {
+			br.annotations.Add(jobAnnotation(job, name, start, err))
+		}
+
 		br.Results <- jobResult{skipped: skipped, err: err}
 
-		if err != nil {
+		if err != nil && br.failurePolicy.Mode == dart.FailFast {
 			return
 		}
 	}
 }
+
+// finishAttempt records the outcome of an attempt at the job for the named cluster
+func (br *SequencedBatchRunner[J]) finishAttempt(name string, err error) {
+	update := stateUpdate{Name: name, Finished: true, Completed: time.Now()}
+	if err != nil {
+		update.Err = err.Error()
+
+		br.failures.Add(1)
+	}
+
+	if err := br.record(update); err != nil {
+		logrus.Errorf("failed to record attempt for Cluster %s: %v", name, err)
+	}
+}
+
+// jobName returns the name of the cluster a job is about
+func jobName[J JobDataTypes](job J) string {
+	switch typedJob := any(job).(type) {
+	case tofu.Cluster:
+		return typedJob.Name
+	case dart.ClusterTemplate:
+		return typedJob.GeneratedName()
+	case tofu.CustomCluster:
+		return typedJob.Name
+	case KubernetesUpgradeJob:
+		return typedJob.Name
+	default:
+		return ""
+	}
+}
+
+// jobAnnotation marks the time range a job took for the named cluster on Grafana dashboards. Jobs queue them rather
+// than posting them, so that a slow Grafana does not slow down onboarding
+func jobAnnotation[J JobDataTypes](job J, name string, start time.Time, err error) grafana.Annotation {
+	var kind string
+
+	switch any(job).(type) {
+	case tofu.Cluster:
+		kind = "import"
+	case dart.ClusterTemplate:
+		kind = "provision"
+	case tofu.CustomCluster:
+		kind = "register"
+	case KubernetesUpgradeJob:
+		kind = "kubernetes_upgrade"
+	}
+
+	a := grafana.Annotation{Time: start, End: time.Now(), Tags: []string{kind, "cluster:" + name}, Text: kind + " " + name}
+	if err != nil {
+		a.Tags = append(a.Tags, "failed")
+		a.Text += ": " + err.Error()
+	}
+
+	return a
+}
+
+// reached returns true if the cluster of cs went through stage
+func reached(cs *ClusterStatus, stage Stage) bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	return cs.Reached(stage)
+}
+
+// isFailed returns true if the named cluster is marked as failed
+func isFailed(statuses map[string]*ClusterStatus, name string) bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	cs := FindClusterStatusByName(statuses, name)
+
+	return cs != nil && cs.Failed
+}
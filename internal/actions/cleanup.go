@@ -0,0 +1,169 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kwait "k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// clusterDeletionTimeout is how long Rancher gets to remove a provisioning Cluster and its resources
+	clusterDeletionTimeout = 15 * time.Minute
+	// agentCleanupTimeout is how long Rancher gets to remove its agents from a downstream cluster,
+	// before dartboard deletes leftover namespaces itself
+	agentCleanupTimeout = 5 * time.Minute
+)
+
+// agentNamespaces are the namespaces Rancher creates on downstream clusters it manages
+var agentNamespaces = []string{"cattle-system", "cattle-impersonation-system", "cattle-fleet-system"}
+
+// DeleteRancherClusters deletes from Rancher the named downstream clusters, or all clusters in the Cluster state file
+// if names is nil, in batches of r.ClusterBatchSize, and waits for Rancher to clean them up. Infrastructure created by
+// tofu is left intact, and imported clusters found in clusters are checked for leftover agents.
+// Clusters are deleted by the name recorded when Rancher created them, and it is an error if such a cluster is not
+// found. ClusterStatus entries of deleted clusters are removed, so that the next deploy onboards them again
+func DeleteRancherClusters(ctx context.Context, r *dart.Dart, client *rancher.Client, clusters map[string]tofu.Cluster, statePath string, names []string) error {
+	statuses, err := LoadClusterState(statePath)
+	if err != nil {
+		return err
+	}
+
+	if names == nil {
+		for name := range statuses {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	provClient, err := client.GetKubeAPIProvisioningClient()
+	if err != nil {
+		return fmt.Errorf("error while getting provisioning client: %w", err)
+	}
+
+	for batch := range slices.Chunk(names, max(r.ClusterBatchSize, 1)) {
+		logrus.Infof("Deleting clusters %v from Rancher", batch)
+
+		for _, name := range batch {
+			rancherName, created := rancherClusterName(statuses, name)
+
+			err := provClient.Clusters(fleetNamespace).Delete(ctx, rancherName, metav1.DeleteOptions{})
+			if apierrors.IsNotFound(err) && created {
+				// forgetting it would leave the cluster in Rancher, if it was recorded under the wrong name
+				return fmt.Errorf("Cluster %s was created in Rancher as %s, but is not found there", name, rancherName)
+			} else if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("error while deleting Cluster %s (%s): %w", name, rancherName, err)
+			}
+		}
+
+		for _, name := range batch {
+			rancherName, _ := rancherClusterName(statuses, name)
+
+			err := kwait.PollUntilContextTimeout(ctx, 5*time.Second, clusterDeletionTimeout, true, func(ctx context.Context) (bool, error) {
+				_, err := provClient.Clusters(fleetNamespace).Get(ctx, rancherName, metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					return true, nil
+				}
+
+				// transient errors are retried until the timeout
+				return false, nil
+			})
+			if err != nil {
+				return fmt.Errorf("error while waiting for Cluster %s to be deleted: %w", name, err)
+			}
+		}
+
+		for _, name := range batch {
+			// only imported clusters have a kubeconfig in tofu outputs, provisioned ones are gone with their nodes
+			if cluster, ok := clusters[name]; ok && len(cluster.Kubeconfig) > 0 {
+				if err := waitForAgentCleanup(ctx, name, cluster.Kubeconfig); err != nil {
+					return err
+				}
+			}
+
+			delete(statuses, name)
+		}
+
+		if err := SaveClusterState(statePath, statuses); err != nil {
+			return err
+		}
+
+		logrus.Infof("Deleted clusters %v from Rancher", batch)
+	}
+
+	return nil
+}
+
+// rancherClusterName returns the name of the named cluster in Rancher, and whether Rancher is known to have created it
+func rancherClusterName(statuses map[string]*ClusterStatus, name string) (string, bool) {
+	cs := FindClusterStatusByName(statuses, name)
+	if cs == nil {
+		return name, false
+	}
+
+	return cs.ProvisioningName(), cs.Reached(StageCreated)
+}
+
+// waitForAgentCleanup waits for Rancher agent namespaces to disappear from a downstream cluster. Namespaces still
+// there after agentCleanupTimeout are deleted explicitly, so the cluster can be imported again
+func waitForAgentCleanup(ctx context.Context, name, kubeconfig string) error {
+	restConfig, err := GetRESTConfigFromPath(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("error while creating client for cluster %s: %w", name, err)
+	}
+
+	var leftovers []string
+
+	gone := func(ctx context.Context) (bool, error) {
+		leftovers = nil
+
+		for _, namespace := range agentNamespaces {
+			_, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+			if err == nil || !apierrors.IsNotFound(err) {
+				leftovers = append(leftovers, namespace)
+			}
+		}
+
+		return len(leftovers) == 0, nil
+	}
+
+	err = kwait.PollUntilContextTimeout(ctx, 5*time.Second, agentCleanupTimeout, true, gone)
+	if err == nil {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	logrus.Warnf("Rancher did not clean up namespaces %v on cluster %s, deleting them", leftovers, name)
+
+	for _, namespace := range leftovers {
+		err := clientset.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error while deleting namespace %s on cluster %s: %w", namespace, name, err)
+		}
+	}
+
+	err = kwait.PollUntilContextTimeout(ctx, 5*time.Second, agentCleanupTimeout, true, gone)
+	if err != nil {
+		return fmt.Errorf("namespaces %v still present on cluster %s: %w", leftovers, name, err)
+	}
+
+	return nil
+}
@@ -72,7 +72,7 @@ func ConvertConfigToClusterConfig(config *dart.ClusterConfig) *rancherclusters.C
 
 // GetK3SRKE2Cluster is a "helper" functions that takes a rancher client, and the rke2 cluster config as parameters.
 // This function registers a delete cluster function with a wait.WatchWait to ensure the cluster is removed cleanly
-func GetK3SRKE2Cluster(client *rancher.Client, config *rancher.Config, cluster *apisV1.Cluster) (*v1.SteveAPIObject, error) {
+func GetK3SRKE2Cluster(ctx context.Context, client *rancher.Client, config *rancher.Config, cluster *apisV1.Cluster) (*v1.SteveAPIObject, error) {
 	clusterObjs, err := client.Steve.SteveType(shepherdclusters.ProvisioningSteveResourceType).ListAll(nil)
 	if err != nil {
 		return nil, err
@@ -80,8 +80,6 @@ func GetK3SRKE2Cluster(client *rancher.Client, config *rancher.Config, cluster *
 
 	for _, obj := range clusterObjs.Data {
 		if obj.Name == cluster.Name {
-			ctx := context.Background()
-
 			err = kwait.PollUntilContextTimeout(ctx, 500*time.Millisecond, 2*time.Minute, true, func(_ context.Context) (done bool, err error) {
 				client, err = client.ReLoginForConfig(config)
 				if err != nil {
@@ -108,14 +106,15 @@ func GetK3SRKE2Cluster(client *rancher.Client, config *rancher.Config, cluster *
 
 // CreateK3SRKE2Cluster is a "helper" functions that takes a rancher client, and the rke2 cluster config as parameters.
 // This function registers a delete cluster function with a wait.WatchWait to ensure the cluster is removed cleanly
-func CreateK3SRKE2Cluster(client *rancher.Client, config *rancher.Config, cluster *apisV1.Cluster) (*v1.SteveAPIObject, error) {
+func CreateK3SRKE2Cluster(ctx context.Context, client *rancher.Client, config *rancher.Config, cluster *apisV1.Cluster) (*v1.SteveAPIObject, error) {
+	start := time.Now()
 	clusterObj, err := client.Steve.SteveType(shepherdclusters.ProvisioningSteveResourceType).Create(cluster)
+	observeAPICall(start, err)
+
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context.Background()
-
 	err = kwait.PollUntilContextTimeout(ctx, 500*time.Millisecond, 2*time.Minute, true, func(_ context.Context) (done bool, err error) {
 		client, err = client.ReLoginForConfig(config)
 		if err != nil {
@@ -294,7 +293,7 @@ func validateCustomClusterNodeCount(nodes []tofu.Node, totalNodesNeeded int) err
 	return nil
 }
 
-func customClusterRegistrationWatch(client *rancher.Client, steveObject *v1.SteveAPIObject, cluster *apisV1.Cluster) (watch.Interface, string, error) {
+func customClusterRegistrationWatch(ctx context.Context, client *rancher.Client, steveObject *v1.SteveAPIObject, cluster *apisV1.Cluster) (watch.Interface, string, error) {
 	customCluster, err := client.Steve.SteveType(etcdsnapshot.ProvisioningSteveResouceType).ByID(steveObject.ID)
 	if err != nil {
 		return nil, "", err
@@ -321,7 +320,7 @@ func customClusterRegistrationWatch(client *rancher.Client, steveObject *v1.Stev
 		return nil, "", err
 	}
 
-	result, err := kubeProvisioningClient.Clusters(cluster.Namespace).Watch(context.TODO(), metav1.ListOptions{
+	result, err := kubeProvisioningClient.Clusters(cluster.Namespace).Watch(ctx, metav1.ListOptions{
 		FieldSelector:  "metadata.name=" + cluster.Name,
 		TimeoutSeconds: &defaults.WatchTimeoutSeconds,
 	})
@@ -384,7 +383,7 @@ func registerCustomClusterNodes(cluster *apisV1.Cluster, nodes []tofu.Node, quan
 }
 
 // RegisterCustomCluster registers a non-rke1 cluster using a 3rd party client for its nodes
-func RegisterCustomCluster(client *rancher.Client, steveObject *v1.SteveAPIObject, cluster *apisV1.Cluster, nodes []tofu.Node) (*v1.SteveAPIObject, error) {
+func RegisterCustomCluster(ctx context.Context, client *rancher.Client, steveObject *v1.SteveAPIObject, cluster *apisV1.Cluster, nodes []tofu.Node) (*v1.SteveAPIObject, error) {
 	quantityPerPool, rolesPerPool, totalNodesNeeded, err := buildCustomClusterPoolPlan(cluster)
 	if err != nil {
 		return nil, err
@@ -395,7 +394,7 @@ func RegisterCustomCluster(client *rancher.Client, steveObject *v1.SteveAPIObjec
 		return nil, err
 	}
 
-	result, nodeCommand, err := customClusterRegistrationWatch(client, steveObject, cluster)
+	result, nodeCommand, err := customClusterRegistrationWatch(ctx, client, steveObject, cluster)
 	if err != nil {
 		return nil, err
 	}
@@ -405,7 +404,7 @@ func RegisterCustomCluster(client *rancher.Client, steveObject *v1.SteveAPIObjec
 		return nil, err
 	}
 
-	err = wait.WatchWait(result, shepherdclusters.IsProvisioningClusterReady)
+	err = watchWait(ctx, result, shepherdclusters.IsProvisioningClusterReady)
 	if err != nil {
 		return nil, err
 	}
@@ -440,7 +439,7 @@ func VerifyClusterImported(client *rancher.Client, name, namespace string) (bool
 }
 
 // setupClusterVerification prepares clients and watches for cluster verification
-func setupClusterVerification(client *rancher.Client, config *rancher.Config, cluster *v1.SteveAPIObject) (*rancher.Client, error) {
+func setupClusterVerification(ctx context.Context, client *rancher.Client, config *rancher.Config, cluster *v1.SteveAPIObject) (*rancher.Client, error) {
 	client, err := client.ReLoginForConfig(config)
 	if err != nil {
 		return nil, err
@@ -462,7 +461,7 @@ func setupClusterVerification(client *rancher.Client, config *rancher.Config, cl
 		return nil, err
 	}
 
-	watchInterface, err := kubeProvisioningClient.Clusters(cluster.Namespace).Watch(context.TODO(), metav1.ListOptions{
+	watchInterface, err := kubeProvisioningClient.Clusters(cluster.Namespace).Watch(ctx, metav1.ListOptions{
 		FieldSelector:  "metadata.name=" + cluster.Name,
 		TimeoutSeconds: &defaults.WatchTimeoutSeconds,
 	})
@@ -473,7 +472,7 @@ func setupClusterVerification(client *rancher.Client, config *rancher.Config, cl
 	}
 
 	checkFunc := shepherdclusters.IsProvisioningClusterReady
-	err = wait.WatchWait(watchInterface, checkFunc)
+	err = watchWait(ctx, watchInterface, checkFunc)
 	reports.TimeoutClusterReport(cluster, err)
 
 	if err != nil {
@@ -503,8 +502,8 @@ func verifyClusterBasics(client *rancher.Client, cluster *v1.SteveAPIObject) err
 }
 
 // VerifyCluster validates that a non-rke1 cluster and its resources are in a good state, matching a given config.
-func VerifyCluster(client *rancher.Client, config *rancher.Config, cluster *v1.SteveAPIObject) error {
-	adminClient, err := setupClusterVerification(client, config, cluster)
+func VerifyCluster(ctx context.Context, client *rancher.Client, config *rancher.Config, cluster *v1.SteveAPIObject) error {
+	adminClient, err := setupClusterVerification(ctx, client, config, cluster)
 	if err != nil {
 		return err
 	}
@@ -561,7 +560,7 @@ func VerifyCluster(client *rancher.Client, config *rancher.Config, cluster *v1.S
 			return err
 		}
 
-		err = VerifyACE(adminClient, mgmtClusterObject)
+		err = VerifyACE(ctx, adminClient, mgmtClusterObject)
 		if err != nil {
 			return err
 		}
@@ -580,7 +579,7 @@ func VerifyCluster(client *rancher.Client, config *rancher.Config, cluster *v1.S
 	return nil
 }
 
-func VerifyACE(client *rancher.Client, cluster *mgmtv3.Cluster) error {
+func VerifyACE(ctx context.Context, client *rancher.Client, cluster *mgmtv3.Cluster) error {
 	client, err := client.ReLogin()
 	if err != nil {
 		return err
@@ -596,7 +595,7 @@ func VerifyACE(client *rancher.Client, cluster *mgmtv3.Cluster) error {
 		return err
 	}
 
-	originalResp, err := original.Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace("").List(context.TODO(), metav1.ListOptions{})
+	originalResp, err := original.Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace("").List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return err
 	}
@@ -625,7 +624,7 @@ func VerifyACE(client *rancher.Client, cluster *mgmtv3.Cluster) error {
 			return err
 		}
 
-		resp, err := dynamic.Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace("").List(context.TODO(), metav1.ListOptions{})
+		resp, err := dynamic.Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace("").List(ctx, metav1.ListOptions{})
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,183 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	provv1 "github.com/rancher/rancher/pkg/apis/provisioning.cattle.io/v1"
+	"github.com/rancher/shepherd/clients/rancher"
+	shepherdclusters "github.com/rancher/shepherd/extensions/clusters"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Bounds of waits through a ClusterWatcher, polling is bounded by its number of steps instead
+const (
+	clusterCreationTimeout = 5 * time.Minute
+	clusterImportTimeout   = 30 * time.Minute
+)
+
+var provisioningClusterResource = schema.GroupVersionResource{Group: "provisioning.cattle.io", Version: "v1", Resource: "clusters"}
+
+// ClusterWatcher keeps one shared informer on provisioning Clusters in the fleet namespace, so that onboarding
+// workers wait for cluster conditions with a single watch instead of polling Rancher
+type ClusterWatcher struct {
+	informer cache.SharedIndexInformer
+
+	mu sync.Mutex
+	// waiters are the conditions workers wait for, by cluster name
+	waiters map[string][]*clusterWaiter
+}
+
+// clusterWaiter is a worker waiting for a condition on a cluster
+type clusterWaiter struct {
+	cond func(*provv1.Cluster) bool
+	// done receives the cluster once cond is true, and when that was observed
+	done chan clusterEvent
+}
+
+type clusterEvent struct {
+	cluster *provv1.Cluster
+	time    time.Time
+}
+
+// NewClusterWatcher starts an informer on provisioning Clusters through Rancher and waits for its cache to fill.
+// The informer stops when ctx is done
+func NewClusterWatcher(ctx context.Context, rancherClient *rancher.Client) (*ClusterWatcher, error) {
+	restConfig, err := GetLocalClusterRESTConfig(rancherClient)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating dynamic client for the local cluster: %w", err)
+	}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, fleetNamespace, nil)
+
+	w := &ClusterWatcher{
+		informer: factory.ForResource(provisioningClusterResource).Informer(),
+		waiters:  map[string][]*clusterWaiter{},
+	}
+
+	_, err = w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.notify,
+		UpdateFunc: func(_, obj any) { w.notify(obj) },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while watching provisioning Clusters: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), w.informer.HasSynced) {
+		return nil, fmt.Errorf("error while watching provisioning Clusters: cache did not sync: %w", ctx.Err())
+	}
+
+	return w, nil
+}
+
+// WaitFor blocks until the named cluster satisfies cond, returning it and the time the change was observed
+func (w *ClusterWatcher) WaitFor(ctx context.Context, name string, cond func(*provv1.Cluster) bool) (*provv1.Cluster, time.Time, error) {
+	waiter := &clusterWaiter{cond: cond, done: make(chan clusterEvent, 1)}
+
+	w.mu.Lock()
+	w.waiters[name] = append(w.waiters[name], waiter)
+	w.mu.Unlock()
+
+	defer w.remove(name, waiter)
+
+	// the cluster may already satisfy cond, in which case no further event might come
+	if obj, exists, err := w.informer.GetStore().GetByKey(fleetNamespace + "/" + name); err == nil && exists {
+		w.notify(obj)
+	}
+
+	select {
+	case event := <-waiter.done:
+		return event.cluster, event.time, nil
+	case <-ctx.Done():
+		return nil, time.Time{}, fmt.Errorf("error while waiting for Cluster %s: %w", name, ctx.Err())
+	}
+}
+
+// notify hands a cluster added or updated in the informer to the workers whose condition it satisfies
+func (w *ClusterWatcher) notify(obj any) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+
+	cluster := &provv1.Cluster{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, cluster); err != nil {
+		logrus.Debugf("could not convert provisioning Cluster %s: %v", u.GetName(), err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, waiter := range w.waiters[cluster.Name] {
+		if len(waiter.done) == 0 && waiter.cond(cluster) {
+			waiter.done <- clusterEvent{cluster: cluster, time: now}
+		}
+	}
+}
+
+func (w *ClusterWatcher) remove(name string, waiter *clusterWaiter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	waiters := w.waiters[name]
+	for i, other := range waiters {
+		if other == waiter {
+			w.waiters[name] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(w.waiters[name]) == 0 {
+		delete(w.waiters, name)
+	}
+}
+
+// waitForProvisioningCluster waits until the cluster satisfies cond, through watcher if it is not nil, otherwise by
+// polling Rancher up to steps times. It returns the cluster and when cond was first seen to hold
+func waitForProvisioningCluster(ctx context.Context, rancherClient *rancher.Client, watcher *ClusterWatcher, name, namespace string,
+	steps int, timeout time.Duration, cond func(*provv1.Cluster) bool,
+) (*provv1.Cluster, time.Time, error) {
+	if watcher != nil {
+		watchCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return watcher.WaitFor(watchCtx, name, cond)
+	}
+
+	var cluster *provv1.Cluster
+
+	err := BackoffWaitRetrying(ctx, steps, func() (finished bool, err error) {
+		start := time.Now()
+		cluster, _, err = shepherdclusters.GetProvisioningClusterByName(rancherClient, name, namespace)
+		observeAPICall(start, err)
+
+		if err != nil {
+			return false, fmt.Errorf("error while getting Cluster by Name %s in Namespace %s:\n%w", name, namespace, err)
+		}
+
+		return cond(cluster), nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return cluster, time.Now(), nil
+}
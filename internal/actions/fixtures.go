@@ -0,0 +1,361 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/shepherd/clients/rancher"
+	management "github.com/rancher/shepherd/clients/rancher/generated/management/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// fixtureGlobalRole is bound to fixture users so that they can log in
+const fixtureGlobalRole = "user"
+
+// FixtureSummary counts the objects of one kind a fixtures run created, and the ones previous runs already had. Objects
+// not reached because the run failed are in neither count
+type FixtureSummary struct {
+	Kind     string
+	Created  int
+	Existing int
+}
+
+// fixtureTarget is a project or cluster bindings are created in
+type fixtureTarget struct {
+	// id is a project ID, eg. c-m-abcd1234:p-xyz12, or a cluster ID
+	id        string
+	clusterID string
+}
+
+// CreateFixtures creates the users, projects and role bindings of the dart's fixtures section, skipping the ones that
+// already exist, and returns how many objects of each kind were created
+func CreateFixtures(ctx context.Context, r *dart.Dart, rancherClient *rancher.Client) ([]FixtureSummary, error) {
+	f := r.Fixtures
+
+	clusters, err := fixtureClusters(rancherClient, f.Clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Creating fixtures on %d clusters: %d users, %d projects per cluster", len(clusters), f.Users, f.ProjectsPerCluster)
+
+	var summaries []FixtureSummary
+
+	userIDs, summary, err := createFixtureUsers(ctx, rancherClient, f, r.ChartVariables.UserPassword)
+	summaries = append(summaries, summary...)
+
+	if err != nil {
+		return summaries, err
+	}
+
+	projects, summary, err := createFixtureProjects(ctx, rancherClient, f, clusters)
+	summaries = append(summaries, summary...)
+
+	if err != nil {
+		return summaries, err
+	}
+
+	subjects := map[string][]string{dart.SubjectUser: userIDs}
+	if provider := r.RancherConfig.AuthProvider; provider != nil {
+		subjects[dart.SubjectGroup] = groupPrincipalIDs(*provider)
+	}
+
+	clusterTargets := make([]fixtureTarget, 0, len(clusters))
+	for _, cluster := range clusters {
+		clusterTargets = append(clusterTargets, fixtureTarget{id: cluster.ID, clusterID: cluster.ID})
+	}
+
+	for _, binding := range f.Bindings {
+		targets := projects
+		if binding.Scope == dart.ScopeCluster {
+			targets = clusterTargets
+		}
+
+		summary, err := createFixtureBindings(ctx, rancherClient, f.BatchSize, binding, targets, subjects[binding.Subject])
+		summaries = append(summaries, summary)
+
+		if err != nil {
+			return summaries, err
+		}
+	}
+
+	return summaries, nil
+}
+
+// fixtureClusters returns the management clusters matching selectors, all if there are none, sorted by name
+func fixtureClusters(rancherClient *rancher.Client, selectors []string) ([]management.Cluster, error) {
+	list, err := rancherClient.Management.Cluster.ListAll(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing management Clusters: %w", err)
+	}
+
+	var result []management.Cluster
+
+	for _, cluster := range list.Data {
+		if len(selectors) == 0 || slices.ContainsFunc(selectors, func(s string) bool {
+			prefix, isTemplate := strings.CutPrefix(s, "template=")
+			return s == cluster.Name || (isTemplate && strings.HasPrefix(cluster.Name, prefix+"-"))
+		}) {
+			result = append(result, cluster)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("fixtures: no Rancher clusters match %v", selectors)
+	}
+
+	slices.SortFunc(result, func(a, b management.Cluster) int { return strings.Compare(a.Name, b.Name) })
+
+	return result, nil
+}
+
+// createFixtureUsers creates the fixture users and binds them to the user global role, returning their IDs in order
+func createFixtureUsers(ctx context.Context, rancherClient *rancher.Client, f dart.Fixtures, password string) ([]string, []FixtureSummary, error) {
+	if f.Users == 0 {
+		return nil, nil, nil
+	}
+
+	users, err := rancherClient.Management.User.ListAll(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while listing Users: %w", err)
+	}
+
+	bindings, err := rancherClient.Management.GlobalRoleBinding.ListAll(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while listing GlobalRoleBindings: %w", err)
+	}
+
+	existing := map[string]string{}
+	for _, user := range users.Data {
+		existing[user.Username] = user.ID
+	}
+
+	bound := map[string]bool{}
+
+	for _, binding := range bindings.Data {
+		if binding.GlobalRoleID == fixtureGlobalRole {
+			bound[binding.UserID] = true
+		}
+	}
+
+	ids := make([]string, f.Users)
+	userSummary := FixtureSummary{Kind: "users"}
+	bindingSummary := FixtureSummary{Kind: "global role bindings"}
+
+	var createdUsers, existingUsers, createdBindings, existingBindings atomic.Int32
+
+	enabled := true
+
+	err = shared.Limiter().Each(ctx, f.BatchSize, f.Users, false, func(i int) error {
+		username := fmt.Sprintf("%s-user-%d", f.Prefix, i)
+
+		id, ok := existing[username]
+		if !ok {
+			user, err := rancherClient.Management.User.Create(&management.User{
+				Username: username,
+				Name:     username,
+				Password: password,
+				Enabled:  &enabled,
+			})
+			if err != nil {
+				return fmt.Errorf("error while creating User %s: %w", username, err)
+			}
+
+			id = user.ID
+
+			createdUsers.Add(1)
+		} else {
+			existingUsers.Add(1)
+		}
+
+		ids[i] = id
+
+		if bound[id] {
+			existingBindings.Add(1)
+			return nil
+		}
+
+		_, err := rancherClient.Management.GlobalRoleBinding.Create(&management.GlobalRoleBinding{
+			GlobalRoleID: fixtureGlobalRole,
+			UserID:       id,
+		})
+		if err != nil {
+			return fmt.Errorf("error while binding User %s to global role %s: %w", username, fixtureGlobalRole, err)
+		}
+
+		createdBindings.Add(1)
+
+		return nil
+	})
+
+	userSummary.Created = int(createdUsers.Load())
+	userSummary.Existing = int(existingUsers.Load())
+	bindingSummary.Created = int(createdBindings.Load())
+	bindingSummary.Existing = int(existingBindings.Load())
+
+	return ids, []FixtureSummary{userSummary, bindingSummary}, err
+}
+
+// createFixtureProjects creates the fixture projects in all clusters, returning them in cluster, then index order
+func createFixtureProjects(ctx context.Context, rancherClient *rancher.Client, f dart.Fixtures, clusters []management.Cluster) ([]fixtureTarget, []FixtureSummary, error) {
+	if f.ProjectsPerCluster == 0 {
+		return nil, nil, nil
+	}
+
+	projects, err := rancherClient.Management.Project.ListAll(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while listing Projects: %w", err)
+	}
+
+	existing := map[string]string{}
+	for _, project := range projects.Data {
+		existing[project.ClusterID+"/"+project.Name] = project.ID
+	}
+
+	targets := make([]fixtureTarget, len(clusters)*f.ProjectsPerCluster)
+
+	var created, found atomic.Int32
+
+	err = shared.Limiter().Each(ctx, f.BatchSize, len(targets), false, func(i int) error {
+		cluster := clusters[i/f.ProjectsPerCluster]
+		name := fmt.Sprintf("%s-project-%d", f.Prefix, i%f.ProjectsPerCluster)
+
+		id, ok := existing[cluster.ID+"/"+name]
+		if !ok {
+			project, err := rancherClient.Management.Project.Create(&management.Project{ClusterID: cluster.ID, Name: name})
+			if err != nil {
+				return fmt.Errorf("error while creating Project %s in cluster %s: %w", name, cluster.Name, err)
+			}
+
+			id = project.ID
+
+			created.Add(1)
+		} else {
+			found.Add(1)
+		}
+
+		targets[i] = fixtureTarget{id: id, clusterID: cluster.ID}
+
+		return nil
+	})
+
+	summary := FixtureSummary{Kind: "projects", Created: int(created.Load()), Existing: int(found.Load())}
+
+	return targets, []FixtureSummary{summary}, err
+}
+
+// createFixtureBindings binds binding.PerTarget subjects to the binding's role template in every target. Subjects are
+// user IDs or group principal IDs
+func createFixtureBindings(ctx context.Context, rancherClient *rancher.Client, batchSize int, binding dart.FixtureBinding,
+	targets []fixtureTarget, subjects []string,
+) (FixtureSummary, error) {
+	summary := FixtureSummary{Kind: fmt.Sprintf("%s %s bindings", binding.RoleTemplate, binding.Scope)}
+
+	existing, err := existingRoleTemplateBindings(rancherClient, binding.Scope)
+	if err != nil {
+		return summary, err
+	}
+
+	perTarget := min(binding.PerTarget, len(subjects))
+	total := len(targets) * perTarget
+
+	var created, found atomic.Int32
+
+	err = shared.Limiter().Each(ctx, batchSize, total, false, func(i int) error {
+		target := targets[i/perTarget]
+		subject := subjects[i%len(subjects)]
+
+		userID, groupID := subject, ""
+		if binding.Subject == dart.SubjectGroup {
+			userID, groupID = "", subject
+		}
+
+		if existing[roleTemplateBindingKey(target.id, binding.RoleTemplate, userID, groupID)] {
+			found.Add(1)
+			return nil
+		}
+
+		var err error
+		if binding.Scope == dart.ScopeCluster {
+			_, err = rancherClient.Management.ClusterRoleTemplateBinding.Create(&management.ClusterRoleTemplateBinding{
+				ClusterID:        target.id,
+				RoleTemplateID:   binding.RoleTemplate,
+				UserID:           userID,
+				GroupPrincipalID: groupID,
+			})
+		} else {
+			_, err = rancherClient.Management.ProjectRoleTemplateBinding.Create(&management.ProjectRoleTemplateBinding{
+				ProjectID:        target.id,
+				RoleTemplateID:   binding.RoleTemplate,
+				UserID:           userID,
+				GroupPrincipalID: groupID,
+			})
+		}
+
+		if err != nil {
+			return fmt.Errorf("error while binding %s%s to %s in %s: %w", userID, groupID, binding.RoleTemplate, target.id, err)
+		}
+
+		created.Add(1)
+
+		return nil
+	})
+
+	summary.Created = int(created.Load())
+	summary.Existing = int(found.Load())
+
+	return summary, err
+}
+
+// existingRoleTemplateBindings returns keys of all project or cluster role template bindings, see roleTemplateBindingKey
+func existingRoleTemplateBindings(rancherClient *rancher.Client, scope string) (map[string]bool, error) {
+	result := map[string]bool{}
+
+	if scope == dart.ScopeCluster {
+		list, err := rancherClient.Management.ClusterRoleTemplateBinding.ListAll(nil)
+		if err != nil {
+			return nil, fmt.Errorf("error while listing ClusterRoleTemplateBindings: %w", err)
+		}
+
+		for _, b := range list.Data {
+			result[roleTemplateBindingKey(b.ClusterID, b.RoleTemplateID, b.UserID, b.GroupPrincipalID)] = true
+		}
+
+		return result, nil
+	}
+
+	list, err := rancherClient.Management.ProjectRoleTemplateBinding.ListAll(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing ProjectRoleTemplateBindings: %w", err)
+	}
+
+	for _, b := range list.Data {
+		result[roleTemplateBindingKey(b.ProjectID, b.RoleTemplateID, b.UserID, b.GroupPrincipalID)] = true
+	}
+
+	return result, nil
+}
+
+func roleTemplateBindingKey(targetID, roleTemplateID, userID, groupPrincipalID string) string {
+	return strings.Join([]string{targetID, roleTemplateID, userID, groupPrincipalID}, "|")
+}
+
+// groupPrincipalIDs returns the principal IDs of the groups an auth provider of rancher_config is seeded with
+func groupPrincipalIDs(provider dart.AuthProvider) []string {
+	result := make([]string, 0, provider.Groups)
+
+	for g := range provider.Groups {
+		if provider.Type == dart.AuthKeycloak {
+			result = append(result, fmt.Sprintf("keycloakoidc_group://group-%d", g))
+		} else {
+			result = append(result, fmt.Sprintf("openldap_group://cn=group-%d,ou=groups,%s", g, LDAPBaseDN))
+		}
+	}
+
+	return result
+}
@@ -1,9 +1,16 @@
 package actions
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
@@ -11,6 +18,50 @@ import (
 
 // ClusterStatus holds the state of each cluster.
 type ClusterStatus struct {
+	Name string `yaml:"name"`
+	// RancherName is the name of the provisioning Cluster in Rancher, recorded once it is created. Clusters
+	// provisioned by Rancher get a generated name, which differs from Name
+	RancherName string `yaml:"rancher_name,omitempty"`
+	// Template is the name_prefix of the cluster template the cluster is provisioned from, empty for other clusters
+	Template string       `yaml:"template,omitempty"`
+	History  []StageEvent `yaml:"history"`
+	Failed   bool         `yaml:"failed"`
+	Error    string       `yaml:"error,omitempty"`
+	Attempts int          `yaml:"attempts"`
+	Stage    Stage        `yaml:"stage"`
+	// KubernetesUpgrades records Kubernetes version upgrades of the cluster, oldest first
+	KubernetesUpgrades []KubernetesUpgrade `yaml:"kubernetes_upgrades,omitempty"`
+}
+
+// KubernetesUpgrade records one attempt at changing the Kubernetes version of a cluster
+type KubernetesUpgrade struct {
+	Start    time.Time     `yaml:"start"`
+	From     string        `yaml:"from"`
+	To       string        `yaml:"to"`
+	Error    string        `yaml:"error,omitempty"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// StageEvent records when a cluster reached a Stage
+type StageEvent struct {
+	Time  time.Time `yaml:"time"`
+	Stage Stage     `yaml:"stage"`
+}
+
+const ClustersStateFile = "clusters_state.yaml"
+
+// clusterStateVersion is the current version of the Cluster state file schema.
+// Version 1 files are a bare map of ClusterStatus with one bool per Stage, and no version field
+const clusterStateVersion = 2
+
+// clusterStateFile is the on-disk format of the Cluster state file
+type clusterStateFile struct {
+	Version  int                       `yaml:"version"`
+	Clusters map[string]*ClusterStatus `yaml:"clusters"`
+}
+
+// clusterStatusV1 is ClusterStatus as persisted in version 1 state files
+type clusterStatusV1 struct {
 	Name        string `yaml:"name"`
 	New         bool   `yaml:"new"`
 	Infra       bool   `yaml:"infra"`
@@ -18,11 +69,11 @@ type ClusterStatus struct {
 	Imported    bool   `yaml:"imported"`
 	Provisioned bool   `yaml:"provisioned"`
 	Registered  bool   `yaml:"registered"`
-	Stage       Stage  `yaml:"stage"`
+	Failed      bool   `yaml:"failed"`
+	Error       string `yaml:"error,omitempty"`
+	Attempts    int    `yaml:"attempts"`
 }
 
-const ClustersStateFile = "clusters_state.yaml"
-
 // Setup an "enum" for handling stateUpdate "Stage" logic
 // See https://gobyexample.com/enums
 type Stage int
@@ -36,6 +87,13 @@ const (
 	StageRegistered               // Cluster has been registered
 )
 
+// stageTransitions lists the stages that can directly follow each stage
+var stageTransitions = map[Stage][]Stage{
+	StageNew:     {StageInfra, StageCreated},
+	StageInfra:   {StageCreated},
+	StageCreated: {StageImported, StageProvisioned, StageRegistered},
+}
+
 // Gives a human-readable name for the Stage.
 func (s Stage) String() string {
 	switch s {
@@ -57,26 +115,149 @@ func (s Stage) String() string {
 	}
 }
 
+// MarshalYAML persists a Stage by name
+func (s Stage) MarshalYAML() (any, error) {
+	return s.String(), nil
+}
+
+// UnmarshalYAML accepts a Stage by name or, as in version 1 state files, by number
+func (s *Stage) UnmarshalYAML(unmarshal func(any) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	if n, err := strconv.Atoi(value); err == nil {
+		*s = Stage(n)
+		return nil
+	}
+
+	for stage := StageNew; stage <= StageRegistered; stage++ {
+		if strings.EqualFold(stage.String(), value) {
+			*s = stage
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown Cluster stage %q", value)
+}
+
+// Reached returns true if the cluster went through stage
+func (cs *ClusterStatus) Reached(stage Stage) bool {
+	return slices.ContainsFunc(cs.History, func(e StageEvent) bool {
+		return e.Stage == stage
+	})
+}
+
+// ProvisioningName returns the name of the cluster's provisioning Cluster in Rancher. State files written before
+// it was recorded only have Name, which is also the Rancher name of imported and custom clusters
+func (cs *ClusterStatus) ProvisioningName() string {
+	if cs.RancherName != "" {
+		return cs.RancherName
+	}
+
+	return cs.Name
+}
+
+// Advance records that the cluster reached stage at time t. Reaching an already reached stage again is a no-op,
+// while skipping or going back to an earlier stage is an error
+func (cs *ClusterStatus) Advance(stage Stage, t time.Time) error {
+	if cs.Reached(stage) {
+		return nil
+	}
+
+	if len(cs.History) == 0 {
+		if stage != StageNew {
+			return fmt.Errorf("Cluster %s: first stage must be %s, got %s", cs.Name, StageNew, stage)
+		}
+	} else if !slices.Contains(stageTransitions[cs.Stage], stage) {
+		return fmt.Errorf("Cluster %s: invalid stage transition %s -> %s", cs.Name, cs.Stage, stage)
+	}
+
+	cs.History = append(cs.History, StageEvent{Stage: stage, Time: t})
+	cs.Stage = stage
+
+	return nil
+}
+
+// migrate converts a version 1 ClusterStatus, whose stage history has no times
+func (v1 *clusterStatusV1) migrate() *ClusterStatus {
+	cs := &ClusterStatus{
+		Name:     v1.Name,
+		Failed:   v1.Failed,
+		Error:    v1.Error,
+		Attempts: v1.Attempts,
+	}
+
+	reached := []struct {
+		stage Stage
+		ok    bool
+	}{
+		{StageNew, v1.New},
+		{StageInfra, v1.Infra},
+		{StageCreated, v1.Created},
+		{StageImported, v1.Imported},
+		{StageProvisioned, v1.Provisioned},
+		{StageRegistered, v1.Registered},
+	}
+
+	for _, r := range reached {
+		if r.ok {
+			cs.History = append(cs.History, StageEvent{Stage: r.stage})
+			cs.Stage = r.stage
+		}
+	}
+
+	return cs
+}
+
 // SaveClusterState persists the map[string]*ClusterStatus to a YAML file.
+// The file is replaced atomically, so that a crash never leaves it half-written
 func SaveClusterState(filePath string, statuses map[string]*ClusterStatus) error {
-	data, err := yaml.Marshal(statuses)
+	data, err := yaml.Marshal(clusterStateFile{Version: clusterStateVersion, Clusters: statuses})
 	if err != nil {
 		return fmt.Errorf("failed to marshal Cluster state: %w", err)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("failed to create Cluster state directory: %w", err)
 	}
 
-	if err := os.WriteFile(filePath, data, 0o644); err != nil {
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary Cluster state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
 		return fmt.Errorf("failed to write Cluster state file: %w", err)
 	}
 
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync Cluster state file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close Cluster state file: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to chmod Cluster state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to replace Cluster state file: %w", err)
+	}
+
 	return nil
 }
 
 // LoadClusterState reads the YAML state file and unmarshals into map[string]*ClusterStatus.
 // If the file does not exist, it returns an empty map[string]*ClusterStatus without error.
+// Files written with older schema versions are migrated to the current one
 func LoadClusterState(filePath string) (map[string]*ClusterStatus, error) {
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		logrus.Infof("Did not find existing Cluster state file at %s. Creating new Cluster state file and returning new empty map[string]*ClusterStatus", filePath)
@@ -95,12 +276,78 @@ func LoadClusterState(filePath string) (map[string]*ClusterStatus, error) {
 		return nil, fmt.Errorf("failed to os.ReadFile Cluster state file at %s: %w", filePath, err)
 	}
 
-	var statuses map[string]*ClusterStatus
-	if err := yaml.Unmarshal(data, &statuses); err != nil {
+	var header struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal state: %w", err)
 	}
 
-	return statuses, nil
+	switch header.Version {
+	case 0:
+		var v1Statuses map[string]*clusterStatusV1
+		if err := yaml.Unmarshal(data, &v1Statuses); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal version 1 state: %w", err)
+		}
+
+		logrus.Infof("Migrating Cluster state file at %s to version %d", filePath, clusterStateVersion)
+
+		statuses := make(map[string]*ClusterStatus, len(v1Statuses))
+		for name, v1 := range v1Statuses {
+			statuses[name] = v1.migrate()
+		}
+
+		return statuses, nil
+	case clusterStateVersion:
+		var state clusterStateFile
+		if err := yaml.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal state: %w", err)
+		}
+
+		if state.Clusters == nil {
+			state.Clusters = map[string]*ClusterStatus{}
+		}
+
+		return state.Clusters, nil
+	default:
+		return nil, fmt.Errorf("unsupported Cluster state file version %d at %s, a newer dartboard is needed", header.Version, filePath)
+	}
+}
+
+// LockClusterState takes an advisory lock on the state file at filePath, so that only one dartboard process
+// at a time works on a workspace. The returned function releases the lock
+func LockClusterState(filePath string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create Cluster state directory: %w", err)
+	}
+
+	lockPath := filePath + ".lock"
+
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Cluster state lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lockFile.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			pid, _ := os.ReadFile(lockPath)
+			return nil, fmt.Errorf("Cluster state at %s is in use by another dartboard process (pid %s)", filePath, strings.TrimSpace(string(pid)))
+		}
+
+		return nil, fmt.Errorf("failed to lock Cluster state file: %w", err)
+	}
+
+	// record who holds the lock, for the error message above
+	if err := lockFile.Truncate(0); err == nil {
+		_, _ = lockFile.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return func() {
+		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
 }
 
 func DestroyClusterState(filePath string) error {
@@ -155,3 +402,20 @@ func FindOrCreateStatusByName(statuses map[string]*ClusterStatus, name string) *
 
 	return clusterStatus
 }
+
+// FailedClusterStatuses returns, sorted by name, the statuses of clusters whose last attempt failed
+func FailedClusterStatuses(statuses map[string]*ClusterStatus) []*ClusterStatus {
+	var failed []*ClusterStatus
+
+	for _, cs := range statuses {
+		if cs.Failed {
+			failed = append(failed, cs)
+		}
+	}
+
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].Name < failed[j].Name
+	})
+
+	return failed
+}
@@ -0,0 +1,284 @@
+package actions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadClusterStateMigratesV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ClustersStateFile)
+
+	v1 := `downstream-0-0:
+  name: downstream-0-0
+  new: true
+  infra: true
+  created: true
+  imported: true
+  attempts: 2
+downstream-0-1:
+  name: downstream-0-1
+  new: true
+  created: true
+  failed: true
+  error: timed out
+  attempts: 1
+`
+	if err := os.WriteFile(path, []byte(v1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := LoadClusterState(path)
+	if err != nil {
+		t.Fatalf("LoadClusterState() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		stage    Stage
+		history  []Stage
+		failed   bool
+		errMsg   string
+		attempts int
+	}{
+		{name: "downstream-0-0", stage: StageImported, history: []Stage{StageNew, StageInfra, StageCreated, StageImported}, attempts: 2},
+		{name: "downstream-0-1", stage: StageCreated, history: []Stage{StageNew, StageCreated}, failed: true, errMsg: "timed out", attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := statuses[tt.name]
+			if cs == nil {
+				t.Fatalf("no status for %s", tt.name)
+			}
+
+			if cs.Stage != tt.stage {
+				t.Errorf("Stage = %s, want %s", cs.Stage, tt.stage)
+			}
+
+			if len(cs.History) != len(tt.history) {
+				t.Fatalf("History = %v, want stages %v", cs.History, tt.history)
+			}
+
+			for i, stage := range tt.history {
+				if cs.History[i].Stage != stage || !cs.History[i].Time.IsZero() {
+					t.Errorf("History[%d] = %v, want stage %s without time", i, cs.History[i], stage)
+				}
+			}
+
+			if cs.Failed != tt.failed || cs.Error != tt.errMsg || cs.Attempts != tt.attempts {
+				t.Errorf("Failed, Error, Attempts = %v, %q, %d, want %v, %q, %d",
+					cs.Failed, cs.Error, cs.Attempts, tt.failed, tt.errMsg, tt.attempts)
+			}
+		})
+	}
+
+	// migrated statuses are saved in the current version and read back unchanged
+	if err := SaveClusterState(path, statuses); err != nil {
+		t.Fatalf("SaveClusterState() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(data), "version: 2\n") {
+		t.Errorf("saved state does not start with the version:\n%s", data)
+	}
+
+	reloaded, err := LoadClusterState(path)
+	if err != nil {
+		t.Fatalf("LoadClusterState() error = %v", err)
+	}
+
+	if got := reloaded["downstream-0-0"]; got == nil || got.Stage != StageImported || len(got.History) != 4 {
+		t.Errorf("reloaded status = %+v, want it Imported with 4 history entries", got)
+	}
+}
+
+func TestLoadClusterStateRejectsNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ClustersStateFile)
+
+	if err := os.WriteFile(path, []byte("version: 3\nclusters: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadClusterState(path); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("LoadClusterState() error = %v, want unsupported version", err)
+	}
+}
+
+func TestLoadClusterStateCreatesMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workspace", ClustersStateFile)
+
+	statuses, err := LoadClusterState(path)
+	if err != nil {
+		t.Fatalf("LoadClusterState() error = %v", err)
+	}
+
+	if len(statuses) != 0 {
+		t.Errorf("LoadClusterState() = %v, want no statuses", statuses)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("state file was not created: %v", err)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		history []Stage
+		stage   Stage
+		wantErr bool
+		want    Stage
+	}{
+		{name: "first stage", history: nil, stage: StageNew, want: StageNew},
+		{name: "first stage not new", history: nil, stage: StageCreated, wantErr: true},
+		{name: "new to infra", history: []Stage{StageNew}, stage: StageInfra, want: StageInfra},
+		{name: "new to created", history: []Stage{StageNew}, stage: StageCreated, want: StageCreated},
+		{name: "infra to created", history: []Stage{StageNew, StageInfra}, stage: StageCreated, want: StageCreated},
+		{name: "created to imported", history: []Stage{StageNew, StageCreated}, stage: StageImported, want: StageImported},
+		{name: "created to provisioned", history: []Stage{StageNew, StageCreated}, stage: StageProvisioned, want: StageProvisioned},
+		{name: "created to registered", history: []Stage{StageNew, StageCreated}, stage: StageRegistered, want: StageRegistered},
+		{name: "skip created", history: []Stage{StageNew}, stage: StageImported, wantErr: true},
+		{name: "infra to imported", history: []Stage{StageNew, StageInfra}, stage: StageImported, wantErr: true},
+		{name: "back to infra", history: []Stage{StageNew, StageCreated}, stage: StageInfra, wantErr: true},
+		{name: "after imported", history: []Stage{StageNew, StageCreated, StageImported}, stage: StageProvisioned, wantErr: true},
+		{name: "already reached", history: []Stage{StageNew, StageCreated, StageImported}, stage: StageNew, want: StageImported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &ClusterStatus{Name: "c"}
+			for _, stage := range tt.history {
+				cs.History = append(cs.History, StageEvent{Stage: stage, Time: t0})
+				cs.Stage = stage
+			}
+
+			before := len(cs.History)
+
+			err := cs.Advance(tt.stage, t0.Add(time.Minute))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Advance(%s) error = %v, wantErr %v", tt.stage, err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if len(cs.History) != before || (before > 0 && cs.Stage != tt.history[before-1]) {
+					t.Errorf("failed Advance(%s) changed the status: %+v", tt.stage, cs)
+				}
+
+				return
+			}
+
+			if cs.Stage != tt.want {
+				t.Errorf("Stage = %s, want %s", cs.Stage, tt.want)
+			}
+
+			if last := cs.History[len(cs.History)-1]; last.Stage != tt.want {
+				t.Errorf("last history entry = %v, want stage %s", last, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyUpdateRejectsInvalidTransition(t *testing.T) {
+	cs := &ClusterStatus{Name: "c"}
+
+	if err := applyUpdate(cs, stateUpdate{Name: "c", Stage: StageNew, Completed: time.Now()}); err != nil {
+		t.Fatalf("applyUpdate(New) error = %v", err)
+	}
+
+	if err := applyUpdate(cs, stateUpdate{Name: "c", Stage: StageImported, Completed: time.Now(), RancherName: "c"}); err == nil {
+		t.Fatal("applyUpdate(New -> Imported) succeeded, want an error")
+	}
+
+	if cs.Stage != StageNew || cs.RancherName != "" {
+		t.Errorf("rejected update changed the status: %+v", cs)
+	}
+
+	if err := applyUpdate(nil, stateUpdate{Name: "missing", Stage: StageNew}); err == nil {
+		t.Error("applyUpdate() of an unknown cluster succeeded, want an error")
+	}
+}
+
+func TestSaveClusterStateReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ClustersStateFile)
+
+	for attempts := 1; attempts <= 3; attempts++ {
+		statuses := map[string]*ClusterStatus{"c": {Name: "c", Attempts: attempts}}
+		if err := SaveClusterState(path, statuses); err != nil {
+			t.Fatalf("SaveClusterState() error = %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != ClustersStateFile {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+
+		t.Errorf("directory contains %v, want only %s without leftover temporary files", names, ClustersStateFile)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("state file mode = %v, want 0644", info.Mode().Perm())
+	}
+
+	statuses, err := LoadClusterState(path)
+	if err != nil {
+		t.Fatalf("LoadClusterState() error = %v", err)
+	}
+
+	if cs := statuses["c"]; cs == nil || cs.Attempts != 3 {
+		t.Errorf("LoadClusterState() = %+v, want the last saved status", cs)
+	}
+}
+
+func TestSaveClusterStateKeepsFileOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ClustersStateFile)
+
+	if err := SaveClusterState(path, map[string]*ClusterStatus{"c": {Name: "c", Attempts: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+
+	// the temporary file cannot be created next to the state file
+	if err := os.Chmod(dir, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0o755) //nolint:errcheck
+
+	if err := SaveClusterState(path, map[string]*ClusterStatus{"c": {Name: "c", Attempts: 2}}); err == nil {
+		t.Fatal("SaveClusterState() succeeded in a read-only directory")
+	}
+
+	statuses, err := LoadClusterState(path)
+	if err != nil {
+		t.Fatalf("LoadClusterState() error = %v", err)
+	}
+
+	if cs := statuses["c"]; cs == nil || cs.Attempts != 1 {
+		t.Errorf("LoadClusterState() = %+v, want the previously saved status", cs)
+	}
+}
@@ -0,0 +1,78 @@
+package actions
+
+import (
+	"fmt"
+
+	provv1 "github.com/rancher/rancher/pkg/apis/provisioning.cattle.io/v1"
+	"github.com/rancher/shepherd/clients/rancher"
+	v1 "github.com/rancher/shepherd/clients/rancher/v1"
+	shepherdclusters "github.com/rancher/shepherd/extensions/clusters"
+)
+
+// clusterConditionConnected is the management Cluster condition tracking the cattle-cluster-agent connection
+const clusterConditionConnected = "Connected"
+
+// ClusterHealth is the live state of a cluster as seen by Rancher
+type ClusterHealth struct {
+	// ID is the management Cluster ID, eg. c-m-abcd1234
+	ID        string `json:"id"`
+	Ready     bool   `json:"ready"`
+	Connected bool   `json:"connected"`
+	Nodes     int64  `json:"nodes"`
+	// AgentImage is the cattle-cluster-agent image Rancher deployed on the cluster
+	AgentImage string `json:"agentImage,omitempty"`
+	// Conditions lists provisioning Cluster conditions that are not True, as "Type: message"
+	Conditions []string `json:"conditions,omitempty"`
+}
+
+// ListClusterHealth returns the health of all clusters known to Rancher, by provisioning Cluster name
+func ListClusterHealth(client *rancher.Client) (map[string]ClusterHealth, error) {
+	provClusters, err := client.Steve.SteveType(shepherdclusters.ProvisioningSteveResourceType).ListAll(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing provisioning Clusters: %w", err)
+	}
+
+	mgmtClusters, err := client.Management.Cluster.ListAll(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing management Clusters: %w", err)
+	}
+
+	result := make(map[string]ClusterHealth, len(provClusters.Data))
+
+	for _, obj := range provClusters.Data {
+		status := &provv1.ClusterStatus{}
+		if err := v1.ConvertToK8sType(obj.Status, status); err != nil {
+			return nil, fmt.Errorf("error while reading status of Cluster %s: %w", obj.Name, err)
+		}
+
+		health := ClusterHealth{
+			ID:    status.ClusterName,
+			Ready: status.Ready,
+		}
+
+		for _, condition := range status.Conditions {
+			if condition.Status != "True" && condition.Message != "" {
+				health.Conditions = append(health.Conditions, condition.Type+": "+condition.Message)
+			}
+		}
+
+		for _, mgmtCluster := range mgmtClusters.Data {
+			if mgmtCluster.ID != status.ClusterName {
+				continue
+			}
+
+			health.Nodes = mgmtCluster.NodeCount
+			health.AgentImage = mgmtCluster.AgentImage
+
+			for _, condition := range mgmtCluster.Conditions {
+				if condition.Type == clusterConditionConnected {
+					health.Connected = condition.Status == "True"
+				}
+			}
+		}
+
+		result[obj.Name] = health
+	}
+
+	return result, nil
+}
@@ -0,0 +1,185 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rancher/dartboard/internal/shared"
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"github.com/rancher/shepherd/clients/rancher"
+	v1 "github.com/rancher/shepherd/clients/rancher/v1"
+	"github.com/rancher/shepherd/pkg/clientbase"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// clusterRegistrationTokenSteveType is the Steve type of the tokens Rancher generates import manifests for
+const clusterRegistrationTokenSteveType = "management.cattle.io.clusterregistrationtoken"
+
+// importFieldManager owns the fields of objects applied from import manifests
+const importFieldManager = "dartboard"
+
+// manifestTimeout bounds downloading an import manifest
+const manifestTimeout = time.Minute
+
+// importClusterWithManifest downloads the registration manifest Rancher generated for the management cluster
+// clusterID and applies it to the cluster at restConfig with server-side apply, instead of running a Job there
+func importClusterWithManifest(ctx context.Context, rancherClient *rancher.Client, rancherConfig *rancher.Config, clusterID string,
+	restConfig *rest.Config,
+) error {
+	manifestURL, err := waitForManifestURL(ctx, rancherClient, clusterID)
+	if err != nil {
+		return err
+	}
+
+	var manifest []byte
+
+	err = shared.Retry().Do(ctx, "download of import manifest of Cluster "+clusterID, func() error {
+		manifest, err = downloadManifest(ctx, rancherConfig, manifestURL)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return shared.Retry().Do(ctx, "apply of import manifest of Cluster "+clusterID, func() error {
+		return serverSideApply(ctx, restConfig, manifest)
+	})
+}
+
+// waitForManifestURL waits for Rancher to generate the default registration token of the management cluster
+// clusterID, returning the URL of its import manifest
+func waitForManifestURL(ctx context.Context, rancherClient *rancher.Client, clusterID string) (string, error) {
+	var manifestURL string
+
+	err := BackoffWaitRetrying(ctx, 30, func() (bool, error) {
+		start := time.Now()
+		token, err := rancherClient.Steve.SteveType(clusterRegistrationTokenSteveType).ByID(clusterID + "/default-token")
+		observeAPICall(start, err)
+
+		if clientbase.IsNotFound(err) {
+			// the token is created asynchronously after the Cluster
+			return false, nil
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("error while getting the registration token of Cluster %s: %w", clusterID, err)
+		}
+
+		status := &managementv3.ClusterRegistrationTokenStatus{}
+		if err := v1.ConvertToK8sType(token.Status, status); err != nil {
+			return false, fmt.Errorf("error while reading registration token of Cluster %s: %w", clusterID, err)
+		}
+
+		manifestURL = status.ManifestURL
+
+		return manifestURL != "", nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error while waiting for the registration token of Cluster %s: %w", clusterID, err)
+	}
+
+	return manifestURL, nil
+}
+
+// downloadManifest gets an import manifest. Its URL uses Rancher's server-url, as seen from downstream clusters, so it
+// is requested from the host this machine reaches Rancher at instead
+func downloadManifest(ctx context.Context, rancherConfig *rancher.Config, manifestURL string) ([]byte, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid import manifest URL %q: %w", manifestURL, err)
+	}
+
+	u.Scheme = "https"
+	u.Host = rancherConfig.Host
+
+	insecure := rancherConfig.Insecure != nil && *rancherConfig.Insecure
+	client := &http.Client{
+		Timeout:   manifestTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}}, //nolint:gosec
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while downloading import manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error while downloading import manifest: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error while downloading import manifest: got %s", resp.Status)
+	}
+
+	return body, nil
+}
+
+// serverSideApply applies all objects of a multi-document YAML manifest, in order, to the cluster at restConfig
+func serverSideApply(ctx context.Context, restConfig *rest.Config, manifest []byte) error {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("error while creating discovery client: %w", err)
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("error while creating dynamic client: %w", err)
+	}
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("error while decoding import manifest: %w", err)
+		}
+
+		// empty documents, eg. after a trailing ---
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		gvk := obj.GroupVersionKind()
+
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return fmt.Errorf("error while mapping %s: %w", gvk, err)
+		}
+
+		var resource dynamic.ResourceInterface = client.Resource(mapping.Resource)
+		if mapping.Scope.Name() == "namespace" {
+			resource = client.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+
+		_, err = resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: importFieldManager, Force: true})
+		if err != nil {
+			return fmt.Errorf("error while applying %s %s: %w", gvk.Kind, obj.GetName(), err)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package actions
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/rancher/dartboard/internal/tofu"
 	"github.com/rancher/shepherd/clients/rancher"
 	yaml "gopkg.in/yaml.v2"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 type Kubeconfig struct {
@@ -98,7 +102,8 @@ func GetRESTConfigFromPath(kubeconfigPath string) (*rest.Config, error) {
 	return restConfig, nil
 }
 
-func GetRESTConfigForClusterID(rancherClient *rancher.Client, id string) (*rest.Config, error) {
+// GetKubeconfigForClusterID returns a kubeconfig for a cluster known to Rancher, going through Rancher's proxy
+func GetKubeconfigForClusterID(rancherClient *rancher.Client, id string) ([]byte, error) {
 	cluster, err := rancherClient.Management.Cluster.ByID(id)
 	if err != nil {
 		return nil, fmt.Errorf("error while getting Cluster by ID %s: %v", id, err)
@@ -109,7 +114,14 @@ func GetRESTConfigForClusterID(rancherClient *rancher.Client, id string) (*rest.
 		return nil, fmt.Errorf("error while generating Kubeconfig for Cluster with ID %s: %v", id, err)
 	}
 
-	configBytes := []byte(output.Config)
+	return []byte(output.Config), nil
+}
+
+func GetRESTConfigForClusterID(rancherClient *rancher.Client, id string) (*rest.Config, error) {
+	configBytes, err := GetKubeconfigForClusterID(rancherClient, id)
+	if err != nil {
+		return nil, err
+	}
 
 	restConfig, err := clientcmd.RESTConfigFromKubeConfig(configBytes)
 	if err != nil {
@@ -122,3 +134,49 @@ func GetRESTConfigForClusterID(rancherClient *rancher.Client, id string) (*rest.
 func GetLocalClusterRESTConfig(rancherClient *rancher.Client) (*rest.Config, error) {
 	return GetRESTConfigForClusterID(rancherClient, "local")
 }
+
+// WriteContextKubeconfig writes to dst a self-contained kubeconfig with only kubeContext of the kubeconfig at src,
+// or its current context if kubeContext is empty, so that tools which ignore contexts use the right cluster. It returns
+// the name of the context written
+func WriteContextKubeconfig(src, kubeContext, dst string) (string, error) {
+	if rest, ok := strings.CutPrefix(src, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("error while expanding %s: %w", src, err)
+		}
+
+		src = filepath.Join(home, rest)
+	}
+
+	config, err := clientcmd.LoadFromFile(src)
+	if err != nil {
+		return "", fmt.Errorf("error while loading kubeconfig %s: %w", src, err)
+	}
+
+	if kubeContext != "" {
+		if _, ok := config.Contexts[kubeContext]; !ok {
+			return "", fmt.Errorf("kubeconfig %s has no context %s", src, kubeContext)
+		}
+
+		config.CurrentContext = kubeContext
+	}
+
+	if config.CurrentContext == "" {
+		return "", fmt.Errorf("kubeconfig %s has no current context", src)
+	}
+
+	if err := clientcmdapi.MinifyConfig(config); err != nil {
+		return "", fmt.Errorf("error while extracting context from kubeconfig %s: %w", src, err)
+	}
+
+	// certificate and key files are inlined, dst may be read from another directory
+	if err := clientcmdapi.FlattenConfig(config); err != nil {
+		return "", fmt.Errorf("error while inlining files of kubeconfig %s: %w", src, err)
+	}
+
+	if err := clientcmd.WriteToFile(*config, dst); err != nil {
+		return "", fmt.Errorf("error while writing kubeconfig %s: %w", dst, err)
+	}
+
+	return config.CurrentContext, nil
+}
@@ -0,0 +1,163 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kwait "k8s.io/apimachinery/pkg/util/wait"
+	kretry "k8s.io/client-go/util/retry"
+)
+
+// KubernetesUpgradeJob changes the Kubernetes version of a provisioned or custom cluster
+type KubernetesUpgradeJob struct {
+	Name string
+	// RancherName is the name of the cluster's provisioning Cluster in Rancher
+	RancherName string
+	Version     string
+	// Timeout is how long the cluster gets to complete the upgrade
+	Timeout time.Duration
+}
+
+// UpgradeKubernetesInBatches changes the Kubernetes version of the named clusters to version through Rancher's
+// provisioning API, in batches of r.ClusterBatchSize. Each attempt is recorded in the Cluster state file
+func UpgradeKubernetesInBatches(ctx context.Context, r *dart.Dart, rancherClient *rancher.Client, names []string, version string,
+	timeout time.Duration,
+) error {
+	clusterStatePath := fmt.Sprintf("%s/%s", r.TofuWorkspaceStatePath, ClustersStateFile)
+
+	statuses, err := LoadClusterState(clusterStatePath)
+	if err != nil {
+		return err
+	}
+
+	jobs := make([]KubernetesUpgradeJob, 0, len(names))
+
+	for _, name := range names {
+		cs := FindClusterStatusByName(statuses, name)
+		if cs == nil || !cs.Reached(StageCreated) {
+			return fmt.Errorf("cluster %s was not created in Rancher", name)
+		}
+
+		jobs = append(jobs, KubernetesUpgradeJob{Name: name, RancherName: cs.ProvisioningName(), Version: version, Timeout: timeout})
+	}
+
+	for batch := range slices.Chunk(jobs, max(r.ClusterBatchSize, 1)) {
+		batchRunner := NewSequencedBatchRunner[KubernetesUpgradeJob](len(batch), r.FailurePolicy, r.Failures, false)
+
+		if err := batchRunner.Run(ctx, batch, statuses, clusterStatePath, rancherClient, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func upgradeKubernetesWithRunner[J JobDataTypes](ctx context.Context, br *SequencedBatchRunner[J], job KubernetesUpgradeJob,
+	rancherClient *rancher.Client,
+) (skipped bool, err error) {
+	provClient, err := rancherClient.GetKubeAPIProvisioningClient()
+	if err != nil {
+		return false, fmt.Errorf("error while getting provisioning client: %w", err)
+	}
+
+	cluster, err := provClient.Clusters(fleetNamespace).Get(ctx, job.RancherName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("error while getting Cluster %s (%s): %w", job.Name, job.RancherName, err)
+	}
+
+	if cluster.Spec.RKEConfig == nil {
+		return false, fmt.Errorf("cluster %s is not provisioned by Rancher, its Kubernetes version cannot be changed", job.Name)
+	}
+
+	from := cluster.Spec.KubernetesVersion
+	if from == job.Version {
+		logrus.Infof("Cluster %s already runs Kubernetes %s, skipping...", job.Name, job.Version)
+		return true, nil
+	}
+
+	release, err := shared.Limiter().Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	logrus.Infof("Upgrading cluster %s from Kubernetes %s to %s", job.Name, from, job.Version)
+
+	start := time.Now()
+
+	err = kretry.RetryOnConflict(kretry.DefaultRetry, func() error {
+		cluster, err := provClient.Clusters(fleetNamespace).Get(ctx, job.RancherName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		cluster.Spec.KubernetesVersion = job.Version
+
+		_, err = provClient.Clusters(fleetNamespace).Update(ctx, cluster, metav1.UpdateOptions{})
+
+		return err
+	})
+	observeAPICall(start, err)
+
+	if err == nil {
+		err = waitForKubernetesVersion(ctx, rancherClient, job)
+	}
+
+	upgrade := &KubernetesUpgrade{Start: start, From: from, To: job.Version, Duration: time.Since(start).Round(time.Second)}
+	if err != nil {
+		upgrade.Error = err.Error()
+	}
+
+	// interrupted upgrades are left to the next run
+	if ctx.Err() == nil {
+		if recordErr := br.record(stateUpdate{Name: job.Name, Completed: time.Now(), Upgrade: upgrade}); recordErr != nil {
+			return false, errors.Join(err, recordErr)
+		}
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("error while upgrading cluster %s to Kubernetes %s: %w", job.Name, job.Version, err)
+	}
+
+	logrus.Infof("Cluster %s upgraded to Kubernetes %s in %s", job.Name, job.Version, upgrade.Duration)
+
+	return false, nil
+}
+
+// waitForKubernetesVersion waits until the cluster API server reports the job version and the cluster is ready
+func waitForKubernetesVersion(ctx context.Context, rancherClient *rancher.Client, job KubernetesUpgradeJob) error {
+	provClient, err := rancherClient.GetKubeAPIProvisioningClient()
+	if err != nil {
+		return fmt.Errorf("error while getting provisioning client: %w", err)
+	}
+
+	return kwait.PollUntilContextTimeout(ctx, 10*time.Second, job.Timeout, false, func(ctx context.Context) (bool, error) {
+		cluster, err := provClient.Clusters(fleetNamespace).Get(ctx, job.RancherName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, err
+		} else if err != nil {
+			// transient errors are retried until the timeout
+			return false, nil
+		}
+
+		if !cluster.Status.Ready || cluster.Status.ClusterName == "" {
+			return false, nil
+		}
+
+		mgmtCluster, err := rancherClient.Management.Cluster.ByID(cluster.Status.ClusterName)
+		if err != nil {
+			return false, nil
+		}
+
+		return mgmtCluster.Version != nil && mgmtCluster.Version.GitVersion == job.Version, nil
+	})
+}
@@ -15,7 +15,7 @@ const (
 
 // StatusPods is a helper function that uses the steve client to list pods on a namespace for a specific cluster
 // and return the statuses in a list of strings
-func StatusPodsWithTimeout(client *rancher.Client, clusterID string, timeout time.Duration) []error {
+func StatusPodsWithTimeout(ctx context.Context, client *rancher.Client, clusterID string, timeout time.Duration) []error {
 	downstreamClient, err := client.Steve.ProxyDownstream(clusterID)
 	if err != nil {
 		return []error{err}
@@ -24,7 +24,6 @@ func StatusPodsWithTimeout(client *rancher.Client, clusterID string, timeout tim
 	var podErrors []error
 
 	steveClient := downstreamClient.SteveType(PodResourceSteveType)
-	ctx := context.Background()
 
 	err = wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true, func(_ context.Context) (done bool, err error) {
 		// emptying pod errors every time we poll so that we don't return stale errors
@@ -1,11 +1,14 @@
 package actions
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/dartboard/internal/shared"
 	"github.com/rancher/dartboard/internal/tofu"
 	yaml "gopkg.in/yaml.v2"
 
@@ -17,7 +20,6 @@ import (
 	shepherddefaults "github.com/rancher/shepherd/extensions/defaults"
 	shepherdtokens "github.com/rancher/shepherd/extensions/token"
 	"github.com/rancher/shepherd/pkg/session"
-	shepherdwait "github.com/rancher/shepherd/pkg/wait"
 
 	"github.com/rancher/tests/actions/machinepools"
 	"github.com/rancher/tests/actions/pipeline"
@@ -44,24 +46,43 @@ func NewRancherConfig(host, adminToken, adminPassword string, insecure bool) ran
 	}
 }
 
-func SetupRancherClient(rancherConfig *rancher.Config, bootstrapPassword string, session *session.Session) (*rancher.Client, error) {
+// NewRancherClient logs in as admin and returns a client, without any further setup
+func NewRancherClient(rancherConfig *rancher.Config, password string, session *session.Session) (*rancher.Client, error) {
 	adminUser := &management.User{
 		Username: "admin",
-		Password: bootstrapPassword,
+		Password: password,
 	}
 
 	logrus.Debugf("Rancher Config: Host: %s AdminToken: %s Insecure: %t", rancherConfig.Host, rancherConfig.AdminToken, *rancherConfig.Insecure)
 
 	adminToken, err := shepherdtokens.GenerateUserToken(adminUser, rancherConfig.Host)
 	if err != nil {
-		return nil, fmt.Errorf("error while creating Admin Token with config %v:\n%v", &rancherConfig, err)
+		return nil, fmt.Errorf("error while creating Admin Token with config %v:\n%w", &rancherConfig, err)
 	}
 
 	rancherConfig.AdminToken = adminToken.Token
 
 	client, err := rancher.NewClientForConfig(rancherConfig.AdminToken, rancherConfig, session)
 	if err != nil {
-		return nil, fmt.Errorf("error while setting up Rancher client with config %v:\n%v", rancherConfig, err)
+		return nil, fmt.Errorf("error while setting up Rancher client with config %v:\n%w", rancherConfig, err)
+	}
+
+	return client, nil
+}
+
+// SetupRancherClient logs in as admin, retrying while Rancher starts up, and completes Rancher's first-run setup
+func SetupRancherClient(ctx context.Context, rancherConfig *rancher.Config, bootstrapPassword string, session *session.Session) (*rancher.Client, error) {
+	var client *rancher.Client
+
+	err := shared.Retry().Do(ctx, "Rancher admin login", func() error {
+		var err error
+
+		client, err = NewRancherClient(rancherConfig, bootstrapPassword, session)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	err = pipeline.PostRancherInstall(client, rancherConfig.AdminPassword)
@@ -77,13 +98,13 @@ func SetupRancherClient(rancherConfig *rancher.Config, bootstrapPassword string,
 	return client, err
 }
 
-func ProvisionDownstreamClusters(r *dart.Dart, templates []dart.ClusterTemplate, rancherClient *rancher.Client) error {
+func ProvisionDownstreamClusters(ctx context.Context, r *dart.Dart, templates []dart.ClusterTemplate, rancherClient *rancher.Client) error {
 	if r.ClusterBatchSize <= 0 {
 		panic("ClusterBatchSize must be > 0")
 	}
 
 	for _, template := range r.ClusterTemplates {
-		err := ProvisionClustersInBatches(r, template, rancherClient)
+		err := ProvisionClustersInBatches(ctx, r, template, rancherClient)
 		if err != nil {
 			return err
 		}
@@ -94,7 +115,7 @@ func ProvisionDownstreamClusters(r *dart.Dart, templates []dart.ClusterTemplate,
 
 // Provisions clusters in "batches" where r.ClusterBatchSize is the maximum # of clusters to provision before sleeping for a short period and continuing
 // This will continue to provision clusters until template.ClusterCount # of Clusters have been provisioned
-func ProvisionClustersInBatches(r *dart.Dart, template dart.ClusterTemplate, rancherClient *rancher.Client) error {
+func ProvisionClustersInBatches(ctx context.Context, r *dart.Dart, template dart.ClusterTemplate, rancherClient *rancher.Client) error {
 	clusterStatePath := fmt.Sprintf("%s/%s", r.TofuWorkspaceStatePath, ClustersStateFile)
 
 	statuses, err := LoadClusterState(clusterStatePath)
@@ -102,35 +123,113 @@ func ProvisionClustersInBatches(r *dart.Dart, template dart.ClusterTemplate, ran
 		return err
 	}
 
-	batchNum := 0
+	names := ProvisionedClusterNames(r, template, statuses)
+
 	// Create batches of clusters from the template
-	for i := 0; i < template.ClusterCount; i += r.ClusterBatchSize {
-		// Create a batch of templates with unique names
-		batchTemplates := make([]dart.ClusterTemplate, 0, r.ClusterBatchSize)
-		j := min(i+r.ClusterBatchSize, template.ClusterCount)
+	for batch := range slices.Chunk(names, r.ClusterBatchSize) {
+		batchTemplates := make([]dart.ClusterTemplate, 0, len(batch))
 
-		// Generate the name for each instance of the template and add the template instances to the batchTemplates slice
-		for k := i; k < j; k++ {
+		for _, name := range batch {
 			templateCopy := template
-			templateCopy.SetGeneratedName(fmt.Sprintf("%d-%d", batchNum, k-i))
+			templateCopy.SetGeneratedName(strings.TrimPrefix(name, template.NamePrefix+"-"))
 			batchTemplates = append(batchTemplates, templateCopy)
 		}
 
 		// Create and run a batch runner for this batch of templates
-		batchRunner := NewSequencedBatchRunner[dart.ClusterTemplate](len(batchTemplates))
+		batchRunner := NewSequencedBatchRunner[dart.ClusterTemplate](len(batchTemplates), r.FailurePolicy, r.Failures, r.RetryFailedOnly)
 
-		err := batchRunner.Run(batchTemplates, statuses, clusterStatePath, rancherClient, nil)
+		err := batchRunner.Run(ctx, batchTemplates, statuses, clusterStatePath, rancherClient, nil)
 		if err != nil {
 			return err
 		}
-
-		batchNum++
 	}
 
 	return nil
 }
 
-func provisionClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J], template dart.ClusterTemplate,
+// ProvisionedClusterNames returns the names of the template.ClusterCount clusters to provision from template, oldest
+// first. Clusters recorded in statuses keep their names, so that changing cluster_batch_size does not rename them,
+// and new ones get generated names not in use yet
+func ProvisionedClusterNames(r *dart.Dart, template dart.ClusterTemplate, statuses map[string]*ClusterStatus) []string {
+	names := RecordedProvisionedClusterNames(template, statuses)
+	if len(names) > template.ClusterCount {
+		return names[:template.ClusterCount]
+	}
+
+	for k := 0; len(names) < template.ClusterCount; k++ {
+		template.SetGeneratedName(provisionedNameSuffix(r, k))
+
+		if _, ok := statuses[template.GeneratedName()]; !ok {
+			names = append(names, template.GeneratedName())
+		}
+	}
+
+	return names
+}
+
+// RecordedProvisionedClusterNames returns the names of clusters in statuses provisioned from template, oldest first
+func RecordedProvisionedClusterNames(template dart.ClusterTemplate, statuses map[string]*ClusterStatus) []string {
+	var recorded []*ClusterStatus
+
+	for _, cs := range statuses {
+		if isProvisionedFrom(cs, template) {
+			recorded = append(recorded, cs)
+		}
+	}
+
+	slices.SortFunc(recorded, func(a, b *ClusterStatus) int {
+		if c := firstSeen(a).Compare(firstSeen(b)); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	names := make([]string, 0, len(recorded))
+	for _, cs := range recorded {
+		names = append(names, cs.Name)
+	}
+
+	return names
+}
+
+// isProvisionedFrom returns true if cs is of a cluster provisioned from template. Clusters recorded before templates
+// were are matched by generated name, except imported and registered ones which tofu may name alike
+func isProvisionedFrom(cs *ClusterStatus, template dart.ClusterTemplate) bool {
+	if cs.Template != "" {
+		return cs.Template == template.NamePrefix
+	}
+
+	if cs.Reached(StageImported) || cs.Reached(StageRegistered) {
+		return false
+	}
+
+	suffix, ok := strings.CutPrefix(cs.Name, template.NamePrefix+"-")
+	if !ok {
+		return false
+	}
+
+	var batch, index int
+	n, err := fmt.Sscanf(suffix, "%d-%d", &batch, &index)
+
+	return err == nil && n == 2 && fmt.Sprintf("%d-%d", batch, index) == suffix
+}
+
+// firstSeen returns when the cluster of cs was first recorded, zero for clusters migrated from version 1 state files
+func firstSeen(cs *ClusterStatus) time.Time {
+	if len(cs.History) == 0 {
+		return time.Time{}
+	}
+
+	return cs.History[0].Time
+}
+
+// provisionedNameSuffix returns the generated name suffix of the k-th cluster of a template, "<batch>-<index in batch>"
+func provisionedNameSuffix(r *dart.Dart, k int) string {
+	return fmt.Sprintf("%d-%d", k/r.ClusterBatchSize, k%r.ClusterBatchSize)
+}
+
+func provisionClusterWithRunner[J JobDataTypes](ctx context.Context, br *SequencedBatchRunner[J], template dart.ClusterTemplate,
 	statuses map[string]*ClusterStatus, rancherClient *rancher.Client,
 ) (skipped bool, err error) {
 	clusterName := template.GeneratedName()
@@ -141,37 +240,27 @@ func provisionClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J], tem
 	// cs.ClusterTemplate = template
 	stateMutex.Unlock()
 
-	<-br.seqCh
-
-	br.Updates <- stateUpdate{Name: clusterName, Stage: StageNew, Completed: time.Now()}
-
-	br.seqCh <- struct{}{}
+	if err := br.record(stateUpdate{Name: clusterName, Stage: StageNew, Completed: time.Now(), Template: template.NamePrefix}); err != nil {
+		return false, err
+	}
 
-	if cs.Provisioned {
+	if reached(cs, StageProvisioned) {
 		logrus.Infof("Cluster %s has already been provisioned, skipping...", cs.Name)
 		return true, nil
 	}
 
-	logrus.Info("Continuing with cluster provisioning...")
-
-	nodeProvider := CreateProvider(template.ClusterConfig.Provider)
-	templateClusterConfig := ConvertConfigToClusterConfig(template.ClusterConfig)
-
-	// Create the cluster
-	clusterObject, err := provisioning.CreateProvisioningCluster(rancherClient, nodeProvider, cloudcredentials.CloudCredential{}, templateClusterConfig, machinepools.MachineConfigs{}, nil)
-	reports.TimeoutClusterReport(clusterObject, err)
-
+	release, err := shared.Limiter().Acquire(ctx)
 	if err != nil {
-		return false, fmt.Errorf("error while provisioning cluster with ClusterConfig %v:\n%v", templateClusterConfig, err)
+		return false, err
 	}
+	defer release()
 
-	<-br.seqCh
-
-	br.Updates <- stateUpdate{Name: clusterName, Stage: StageCreated, Completed: time.Now()}
-
-	br.seqCh <- struct{}{}
+	logrus.Info("Continuing with cluster provisioning...")
 
-	logrus.Infof("Cluster named %s was created.", clusterName)
+	clusterObject, err := createOrGetProvisionedCluster(ctx, br, rancherClient, template, cs, clusterName)
+	if err != nil {
+		return false, err
+	}
 
 	// Wait for the cluster to be ready
 	fiveMinuteTimeout := int64(shepherddefaults.FiveMinuteTimeout)
@@ -186,27 +275,75 @@ func provisionClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J], tem
 	}
 
 	checkFunc := shepherdclusters.IsProvisioningClusterReady
-	err = shepherdwait.WatchWait(watchInterface, checkFunc)
+	err = watchWait(ctx, watchInterface, checkFunc)
 	reports.TimeoutClusterReport(clusterObject, err)
 
 	if err != nil {
 		return false, fmt.Errorf("error while waiting for Provisioned Cluster to be Ready %v:\n%v", clusterObject.ID, err)
 	}
 
-	cs.Provisioned = true
+	if err := br.record(stateUpdate{Name: clusterName, Stage: StageProvisioned, Completed: time.Now()}); err != nil {
+		return false, err
+	}
+
+	logrus.Infof("Cluster named %s was provisioned.", clusterName)
+
+	return false, nil
+}
 
-	<-br.seqCh
+// createOrGetProvisionedCluster creates the provisioning Cluster for template, or gets the one created by an
+// earlier attempt, so that retries resume waiting for it instead of provisioning another cluster
+func createOrGetProvisionedCluster[J JobDataTypes](ctx context.Context, br *SequencedBatchRunner[J], rancherClient *rancher.Client,
+	template dart.ClusterTemplate, cs *ClusterStatus, clusterName string,
+) (*v1.SteveAPIObject, error) {
+	if reached(cs, StageCreated) {
+		stateMutex.Lock()
+		rancherName := cs.ProvisioningName()
+		stateMutex.Unlock()
 
-	br.Updates <- stateUpdate{Name: clusterName, Stage: StageProvisioned, Completed: time.Now()}
+		var clusterObject *v1.SteveAPIObject
 
-	br.seqCh <- struct{}{}
+		err := shared.Retry().Do(ctx, "get of Cluster "+fleetNamespace+"/"+rancherName, func() error {
+			var err error
 
-	logrus.Infof("Cluster named %s was provisioned.", clusterName)
+			start := time.Now()
+			clusterObject, err = rancherClient.Steve.SteveType(shepherdclusters.ProvisioningSteveResourceType).ByID(fleetNamespace + "/" + rancherName)
+			observeAPICall(start, err)
 
-	return false, nil
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error while getting Cluster %s created by an earlier attempt:\n%w", rancherName, err)
+		}
+
+		logrus.Infof("Cluster named %s was already created as %s, waiting for it...", clusterName, rancherName)
+
+		return clusterObject, nil
+	}
+
+	nodeProvider := CreateProvider(template.ClusterConfig.Provider)
+	templateClusterConfig := ConvertConfigToClusterConfig(template.ClusterConfig)
+
+	// Create the cluster
+	start := time.Now()
+	clusterObject, err := provisioning.CreateProvisioningCluster(rancherClient, nodeProvider, cloudcredentials.CloudCredential{}, templateClusterConfig, machinepools.MachineConfigs{}, nil)
+	observeAPICall(start, err)
+	reports.TimeoutClusterReport(clusterObject, err)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while provisioning cluster with ClusterConfig %v:\n%v", templateClusterConfig, err)
+	}
+
+	if err := br.record(stateUpdate{Name: clusterName, Stage: StageCreated, Completed: time.Now(), RancherName: clusterObject.Name}); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Cluster named %s was created as %s.", clusterName, clusterObject.Name)
+
+	return clusterObject, nil
 }
 
-func ImportDownstreamClusters(r *dart.Dart, clusters []tofu.Cluster, rancherClient *rancher.Client, rancherConfig *rancher.Config) error {
+func ImportDownstreamClusters(ctx context.Context, r *dart.Dart, clusters []tofu.Cluster, rancherClient *rancher.Client, rancherConfig *rancher.Config) error {
 	if r.ClusterBatchSize <= 0 {
 		panic("ClusterBatchSize must be > 0")
 	}
@@ -216,7 +353,7 @@ func ImportDownstreamClusters(r *dart.Dart, clusters []tofu.Cluster, rancherClie
 		return nil
 	}
 
-	err := ImportClustersInBatches(r, clusters, rancherClient, rancherConfig)
+	err := ImportClustersInBatches(ctx, r, clusters, rancherClient, rancherConfig)
 	if err != nil {
 		return err
 	}
@@ -224,7 +361,7 @@ func ImportDownstreamClusters(r *dart.Dart, clusters []tofu.Cluster, rancherClie
 	return nil
 }
 
-func ImportClustersInBatches(r *dart.Dart, clusters []tofu.Cluster, rancherClient *rancher.Client, rancherConfig *rancher.Config) error {
+func ImportClustersInBatches(ctx context.Context, r *dart.Dart, clusters []tofu.Cluster, rancherClient *rancher.Client, rancherConfig *rancher.Config) error {
 	clusterStatePath := fmt.Sprintf("%s/%s", r.TofuWorkspaceStatePath, ClustersStateFile)
 
 	statuses, err := LoadClusterState(clusterStatePath)
@@ -232,14 +369,25 @@ func ImportClustersInBatches(r *dart.Dart, clusters []tofu.Cluster, rancherClien
 		return err
 	}
 
+	// all workers wait on one watch of provisioning Clusters, instead of each polling Rancher
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	watcher, err := NewClusterWatcher(watchCtx, rancherClient)
+	if err != nil {
+		logrus.Warnf("Could not watch provisioning Clusters, polling instead: %v", err)
+	}
+
 	// Enqueue clusters in batches and collect results
 	for i := 0; i < len(clusters); i += r.ClusterBatchSize {
 		j := min(i+r.ClusterBatchSize, len(clusters))
 		batch := clusters[i:j]
 
-		batchRunner := NewSequencedBatchRunner[tofu.Cluster](len(batch))
+		batchRunner := NewSequencedBatchRunner[tofu.Cluster](len(batch), r.FailurePolicy, r.Failures, r.RetryFailedOnly)
+		batchRunner.importStrategy = r.ImportStrategy
+		batchRunner.watcher = watcher
 
-		err := batchRunner.Run(batch, statuses, clusterStatePath, rancherClient, rancherConfig)
+		err := batchRunner.Run(ctx, batch, statuses, clusterStatePath, rancherClient, rancherConfig)
 		if err != nil {
 			return err
 		}
@@ -248,74 +396,96 @@ func ImportClustersInBatches(r *dart.Dart, clusters []tofu.Cluster, rancherClien
 	return nil
 }
 
-// createAndWaitForCluster creates a cluster and waits for it to be ready
-func createAndWaitForCluster(rancherClient *rancher.Client, rancherConfig *rancher.Config, importCluster *provv1.Cluster) (*provv1.Cluster, error) {
-	if _, err := CreateK3SRKE2Cluster(rancherClient, rancherConfig, importCluster); err != nil {
-		return nil, fmt.Errorf("error while creating Steve Cluster with Name %s:\n%w", importCluster.Name, err)
+// observeAPICall reports the latency and outcome of a Rancher API call started at start to the onboarding limiter
+func observeAPICall(start time.Time, err error) {
+	shared.Limiter().Observe(time.Since(start), shared.Retry().IsRetryable(err))
+}
+
+// getProvisioningCluster gets a provisioning Cluster by name, retrying transient failures
+func getProvisioningCluster(ctx context.Context, rancherClient *rancher.Client, name, namespace string) (*provv1.Cluster, error) {
+	var cluster *provv1.Cluster
+
+	err := shared.Retry().Do(ctx, "get of Cluster "+namespace+"/"+name, func() error {
+		var err error
+
+		start := time.Now()
+		cluster, _, err = shepherdclusters.GetProvisioningClusterByName(rancherClient, name, namespace)
+		observeAPICall(start, err)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while getting Cluster by Name %s in Namespace %s:\n%w", name, namespace, err)
 	}
 
-	err := BackoffWait(30, func() (finished bool, err error) {
-		updatedCluster, _, err := shepherdclusters.GetProvisioningClusterByName(rancherClient, importCluster.Name, importCluster.Namespace)
-		if err != nil {
-			return false, fmt.Errorf("error while getting Cluster by Name %s in Namespace %s:\n%w", importCluster.Name, importCluster.Namespace, err)
-		}
+	return cluster, nil
+}
 
-		if updatedCluster.Status.ClusterName != "" {
-			return true, nil
+// createAndWaitForCluster creates a cluster and waits for it to be ready
+func createAndWaitForCluster(ctx context.Context, rancherClient *rancher.Client, rancherConfig *rancher.Config, importCluster *provv1.Cluster,
+	watcher *ClusterWatcher,
+) (*provv1.Cluster, error) {
+	err := shared.Retry().Do(ctx, "creation of Cluster "+importCluster.Name, func() error {
+		_, err := CreateK3SRKE2Cluster(ctx, rancherClient, rancherConfig, importCluster)
+		if err != nil && strings.Contains(err.Error(), "already exists") {
+			// a previous attempt went through, but its response was lost
+			return nil
 		}
 
-		return false, nil
+		return err
 	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error while creating Steve Cluster with Name %s:\n%w", importCluster.Name, err)
 	}
 
-	updatedCluster, _, err := shepherdclusters.GetProvisioningClusterByName(rancherClient, importCluster.Name, importCluster.Namespace)
+	cluster, _, err := waitForProvisioningCluster(ctx, rancherClient, watcher, importCluster.Name, importCluster.Namespace, 30,
+		clusterCreationTimeout, func(c *provv1.Cluster) bool { return c.Status.ClusterName != "" })
 	if err != nil {
-		return nil, fmt.Errorf("error while getting Cluster by Name %s in Namespace %s:\n%w", importCluster.Name, importCluster.Namespace, err)
+		return nil, err
 	}
 
-	return updatedCluster, nil
+	return cluster, nil
 }
 
-// performClusterImport imports an external cluster into Rancher
-func performClusterImport(rancherClient *rancher.Client, cluster tofu.Cluster, importCluster *provv1.Cluster) (*provv1.Cluster, error) {
+// performClusterImport imports an external cluster into Rancher with strategy, see dart.ImportJob and dart.ImportManifest.
+// It returns the imported cluster and when it was seen to be ready
+func performClusterImport(ctx context.Context, rancherClient *rancher.Client, rancherConfig *rancher.Config, cluster tofu.Cluster,
+	importCluster *provv1.Cluster, strategy string, watcher *ClusterWatcher,
+) (*provv1.Cluster, time.Time, error) {
 	restConfig, err := GetRESTConfigFromPath(cluster.Kubeconfig)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 	// Apply client-side rate limiting
 	restConfig.QPS = 50
 	restConfig.Burst = 100
 
-	updatedCluster, _, err := shepherdclusters.GetProvisioningClusterByName(rancherClient, importCluster.Name, importCluster.Namespace)
+	updatedCluster, err := getProvisioningCluster(ctx, rancherClient, importCluster.Name, importCluster.Namespace)
 	if err != nil {
-		return nil, fmt.Errorf("error while getting Cluster by Name %s in Namespace %s:\n%w", importCluster.Name, importCluster.Namespace, err)
+		return nil, time.Time{}, err
 	}
 
 	logrus.Infof("Importing Cluster, ID:%s Name:%s", updatedCluster.Status.ClusterName, updatedCluster.Name)
 
-	err = shepherdclusters.ImportCluster(rancherClient, updatedCluster, restConfig)
-	if err != nil {
-		return nil, fmt.Errorf("error while creating Job for importing Cluster %s:\n%w", updatedCluster.Name, err)
-	}
-
-	err = BackoffWait(100, func() (finished bool, err error) {
-		updatedCluster, _, err = shepherdclusters.GetProvisioningClusterByName(rancherClient, importCluster.Name, importCluster.Namespace)
+	if strategy == dart.ImportManifest {
+		err = importClusterWithManifest(ctx, rancherClient, rancherConfig, updatedCluster.Status.ClusterName, restConfig)
 		if err != nil {
-			return false, fmt.Errorf("error while getting Cluster by Name %s in Namespace %s:\n%w", importCluster.Name, importCluster.Namespace, err)
+			return nil, time.Time{}, fmt.Errorf("error while applying import manifest to Cluster %s:\n%w", updatedCluster.Name, err)
+		}
+	} else {
+		err = shared.Retry().Do(ctx, "import of Cluster "+updatedCluster.Name, func() error {
+			return shepherdclusters.ImportCluster(rancherClient, updatedCluster, restConfig)
+		})
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("error while creating Job for importing Cluster %s:\n%w", updatedCluster.Name, err)
 		}
-
-		return updatedCluster.Status.Ready, nil
-	})
-	if err != nil {
-		return nil, err
 	}
 
-	return updatedCluster, nil
+	return waitForProvisioningCluster(ctx, rancherClient, watcher, importCluster.Name, importCluster.Namespace, 100,
+		clusterImportTimeout, func(c *provv1.Cluster) bool { return c.Status.Ready })
 }
 
-func importClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J], cluster tofu.Cluster,
+func importClusterWithRunner[J JobDataTypes](ctx context.Context, br *SequencedBatchRunner[J], cluster tofu.Cluster,
 	statuses map[string]*ClusterStatus, rancherClient *rancher.Client, rancherConfig *rancher.Config,
 ) (skipped bool, err error) {
 	stateMutex.Lock()
@@ -323,19 +493,23 @@ func importClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J], cluste
 	cs := FindOrCreateStatusByName(statuses, cluster.Name)
 
 	stateMutex.Unlock()
-	<-br.seqCh
-
-	br.Updates <- stateUpdate{Name: cluster.Name, Stage: StageNew, Completed: time.Now()}
-
-	br.seqCh <- struct{}{}
+	if err := br.record(stateUpdate{Name: cluster.Name, Stage: StageNew, Completed: time.Now()}); err != nil {
+		return false, err
+	}
 
 	logrus.Infof("Found existing ClusterStatus object for Cluster with name %s.", cluster.Name)
 
-	if cs.Imported {
+	if reached(cs, StageImported) {
 		logrus.Infof("Cluster %s has already been imported, skipping...", cs.Name)
 		return true, nil
 	}
 
+	release, err := shared.Limiter().Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
 	logrus.Info("Continuing with cluster creation...")
 
 	importCluster := provv1.Cluster{
@@ -344,40 +518,33 @@ func importClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J], cluste
 			Namespace: fleetNamespace,
 		},
 	}
-	if !cs.Created {
-		updatedCluster, err := createAndWaitForCluster(rancherClient, rancherConfig, &importCluster)
+	if !reached(cs, StageCreated) {
+		updatedCluster, err := createAndWaitForCluster(ctx, rancherClient, rancherConfig, &importCluster, br.watcher)
 		if err != nil {
 			return false, err
 		}
 
 		_ = updatedCluster // used for side effects in createAndWaitForCluster
 
-		// sequence the Created event
-		<-br.seqCh
-
-		br.Updates <- stateUpdate{Name: cluster.Name, Stage: StageCreated, Completed: time.Now()}
-
-		br.seqCh <- struct{}{}
+		if err := br.record(stateUpdate{Name: cluster.Name, Stage: StageCreated, Completed: time.Now(), RancherName: importCluster.Name}); err != nil {
+			return false, err
+		}
 
 		logrus.Infof("Cluster named %s was created.", importCluster.Name)
 	}
 
-	updatedCluster, err := performClusterImport(rancherClient, cluster, &importCluster)
+	updatedCluster, readyTime, err := performClusterImport(ctx, rancherClient, rancherConfig, cluster, &importCluster, br.importStrategy, br.watcher)
 	if err != nil {
 		return false, err
 	}
 
-	cs.Imported = true
-
-	<-br.seqCh
-
-	br.Updates <- stateUpdate{Name: cluster.Name, Stage: StageImported, Completed: time.Now()}
-
-	br.seqCh <- struct{}{}
+	if err := br.record(stateUpdate{Name: cluster.Name, Stage: StageImported, Completed: readyTime}); err != nil {
+		return false, err
+	}
 
 	logrus.Infof("Cluster named %s was imported.", updatedCluster.Name)
 
-	podErrors := StatusPodsWithTimeout(rancherClient, updatedCluster.Status.ClusterName, shepherddefaults.OneMinuteTimeout)
+	podErrors := StatusPodsWithTimeout(ctx, rancherClient, updatedCluster.Status.ClusterName, shepherddefaults.OneMinuteTimeout)
 	if len(podErrors) > 0 {
 		errorStrings := make([]string, len(podErrors))
 		for i, e := range podErrors {
@@ -390,7 +557,7 @@ func importClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J], cluste
 	return false, nil
 }
 
-func RegisterCustomClusters(r *dart.Dart, templates []tofu.CustomCluster,
+func RegisterCustomClusters(ctx context.Context, r *dart.Dart, templates []tofu.CustomCluster,
 	rancherClient *rancher.Client, rancherConfig *rancher.Config,
 ) error {
 	if r.ClusterBatchSize <= 0 {
@@ -407,7 +574,7 @@ func RegisterCustomClusters(r *dart.Dart, templates []tofu.CustomCluster,
 	}
 
 	for _, template := range templates {
-		err := RegisterCustomClustersInBatches(r, template, rancherClient, rancherConfig)
+		err := RegisterCustomClustersInBatches(ctx, r, template, rancherClient, rancherConfig)
 		if err != nil {
 			return err
 		}
@@ -416,7 +583,7 @@ func RegisterCustomClusters(r *dart.Dart, templates []tofu.CustomCluster,
 	return nil
 }
 
-func RegisterCustomClustersInBatches(r *dart.Dart, template tofu.CustomCluster, rancherClient *rancher.Client, rancherConfig *rancher.Config) error {
+func RegisterCustomClustersInBatches(ctx context.Context, r *dart.Dart, template tofu.CustomCluster, rancherClient *rancher.Client, rancherConfig *rancher.Config) error {
 	clusterStatePath := fmt.Sprintf("%s/%s", r.TofuWorkspaceStatePath, ClustersStateFile)
 
 	statuses, err := LoadClusterState(clusterStatePath)
@@ -445,9 +612,9 @@ func RegisterCustomClustersInBatches(r *dart.Dart, template tofu.CustomCluster,
 		endTemplate := min(startTemplate+r.ClusterBatchSize, len(custom_clusters))
 		batchTemplates := custom_clusters[startTemplate:endTemplate]
 
-		batchRunner := NewSequencedBatchRunner[tofu.CustomCluster](len(batchTemplates))
+		batchRunner := NewSequencedBatchRunner[tofu.CustomCluster](len(batchTemplates), r.FailurePolicy, r.Failures, r.RetryFailedOnly)
 
-		err := batchRunner.Run(batchTemplates, statuses, clusterStatePath, rancherClient, rancherConfig)
+		err := batchRunner.Run(ctx, batchTemplates, statuses, clusterStatePath, rancherClient, rancherConfig)
 		if err != nil {
 			return err
 		}
@@ -475,34 +642,32 @@ func createMachinePools(template tofu.CustomCluster) []provv1.RKEMachinePool {
 }
 
 // createOrGetClusterObject creates a new cluster object or retrieves an existing one
-func createOrGetClusterObject[J JobDataTypes](br *SequencedBatchRunner[J], rancherClient *rancher.Client, rancherConfig *rancher.Config, provCluster *provv1.Cluster, cs *ClusterStatus, clusterName string) (*v1.SteveAPIObject, error) {
+func createOrGetClusterObject[J JobDataTypes](ctx context.Context, br *SequencedBatchRunner[J], rancherClient *rancher.Client, rancherConfig *rancher.Config, provCluster *provv1.Cluster, cs *ClusterStatus, clusterName string) (*v1.SteveAPIObject, error) {
 	var (
 		clusterResp *v1.SteveAPIObject
 		err         error
 	)
 
-	if !cs.Created {
+	if !reached(cs, StageCreated) {
 		logrus.Infof("Creating Cluster object for %s", cs.Name)
 
-		clusterResp, err = CreateK3SRKE2Cluster(rancherClient, rancherConfig, provCluster)
+		clusterResp, err = CreateK3SRKE2Cluster(ctx, rancherClient, rancherConfig, provCluster)
 		if err != nil {
 			return nil, err
 		}
 
-		_, err = GetK3SRKE2Cluster(rancherClient, rancherConfig, provCluster)
+		_, err = GetK3SRKE2Cluster(ctx, rancherClient, rancherConfig, provCluster)
 		if err != nil {
 			return nil, err
 		}
 
-		<-br.seqCh
-
-		br.Updates <- stateUpdate{Name: clusterName, Stage: StageCreated, Completed: time.Now()}
-
-		br.seqCh <- struct{}{}
+		if err := br.record(stateUpdate{Name: clusterName, Stage: StageCreated, Completed: time.Now(), RancherName: provCluster.Name}); err != nil {
+			return nil, err
+		}
 
 		logrus.Infof("Cluster named %s was created.", provCluster.Name)
 	} else {
-		clusterResp, err = GetK3SRKE2Cluster(rancherClient, rancherConfig, provCluster)
+		clusterResp, err = GetK3SRKE2Cluster(ctx, rancherClient, rancherConfig, provCluster)
 		if err != nil {
 			return nil, err
 		}
@@ -511,7 +676,7 @@ func createOrGetClusterObject[J JobDataTypes](br *SequencedBatchRunner[J], ranch
 	return clusterResp, nil
 }
 
-func registerCustomClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J],
+func registerCustomClusterWithRunner[J JobDataTypes](ctx context.Context, br *SequencedBatchRunner[J],
 	template tofu.CustomCluster, statuses map[string]*ClusterStatus,
 	rancherClient *rancher.Client, rancherConfig *rancher.Config,
 ) (skipped bool, err error) {
@@ -525,17 +690,21 @@ func registerCustomClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J]
 
 	stateMutex.Unlock()
 
-	<-br.seqCh
-
-	br.Updates <- stateUpdate{Name: clusterName, Stage: StageNew, Completed: time.Now()}
-
-	br.seqCh <- struct{}{}
+	if err := br.record(stateUpdate{Name: clusterName, Stage: StageNew, Completed: time.Now()}); err != nil {
+		return false, err
+	}
 
-	if cs.Registered {
+	if reached(cs, StageRegistered) {
 		logrus.Infof("Cluster %s has already been registered, skipping...", cs.Name)
 		return true, nil
 	}
 
+	release, err := shared.Limiter().Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
 	logrus.Info("Continuing with cluster registration...")
 
 	provCluster := &provv1.Cluster{
@@ -554,7 +723,7 @@ func registerCustomClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J]
 		},
 	}
 
-	clusterResp, err := createOrGetClusterObject(br, rancherClient, rancherConfig, provCluster, cs, clusterName)
+	clusterResp, err := createOrGetClusterObject(ctx, br, rancherClient, rancherConfig, provCluster, cs, clusterName)
 	if err != nil {
 		return false, err
 	}
@@ -564,10 +733,10 @@ func registerCustomClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J]
 
 	var clusterObject *v1.SteveAPIObject
 	// Retry registration if SSH handshake fails (likely due to node not being ready or concurrency limits)
-	err = BackoffWait(20, func() (bool, error) {
+	err = BackoffWait(ctx, 20, func() (bool, error) {
 		var regErr error
 
-		clusterObject, regErr = RegisterCustomCluster(rancherClient, clusterResp, provCluster, template.Nodes)
+		clusterObject, regErr = RegisterCustomCluster(ctx, rancherClient, clusterResp, provCluster, template.Nodes)
 		if regErr != nil {
 			if strings.Contains(regErr.Error(), "ssh: handshake failed") || strings.Contains(regErr.Error(), "ssh: unable to authenticate") {
 				logrus.Warnf("SSH handshake failed for cluster %s, retrying... Error: %v", clusterName, regErr)
@@ -585,16 +754,14 @@ func registerCustomClusterWithRunner[J JobDataTypes](br *SequencedBatchRunner[J]
 		return false, err
 	}
 
-	err = VerifyCluster(rancherClient, rancherConfig, clusterObject)
+	err = VerifyCluster(ctx, rancherClient, rancherConfig, clusterObject)
 	if err != nil {
 		return false, err
 	}
 
-	<-br.seqCh
-
-	br.Updates <- stateUpdate{Name: clusterName, Stage: StageRegistered, Completed: time.Now()}
-
-	br.seqCh <- struct{}{}
+	if err := br.record(stateUpdate{Name: clusterName, Stage: StageRegistered, Completed: time.Now()}); err != nil {
+		return false, err
+	}
 
 	logrus.Infof("Cluster named %s was registered.", clusterName)
 
@@ -0,0 +1,290 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/rancher/dartboard/internal/dart"
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// LDAPBaseDN is the base DN of the OpenLDAP directory deployed by the openldap chart
+const LDAPBaseDN = "dc=dartboard,dc=local"
+
+// KeycloakRealm and KeycloakClientID are the realm and OIDC client imported by the keycloak chart
+const (
+	KeycloakRealm    = "dartboard"
+	KeycloakClientID = "rancher"
+)
+
+// authSecretNamespace is where Rancher expects auth provider secrets
+const authSecretNamespace = "cattle-global-data"
+
+var (
+	settingResource    = schema.GroupVersionResource{Group: "management.cattle.io", Version: "v3", Resource: "settings"}
+	featureResource    = schema.GroupVersionResource{Group: "management.cattle.io", Version: "v3", Resource: "features"}
+	globalRoleResource = schema.GroupVersionResource{Group: "management.cattle.io", Version: "v3", Resource: "globalroles"}
+	authConfigResource = schema.GroupVersionResource{Group: "management.cattle.io", Version: "v3", Resource: "authconfigs"}
+)
+
+// AuthServer is a running auth provider of a RancherConfig
+type AuthServer struct {
+	// Address is the LDAP host name, or the Keycloak base URL, as reachable from the upstream cluster
+	Address string
+	// Secret is the LDAP admin password, or the Keycloak client secret
+	Secret string
+	// RancherURL is where OIDC logins are redirected back to
+	RancherURL string
+}
+
+// ApplyRancherConfig applies settings, features, global roles and the auth provider of cfg to Rancher's local cluster.
+// server is only used if cfg has an auth provider
+func ApplyRancherConfig(ctx context.Context, rancherClient *rancher.Client, cfg dart.RancherConfig, server AuthServer) error {
+	restConfig, err := GetLocalClusterRESTConfig(rancherClient)
+	if err != nil {
+		return err
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("error while creating dynamic client for the local cluster: %w", err)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Settings)) {
+		if err := setSetting(ctx, client, name, cfg.Settings[name]); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Features)) {
+		if err := setFeature(ctx, client, name, cfg.Features[name]); err != nil {
+			return err
+		}
+	}
+
+	for _, role := range cfg.GlobalRoles {
+		if err := applyGlobalRole(ctx, client, role); err != nil {
+			return err
+		}
+	}
+
+	if cfg.AuthProvider == nil {
+		return nil
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("error while creating client for the local cluster: %w", err)
+	}
+
+	return enableAuthProvider(ctx, client, clientset, cfg.AuthProvider.Type, server)
+}
+
+// setSetting sets the value of an existing management Setting
+func setSetting(ctx context.Context, client dynamic.Interface, name, value string) error {
+	err := updateObject(ctx, client.Resource(settingResource), name, func(obj *unstructured.Unstructured) error {
+		return unstructured.SetNestedField(obj.Object, value, "value")
+	})
+	if err != nil {
+		return fmt.Errorf("error while setting %s: %w", name, err)
+	}
+
+	logrus.Infof("Set Rancher setting %s to %q", name, value)
+
+	return nil
+}
+
+// setFeature enables or disables a management Feature. Locked features are refused by Rancher's webhook
+func setFeature(ctx context.Context, client dynamic.Interface, name string, enabled bool) error {
+	err := updateObject(ctx, client.Resource(featureResource), name, func(obj *unstructured.Unstructured) error {
+		return unstructured.SetNestedField(obj.Object, enabled, "spec", "value")
+	})
+	if err != nil {
+		return fmt.Errorf("error while toggling feature %s: %w", name, err)
+	}
+
+	logrus.Infof("Set Rancher feature %s to %t", name, enabled)
+
+	return nil
+}
+
+// applyGlobalRole creates a GlobalRole, or replaces the display name, rules and default of an existing one
+func applyGlobalRole(ctx context.Context, client dynamic.Interface, role dart.GlobalRole) error {
+	rules := make([]any, 0, len(role.Rules))
+	for _, rule := range role.Rules {
+		r := map[string]any{"verbs": toAnySlice(rule.Verbs)}
+		setIfNotEmpty(r, "apiGroups", rule.APIGroups)
+		setIfNotEmpty(r, "resources", rule.Resources)
+		setIfNotEmpty(r, "resourceNames", rule.ResourceNames)
+		setIfNotEmpty(r, "nonResourceURLs", rule.NonResourceURLs)
+		rules = append(rules, r)
+	}
+
+	displayName := role.DisplayName
+	if displayName == "" {
+		displayName = role.Name
+	}
+
+	fields := map[string]any{
+		"displayName":    displayName,
+		"newUserDefault": role.NewUserDefault,
+		"rules":          rules,
+	}
+
+	if err := upsertObject(ctx, client.Resource(globalRoleResource), "GlobalRole", role.Name, fields); err != nil {
+		return fmt.Errorf("error while applying global role %s: %w", role.Name, err)
+	}
+
+	logrus.Infof("Applied Rancher global role %s", role.Name)
+
+	return nil
+}
+
+// enableAuthProvider stores the secret of server and enables the AuthConfig of authType pointing to it
+func enableAuthProvider(ctx context.Context, client dynamic.Interface, clientset kubernetes.Interface, authType string, server AuthServer) error {
+	name, secretField, fields := openLDAPConfig(server)
+	if authType == dart.AuthKeycloak {
+		name, secretField, fields = keycloakOIDCConfig(server)
+	}
+
+	// Rancher reads secrets from <namespace>:<name> references, the key is the field name in lowercase
+	secretName := fmt.Sprintf("%sconfig-%s", name, secretField)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: authSecretNamespace},
+		StringData: map[string]string{secretField: server.Secret},
+	}
+
+	secrets := clientset.CoreV1().Secrets(authSecretNamespace)
+	if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("error while updating secret %s: %w", secretName, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("error while creating secret %s: %w", secretName, err)
+	}
+
+	err := updateObject(ctx, client.Resource(authConfigResource), name, func(obj *unstructured.Unstructured) error {
+		maps.Copy(obj.Object, fields)
+		obj.Object["enabled"] = true
+		obj.Object["accessMode"] = "unrestricted"
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error while enabling auth provider %s: %w", name, err)
+	}
+
+	logrus.Infof("Enabled Rancher auth provider %s at %s", name, server.Address)
+
+	return nil
+}
+
+// openLDAPConfig returns the AuthConfig name, secret field and fields for the directory of the openldap chart
+func openLDAPConfig(server AuthServer) (string, string, map[string]any) {
+	return "openldap", "serviceaccountpassword", map[string]any{
+		"servers":                         []any{server.Address},
+		"port":                            int64(389),
+		"tls":                             false,
+		"starttls":                        false,
+		"connectionTimeout":               int64(5000),
+		"serviceAccountDistinguishedName": "cn=admin," + LDAPBaseDN,
+		"serviceAccountPassword":          authSecretNamespace + ":openldapconfig-serviceaccountpassword",
+		"userSearchBase":                  "ou=users," + LDAPBaseDN,
+		"userObjectClass":                 "inetOrgPerson",
+		"userLoginAttribute":              "uid",
+		"userNameAttribute":               "cn",
+		"userSearchAttribute":             "uid|sn|givenName",
+		"userMemberAttribute":             "memberOf",
+		"groupSearchBase":                 "ou=groups," + LDAPBaseDN,
+		"groupObjectClass":                "groupOfNames",
+		"groupNameAttribute":              "cn",
+		"groupSearchAttribute":            "cn",
+		"groupMemberMappingAttribute":     "member",
+		"groupMemberUserAttribute":        "entryDN",
+		"groupDNAttribute":                "entryDN",
+		"nestedGroupMembershipEnabled":    false,
+	}
+}
+
+// keycloakOIDCConfig returns the AuthConfig name, secret field and fields for the realm of the keycloak chart
+func keycloakOIDCConfig(server AuthServer) (string, string, map[string]any) {
+	issuer := fmt.Sprintf("%s/realms/%s", server.Address, KeycloakRealm)
+
+	return "keycloakoidc", "clientsecret", map[string]any{
+		"clientId":         KeycloakClientID,
+		"clientSecret":     authSecretNamespace + ":keycloakoidcconfig-clientsecret",
+		"issuer":           issuer,
+		"rancherUrl":       server.RancherURL + "/verify-auth",
+		"authEndpoint":     issuer + "/protocol/openid-connect/auth",
+		"tokenEndpoint":    issuer + "/protocol/openid-connect/token",
+		"userInfoEndpoint": issuer + "/protocol/openid-connect/userinfo",
+		"jwksUrl":          issuer + "/protocol/openid-connect/certs",
+		"scope":            "openid profile email",
+		"groupsClaim":      "groups",
+	}
+}
+
+// updateObject applies mutate to the cluster-scoped object name, retrying on conflicts
+func updateObject(ctx context.Context, client dynamic.NamespaceableResourceInterface, name string, mutate func(*unstructured.Unstructured) error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if err := mutate(obj); err != nil {
+			return err
+		}
+
+		_, err = client.Update(ctx, obj, metav1.UpdateOptions{})
+
+		return err
+	})
+}
+
+// upsertObject creates the cluster-scoped management object name with top-level fields, or sets them if it exists
+func upsertObject(ctx context.Context, client dynamic.NamespaceableResourceInterface, kind, name string, fields map[string]any) error {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "management.cattle.io/v3",
+		"kind":       kind,
+		"metadata":   map[string]any{"name": name},
+	}}
+	maps.Copy(obj.Object, fields)
+
+	_, err := client.Create(ctx, obj, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	return updateObject(ctx, client, name, func(existing *unstructured.Unstructured) error {
+		maps.Copy(existing.Object, fields)
+		return nil
+	})
+}
+
+func setIfNotEmpty(m map[string]any, key string, values []string) {
+	if len(values) > 0 {
+		m[key] = toAnySlice(values)
+	}
+}
+
+func toAnySlice(values []string) []any {
+	result := make([]any, 0, len(values))
+	for _, v := range values {
+		result = append(result, v)
+	}
+
+	return result
+}
@@ -0,0 +1,53 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rancher/shepherd/clients/rancher"
+	"github.com/sirupsen/logrus"
+	kwait "k8s.io/apimachinery/pkg/util/wait"
+)
+
+// WaitForAgentsUpgraded waits until Rancher has redeployed cattle-cluster-agent with imageTag on all downstream
+// clusters, and all of them are connected and ready again
+func WaitForAgentsUpgraded(ctx context.Context, client *rancher.Client, imageTag string, timeout time.Duration) error {
+	var pending []string
+
+	err := kwait.PollUntilContextTimeout(ctx, 10*time.Second, timeout, true, func(_ context.Context) (bool, error) {
+		health, err := ListClusterHealth(client)
+		if err != nil {
+			// Rancher might still be restarting
+			logrus.Debugf("could not get cluster health, retrying: %v", err)
+			return false, nil
+		}
+
+		pending = nil
+		total := 0
+
+		for name, h := range health {
+			if name == "local" {
+				continue
+			}
+
+			total++
+
+			if !strings.HasSuffix(h.AgentImage, ":"+imageTag) || !h.Connected || !h.Ready {
+				pending = append(pending, name)
+			}
+		}
+
+		logrus.Infof("%d/%d downstream cluster agents upgraded and connected", total-len(pending), total)
+
+		return len(pending) == 0, nil
+	})
+	if err != nil {
+		slices.Sort(pending)
+		return fmt.Errorf("cluster agents not upgraded or not connected on %v: %w", pending, err)
+	}
+
+	return nil
+}
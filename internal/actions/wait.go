@@ -1,17 +1,51 @@
 package actions
 
 import (
+	"context"
 	"time"
 
+	"github.com/rancher/dartboard/internal/shared"
+	shepherdwait "github.com/rancher/shepherd/pkg/wait"
+	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
-// BackoffWait is a shared helper for wait.ExponentialBackoff.
-func BackoffWait(steps int, cond func() (bool, error)) error {
-	return wait.ExponentialBackoff(wait.Backoff{
+// BackoffWait is a shared helper for wait.ExponentialBackoffWithContext.
+func BackoffWait(ctx context.Context, steps int, cond func() (bool, error)) error {
+	return wait.ExponentialBackoffWithContext(ctx, wait.Backoff{
 		Duration: 1 * time.Second,
 		Factor:   1.1,
 		Jitter:   0.1,
 		Steps:    steps,
-	}, cond)
+	}, func(_ context.Context) (bool, error) {
+		return cond()
+	})
+}
+
+// BackoffWaitRetrying is BackoffWait, except that errors returned by cond that the default retry policy deems
+// transient do not stop the wait. It is meant for conditions polling an API that may be briefly unavailable
+func BackoffWaitRetrying(ctx context.Context, steps int, cond func() (bool, error)) error {
+	return BackoffWait(ctx, steps, func() (bool, error) {
+		done, err := cond()
+		if err != nil && shared.Retry().IsRetryable(err) {
+			logrus.Warnf("transient error while waiting, will poll again: %v", err)
+			return false, nil
+		}
+
+		return done, err
+	})
+}
+
+// watchWait is shepherd's wait.WatchWait, stopping the watch early if ctx is done
+func watchWait(ctx context.Context, watchInterface watch.Interface, check func(watch.Event) (bool, error)) error {
+	stop := context.AfterFunc(ctx, watchInterface.Stop)
+	defer stop()
+
+	err := shepherdwait.WatchWait(watchInterface, check)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
 }
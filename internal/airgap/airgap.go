@@ -0,0 +1,195 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package airgap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Config describes the local registry and chart cache used to deploy clusters without internet access
+type Config struct {
+	// Registry is the registry address clusters pull all images from, eg. k3d-st-airgap:5000. Empty disables air-gapped mode
+	Registry string `yaml:"registry"`
+	// PushRegistry is the address images are pushed to from this machine, eg. localhost:5100. Defaults to Registry
+	PushRegistry string `yaml:"push_registry"`
+	// CacheDir is where charts and image lists are downloaded to by `dartboard mirror`, and installed from
+	CacheDir string `yaml:"cache_dir"`
+}
+
+// DefaultConfig returns the configuration used when the dart does not specify one
+func DefaultConfig() Config {
+	return Config{CacheDir: "airgap-cache"}
+}
+
+// Validate returns an error if the configuration cannot be used
+func (c Config) Validate() error {
+	for _, registry := range []string{c.Registry, c.PushRegistry} {
+		if strings.Contains(registry, "://") || strings.Contains(registry, "/") {
+			return fmt.Errorf("airgap: registries must be host[:port] addresses, got %q", registry)
+		}
+	}
+
+	if c.PushRegistry != "" && c.Registry == "" {
+		return errors.New("airgap: push_registry requires registry")
+	}
+
+	if c.Enabled() && c.CacheDir == "" {
+		return errors.New("airgap: cache_dir must be set")
+	}
+
+	return nil
+}
+
+// Enabled returns true if clusters should pull images and charts from local sources only
+func (c Config) Enabled() bool {
+	return c.Registry != ""
+}
+
+// Image returns the reference clusters should pull image from: unchanged if air-gapped mode is disabled,
+// otherwise its repository path in the local registry. Digests are dropped, as pushing an image changes them
+func (c Config) Image(image string) string {
+	if !c.Enabled() {
+		return image
+	}
+
+	return c.Registry + "/" + Repository(image)
+}
+
+// pushReference returns the reference image is pushed to from this machine
+func (c Config) pushReference(image string) string {
+	registry := c.PushRegistry
+	if registry == "" {
+		registry = c.Registry
+	}
+
+	return registry + "/" + Repository(image)
+}
+
+// Repository returns image without its registry host and digest, eg. grafana/k6:1.7.1 for
+// docker.io/grafana/k6:1.7.1@sha256:... Docker Hub official images lose the library/ prefix
+func Repository(image string) string {
+	name, digest, _ := strings.Cut(image, "@")
+
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		name = rest
+		if first == "docker.io" || first == "index.docker.io" {
+			name = strings.TrimPrefix(name, "library/")
+		}
+	}
+
+	// a reference by digest only still needs a tag in the local registry
+	if digest != "" && !strings.Contains(path.Base(name), ":") {
+		name += ":" + strings.ReplaceAll(digest, ":", "-")
+	}
+
+	return name
+}
+
+// CachedChart returns the path of the cached copy of the chart at chartURL, failing if it was not downloaded yet
+func (c Config) CachedChart(chartURL string) (string, error) {
+	p, err := c.cachePath(chartURL)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		return "", fmt.Errorf("chart %s is not cached in %s, run `dartboard mirror` with internet access first: %w", chartURL, c.CacheDir, err)
+	}
+
+	return p, nil
+}
+
+// Fetch downloads fileURL into the cache directory, unless it was already downloaded, and returns its path
+func (c Config) Fetch(ctx context.Context, fileURL string) (string, error) {
+	p, err := c.cachePath(fileURL)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	logrus.Infof("Downloading %s", fileURL)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request for %s: %w", fileURL, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", fileURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", fileURL, resp.Status)
+	}
+
+	// downloads are written to a temporary file first, so interrupted ones are not mistaken for cached files
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".download-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to download %s: %w", fileURL, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	return p, nil
+}
+
+// cachePath returns where fileURL is cached: URLs from different hosts or directories may share the same file
+// name, eg. Rancher image lists, so the host and directory are part of the path
+func (c Config) cachePath(fileURL string) (string, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", fileURL, err)
+	}
+
+	return filepath.Join(c.CacheDir, u.Host, filepath.FromSlash(path.Clean(u.Path))), nil
+}
@@ -0,0 +1,225 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package airgap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/dartboard/internal/docker"
+	"github.com/rancher/dartboard/internal/helm"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	rancherImagesURL      = "https://github.com/rancher/rancher/releases/download/v%s/rancher-images.txt"
+	primeRancherImagesURL = "https://prime.ribs.rancher.io/rancher/v%s/rancher-images.txt"
+	primeRegistry         = "registry.rancher.com"
+)
+
+// ChartImages renders a chart with vals and returns the images referenced by its manifests
+func ChartImages(ctx context.Context, chartLocation, releaseName, namespace string, vals map[string]any) ([]string, error) {
+	manifests, err := helm.Template(ctx, chartLocation, releaseName, namespace, vals)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+
+	decoder := yaml.NewDecoder(strings.NewReader(manifests))
+
+	for {
+		var doc yaml.Node
+
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifests of chart %s: %w", chartLocation, err)
+		}
+
+		images = append(images, findImages(&doc)...)
+	}
+
+	return images, nil
+}
+
+// findImages returns the string values of all "image" keys in node and its children
+func findImages(node *yaml.Node) []string {
+	var images []string
+
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "image" && value.Kind == yaml.ScalarNode && value.Value != "" {
+				images = append(images, value.Value)
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		images = append(images, findImages(child)...)
+	}
+
+	return images
+}
+
+// RancherImages returns all images a Rancher version needs, including agents, system charts and the Kubernetes
+// distributions it provisions, from the rancher-images.txt list published with each release
+func (c Config) RancherImages(ctx context.Context, version string, prime bool) ([]string, error) {
+	listURL := fmt.Sprintf(rancherImagesURL, version)
+	if prime {
+		listURL = fmt.Sprintf(primeRancherImagesURL, version)
+	}
+
+	p, err := c.Fetch(ctx, listURL)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Rancher image list: %w", err)
+	}
+
+	var images []string
+
+	for _, image := range strings.Fields(string(content)) {
+		// Prime images are not published on Docker Hub
+		if prime {
+			image = primeRegistry + "/" + image
+		}
+
+		images = append(images, image)
+	}
+
+	return images, nil
+}
+
+// Push copies images to the local registry, skipping those that are already there.
+// Images that cannot be pulled, eg. custom builds, are pushed if known to the local docker daemon
+func (c Config) Push(ctx context.Context, images []string) error {
+	images = unique(images)
+
+	var failed []string
+
+	for i, image := range images {
+		target := c.pushReference(image)
+
+		if c.pushed(ctx, target) {
+			logrus.Debugf("[%d/%d] %s already mirrored", i+1, len(images), image)
+			continue
+		}
+
+		logrus.Infof("[%d/%d] Mirroring %s to %s", i+1, len(images), image, target)
+
+		if err := c.push(ctx, image, target); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			logrus.Errorf("failed to mirror %s: %v", image, err)
+
+			failed = append(failed, image)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d images could not be mirrored: %s", len(failed), len(images), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// push pulls image, tags it as target and pushes it
+func (c Config) push(ctx context.Context, image, target string) error {
+	if err := docker.Pull(ctx, image); err != nil {
+		known, imagesErr := docker.Images(ctx, image)
+		if imagesErr != nil || len(known) == 0 {
+			return err
+		}
+	}
+
+	if err := docker.Tag(ctx, image, target); err != nil {
+		return err
+	}
+
+	return docker.Push(ctx, target)
+}
+
+// pushed returns true if the registry already has a manifest for reference
+func (c Config) pushed(ctx context.Context, reference string) bool {
+	registry, repository, _ := strings.Cut(reference, "/")
+
+	name, tag, ok := strings.Cut(repository, ":")
+	if !ok {
+		tag = "latest"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("http://%s/v2/%s/manifests/%s", registry, name, tag), nil)
+	if err != nil {
+		return false
+	}
+
+	req.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}, ", "))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// unique returns the non-empty images with distinct repositories, sorted. The first of images sharing the same
+// repository wins, eg. the Prime one of a Rancher image also rendered from its chart
+func unique(images []string) []string {
+	seen := map[string]bool{}
+
+	var result []string
+
+	for _, image := range images {
+		if image != "" && !seen[Repository(image)] {
+			seen[Repository(image)] = true
+
+			result = append(result, image)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
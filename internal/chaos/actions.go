@@ -0,0 +1,367 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/rancher/dartboard/internal/docker"
+	"github.com/rancher/dartboard/internal/kubectl"
+	"github.com/rancher/dartboard/internal/tofu"
+)
+
+const (
+	nsCattleSystem = "cattle-system"
+	clusterAgent   = "cattle-cluster-agent"
+	// throttleName names the API Priority and Fairness objects created by throttle_api
+	throttleName = "dartboard-chaos"
+	// restartCommand restarts the distribution service, and with it embedded etcd or kine, on a k3s or RKE2 server
+	restartCommand = "sudo systemctl restart k3s 2>/dev/null || sudo systemctl restart rke2-server"
+)
+
+// throttleManifest limits API calls from Rancher's service account to a single concurrency share, rejecting the rest
+const throttleManifest = `apiVersion: flowcontrol.apiserver.k8s.io/v1
+kind: PriorityLevelConfiguration
+metadata:
+  name: ` + throttleName + `
+spec:
+  type: Limited
+  limited:
+    nominalConcurrencyShares: 1
+    lendablePercent: 0
+    limitResponse:
+      type: Reject
+---
+apiVersion: flowcontrol.apiserver.k8s.io/v1
+kind: FlowSchema
+metadata:
+  name: ` + throttleName + `
+spec:
+  priorityLevelConfiguration:
+    name: ` + throttleName + `
+  matchingPrecedence: 50
+  distinguisherMethod:
+    type: ByUser
+  rules:
+    - subjects:
+        - kind: ServiceAccount
+          serviceAccount:
+            name: rancher
+            namespace: ` + nsCattleSystem + `
+      resourceRules:
+        - verbs: ["*"]
+          apiGroups: ["*"]
+          resources: ["*"]
+          clusterScope: true
+          namespaces: ["*"]
+      nonResourceRules:
+        - verbs: ["*"]
+          nonResourceURLs: ["*"]
+`
+
+// killRancherPods deletes Rancher pods on the upstream cluster, letting their deployment recreate them
+func killRancherPods(ctx context.Context, env Environment, e Event) (string, revertFunc, error) {
+	cluster, err := env.cluster(e.Cluster)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pods, err := names(ctx, cluster.Kubeconfig, "pods", "--namespace", nsCattleSystem, "--selector", "app=rancher")
+	if err != nil {
+		return "", nil, err
+	}
+
+	pods = limit(pods, e.Count)
+	if len(pods) == 0 {
+		return "", nil, fmt.Errorf("no Rancher pods found")
+	}
+
+	args := append([]string{"delete", "pods", "--namespace", nsCattleSystem, "--wait=false", "--ignore-not-found"}, pods...)
+	if err := kubectl.Exec(ctx, cluster.Kubeconfig, log.Writer(), args...); err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("deleted Rancher pods %s", strings.Join(pods, ", ")), nil, nil
+}
+
+// restartDatastore restarts the server nodes of a cluster, which run embedded etcd or kine. On k3d, the external
+// kine container is restarted instead, if the cluster uses one
+func restartDatastore(ctx context.Context, env Environment, e Event) (string, revertFunc, error) {
+	cluster, err := env.cluster(e.Cluster)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// nodes reachable via ssh
+	if len(cluster.NodeAccessCommands) > 0 {
+		var servers []string
+
+		for node := range cluster.NodeAccessCommands {
+			if strings.Contains(node, "-server-") {
+				servers = append(servers, node)
+			}
+		}
+
+		slices.Sort(servers)
+
+		servers = limit(servers, e.Count)
+		if len(servers) == 0 {
+			return "", nil, fmt.Errorf("no server nodes found")
+		}
+
+		for _, server := range servers {
+			if err := runOnNode(ctx, cluster.NodeAccessCommands[server], restartCommand); err != nil {
+				return "", nil, fmt.Errorf("error while restarting node %s: %w", server, err)
+			}
+		}
+
+		return fmt.Sprintf("restarted server nodes %s", strings.Join(servers, ", ")), nil, nil
+	}
+
+	// k3d: nodes are containers named after the Kubernetes node
+	if e.Cluster == "" || e.Cluster == "upstream" {
+		kine, err := docker.Containers(ctx, "^kine$")
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(kine) > 0 {
+			if err := docker.Restart(ctx, kine...); err != nil {
+				return "", nil, err
+			}
+
+			return "restarted kine", nil, nil
+		}
+	}
+
+	servers, err := names(ctx, cluster.Kubeconfig, "nodes", "--selector", "node-role.kubernetes.io/control-plane")
+	if err != nil {
+		return "", nil, err
+	}
+
+	servers = limit(servers, e.Count)
+	if len(servers) == 0 {
+		return "", nil, fmt.Errorf("no server nodes found")
+	}
+
+	if err := docker.Restart(ctx, servers...); err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("restarted server nodes %s", strings.Join(servers, ", ")), nil, nil
+}
+
+// cordonNodes marks nodes unschedulable until reverted
+func cordonNodes(ctx context.Context, env Environment, e Event) (string, revertFunc, error) {
+	cluster, err := env.cluster(e.Cluster)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nodes, err := names(ctx, cluster.Kubeconfig, "nodes")
+	if err != nil {
+		return "", nil, err
+	}
+
+	nodes = limit(nodes, e.Count)
+	if len(nodes) == 0 {
+		return "", nil, fmt.Errorf("no nodes found")
+	}
+
+	if err := kubectl.Exec(ctx, cluster.Kubeconfig, log.Writer(), append([]string{"cordon"}, nodes...)...); err != nil {
+		return "", nil, err
+	}
+
+	revert := func(ctx context.Context) error {
+		return kubectl.Exec(ctx, cluster.Kubeconfig, log.Writer(), append([]string{"uncordon"}, nodes...)...)
+	}
+
+	return fmt.Sprintf("cordoned nodes %s for %s", strings.Join(nodes, ", "), e.Duration), revert, nil
+}
+
+// partitionAgents scales cattle-cluster-agent to 0 on downstream clusters, disconnecting them from Rancher until reverted
+func partitionAgents(ctx context.Context, env Environment, e Event) (string, revertFunc, error) {
+	targets := []string{e.Cluster}
+
+	if e.Cluster == "" {
+		targets = nil
+
+		for name, cluster := range env.Clusters {
+			if strings.HasPrefix(name, "downstream") && cluster.Kubeconfig != "" {
+				targets = append(targets, name)
+			}
+		}
+
+		slices.Sort(targets)
+		targets = limit(targets, e.Count)
+	}
+
+	if len(targets) == 0 {
+		return "", nil, fmt.Errorf("no downstream clusters with a kubeconfig found")
+	}
+
+	var (
+		partitioned []string
+		err         error
+	)
+
+	replicas := map[string]string{}
+
+	for _, name := range targets {
+		if replicas[name], err = partitionAgent(ctx, env, name); err != nil {
+			err = fmt.Errorf("error while partitioning cluster %s: %w", name, err)
+			break
+		}
+
+		partitioned = append(partitioned, name)
+	}
+
+	// partially partitioned clusters are still reverted
+	revert := func(ctx context.Context) error {
+		var errs []error
+
+		for _, name := range partitioned {
+			if err := scaleAgent(ctx, env.Clusters[name], replicas[name]); err != nil {
+				errs = append(errs, fmt.Errorf("cluster %s: %w", name, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+
+	return fmt.Sprintf("scaled %s to 0 on %s for %s", clusterAgent, strings.Join(partitioned, ", "), e.Duration), revert, err
+}
+
+// partitionAgent scales cattle-cluster-agent to 0 on the named cluster, returning its previous replica count
+func partitionAgent(ctx context.Context, env Environment, name string) (string, error) {
+	cluster, err := env.cluster(name)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+
+	err = kubectl.Exec(ctx, cluster.Kubeconfig, &out, "get", "deployment", clusterAgent, "--namespace", nsCattleSystem,
+		"-o", "jsonpath={.spec.replicas}")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out.String()), scaleAgent(ctx, cluster, "0")
+}
+
+// throttleAPI limits the upstream API server's concurrency for Rancher until reverted
+func throttleAPI(ctx context.Context, env Environment, e Event) (string, revertFunc, error) {
+	cluster, err := env.cluster(e.Cluster)
+	if err != nil {
+		return "", nil, err
+	}
+
+	file, err := os.CreateTemp("", "dartboard-chaos-*.yaml")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create throttling manifest: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString(throttleManifest); err != nil {
+		file.Close()
+		return "", nil, fmt.Errorf("failed to write throttling manifest: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return "", nil, fmt.Errorf("failed to write throttling manifest: %w", err)
+	}
+
+	if err := kubectl.Apply(ctx, cluster.Kubeconfig, file.Name()); err != nil {
+		return "", nil, err
+	}
+
+	revert := func(ctx context.Context) error {
+		return kubectl.Exec(ctx, cluster.Kubeconfig, log.Writer(), "delete", "flowschema,prioritylevelconfiguration",
+			throttleName, "--ignore-not-found")
+	}
+
+	return fmt.Sprintf("throttled Rancher API calls to 1 concurrency share for %s", e.Duration), revert, nil
+}
+
+// cluster returns the named cluster, upstream by default
+func (env Environment) cluster(name string) (tofu.Cluster, error) {
+	if name == "" {
+		name = "upstream"
+	}
+
+	cluster, ok := env.Clusters[name]
+	if !ok || cluster.Kubeconfig == "" {
+		return tofu.Cluster{}, fmt.Errorf("cluster %q not found or not reachable", name)
+	}
+
+	return cluster, nil
+}
+
+func scaleAgent(ctx context.Context, cluster tofu.Cluster, replicas string) error {
+	return kubectl.Exec(ctx, cluster.Kubeconfig, log.Writer(), "scale", "deployment", clusterAgent, "--namespace",
+		nsCattleSystem, "--replicas="+replicas)
+}
+
+// names returns the names of the listed resources
+func names(ctx context.Context, kubeconfig string, args ...string) ([]string, error) {
+	var out bytes.Buffer
+
+	args = append([]string{"get"}, args...)
+	args = append(args, "-o", "jsonpath={.items[*].metadata.name}")
+
+	if err := kubectl.Exec(ctx, kubeconfig, &out, args...); err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(out.String()), nil
+}
+
+// limit returns the first count items, or all of them if count is 0
+func limit(items []string, count int) []string {
+	if count > 0 && count < len(items) {
+		return items[:count]
+	}
+
+	return items
+}
+
+// runOnNode runs a shell command on a node through its ssh script
+func runOnNode(ctx context.Context, accessCommand, command string) error {
+	log.Printf("Exec: %s %s\n", accessCommand, command)
+
+	cmd := exec.CommandContext(ctx, accessCommand, command)
+
+	var errStream strings.Builder
+
+	cmd.Stdout = log.Writer()
+	cmd.Stderr = &errStream
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", errStream.String(), err)
+	}
+
+	return nil
+}
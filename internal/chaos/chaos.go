@@ -0,0 +1,221 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/rancher/dartboard/internal/tofu"
+	"github.com/sirupsen/logrus"
+)
+
+// Names of the failures that can be injected, as used in the dart
+const (
+	KillRancherPods  = "kill_rancher_pods"
+	RestartDatastore = "restart_datastore"
+	CordonNodes      = "cordon_nodes"
+	PartitionAgents  = "partition_agents"
+	ThrottleAPI      = "throttle_api"
+)
+
+// revertTimeout bounds how long undoing a failure may take once the schedule is over
+const revertTimeout = 5 * time.Minute
+
+// Event is a failure injected at a given time after the start of a load run
+type Event struct {
+	// At is when the failure is injected, relative to the start of the schedule
+	At     time.Duration `yaml:"at"`
+	Action string        `yaml:"action"`
+	// Cluster is the name of the cluster to act on. Defaults to upstream, or to all downstream clusters for
+	// partition_agents
+	Cluster string `yaml:"cluster"`
+	// Count is the number of pods, nodes or downstream clusters affected, 0 means all of them
+	Count int `yaml:"count"`
+	// Duration is how long the failure lasts before being reverted, for cordon_nodes, partition_agents and throttle_api
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Environment is what chaos actions act on
+type Environment struct {
+	Clusters map[string]tofu.Cluster
+}
+
+// revertFunc undoes a failure
+type revertFunc func(ctx context.Context) error
+
+// action injects a failure, returning a description of what was done and, for lasting failures, how to revert it
+type action func(ctx context.Context, env Environment, e Event) (description string, revert revertFunc, err error)
+
+var actions = map[string]action{
+	KillRancherPods:  killRancherPods,
+	RestartDatastore: restartDatastore,
+	CordonNodes:      cordonNodes,
+	PartitionAgents:  partitionAgents,
+	ThrottleAPI:      throttleAPI,
+}
+
+// reverted lists actions whose failure lasts until reverted
+var reverted = []string{CordonNodes, PartitionAgents, ThrottleAPI}
+
+// Validate returns an error if the event cannot be scheduled
+func (e Event) Validate() error {
+	if _, ok := actions[e.Action]; !ok {
+		names := make([]string, 0, len(actions))
+		for name := range actions {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+
+		return fmt.Errorf("chaos: unknown action %q, must be one of %s", e.Action, strings.Join(names, ", "))
+	}
+
+	if e.At < 0 {
+		return fmt.Errorf("chaos: %s: at must be >= 0, got %s", e.Action, e.At)
+	}
+
+	if e.Count < 0 {
+		return fmt.Errorf("chaos: %s: count must be >= 0, got %d", e.Action, e.Count)
+	}
+
+	if slices.Contains(reverted, e.Action) && e.Duration <= 0 {
+		return fmt.Errorf("chaos: %s: duration must be > 0", e.Action)
+	}
+
+	return nil
+}
+
+// Schedule injects failures in the background
+type Schedule struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// Start injects each event at its time from now and reverts it after its duration
+func Start(ctx context.Context, events []Event, env Environment) *Schedule {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Schedule{cancel: cancel}
+
+	for _, e := range events {
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+
+			if err := run(ctx, env, e); err != nil {
+				logrus.Errorf("Chaos: %v", err)
+
+				s.mu.Lock()
+				s.errs = append(s.errs, err)
+				s.mu.Unlock()
+			}
+		}()
+	}
+
+	return s
+}
+
+// Wait waits until all events have been injected and reverted, returning their errors
+func (s *Schedule) Wait() error {
+	s.wg.Wait()
+	s.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return errors.Join(s.errs...)
+}
+
+// Stop drops events not injected yet, reverts lasting failures right away and waits for them
+func (s *Schedule) Stop() error {
+	s.cancel()
+
+	return s.Wait()
+}
+
+// run injects a single event, reverts it when its duration is over or the schedule stops, and annotates Grafana
+func run(ctx context.Context, env Environment, e Event) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(e.At):
+	}
+
+	target := e.Cluster
+	if target == "" && e.Action != PartitionAgents {
+		target = "upstream"
+	}
+
+	logrus.Infof("Chaos: injecting %s on %s", e.Action, targetName(target))
+
+	start := time.Now()
+
+	description, revert, err := actions[e.Action](ctx, env, e)
+	if err != nil {
+		err = fmt.Errorf("%s on %s: %w", e.Action, targetName(target), err)
+		description = err.Error()
+	} else {
+		logrus.Infof("Chaos: %s", description)
+	}
+
+	// failures must be undone even when the load run is over or interrupted
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), revertTimeout)
+	defer cancel()
+
+	if revert != nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(e.Duration):
+		}
+
+		if revertErr := revert(cleanupCtx); revertErr != nil {
+			revertErr = fmt.Errorf("reverting %s on %s: %w", e.Action, targetName(target), revertErr)
+			err = errors.Join(err, revertErr)
+			description += "; " + revertErr.Error()
+		} else {
+			logrus.Infof("Chaos: %s on %s reverted after %s", e.Action, targetName(target), time.Since(start).Round(time.Second))
+		}
+	}
+
+	tags := []string{"chaos", e.Action}
+	if target != "" {
+		tags = append(tags, "cluster:"+target)
+	}
+
+	grafana.Annotate(ctx, shared.Grafana(), grafana.Annotation{Time: start, End: time.Now(), Tags: tags, Text: "chaos: " + description})
+
+	return err
+}
+
+func targetName(cluster string) string {
+	if cluster == "" {
+		return "downstream clusters"
+	}
+
+	return "cluster " + cluster
+}
@@ -0,0 +1,100 @@
+package dart
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Addon stages, ie. when during deploy an addon is installed
+const (
+	AddonBeforeRancher   = "before_rancher"   // after cert-manager, before the Rancher chart
+	AddonAfterRancher    = "after_rancher"    // after Rancher and monitoring are up
+	AddonAfterDownstream = "after_downstream" // after all downstream clusters were onboarded
+)
+
+// defaultAddonTimeout bounds helm --wait and wait_for conditions of addons that do not set a timeout
+const defaultAddonTimeout = 10 * time.Minute
+
+// Addon is an extra chart installed by deploy, eg. to measure the impact of a component on Rancher
+type Addon struct {
+	// Name is the Helm release name
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	// Chart is a .tgz URL, an oci:// reference, a directory in charts/ or, with Repo, a chart name in that repo
+	Chart   string `yaml:"chart"`
+	Repo    string `yaml:"repo"`
+	Version string `yaml:"version"`
+	// ValuesFile is a path to a values file, applied before Values
+	ValuesFile string         `yaml:"values_file"`
+	Values     map[string]any `yaml:"values"`
+	// Clusters are upstream, tester, downstream cluster names or template=<prefix>. Defaults to upstream
+	Clusters []string `yaml:"clusters"`
+	// Stage is when the addon is installed, addons of the same stage are installed in dart order
+	Stage string `yaml:"stage"`
+	// Wait makes helm wait for all resources of the release to be ready
+	Wait bool `yaml:"wait"`
+	// WaitFor are resources, as kind/name, to wait for after installing. Workloads wait for their rollout,
+	// other kinds for the condition in WaitCondition
+	WaitFor       []string `yaml:"wait_for"`
+	WaitCondition string   `yaml:"wait_condition"`
+	// Timeout bounds Wait and WaitFor
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// setDefaults fills in optional fields
+func (a *Addon) setDefaults() {
+	if a.Namespace == "" {
+		a.Namespace = a.Name
+	}
+
+	if len(a.Clusters) == 0 {
+		a.Clusters = []string{"upstream"}
+	}
+
+	if a.Stage == "" {
+		a.Stage = AddonAfterRancher
+	}
+
+	if a.WaitCondition == "" {
+		a.WaitCondition = "Ready"
+	}
+
+	if a.Timeout == 0 {
+		a.Timeout = defaultAddonTimeout
+	}
+}
+
+// Validate returns an error if the addon cannot be installed
+func (a Addon) Validate() error {
+	if a.Name == "" {
+		return errors.New("addons: name must be set")
+	}
+
+	if a.Chart == "" {
+		return fmt.Errorf("addon %s: chart must be set", a.Name)
+	}
+
+	if a.Repo != "" && strings.Contains(a.Chart, "://") {
+		return fmt.Errorf("addon %s: chart must be a chart name when repo is set, got %q", a.Name, a.Chart)
+	}
+
+	stages := []string{AddonBeforeRancher, AddonAfterRancher, AddonAfterDownstream}
+	if !slices.Contains(stages, a.Stage) {
+		return fmt.Errorf("addon %s: stage must be one of %s, got %q", a.Name, strings.Join(stages, ", "), a.Stage)
+	}
+
+	for _, resource := range a.WaitFor {
+		if kind, name, ok := strings.Cut(resource, "/"); !ok || kind == "" || name == "" {
+			return fmt.Errorf("addon %s: wait_for entries must be kind/name, got %q", a.Name, resource)
+		}
+	}
+
+	if a.Timeout < 0 {
+		return fmt.Errorf("addon %s: timeout must be >= 0, got %v", a.Name, a.Timeout)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package dart
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExternalCluster is a pre-existing cluster, eg. a kind cluster or an EKS cluster, imported as a downstream cluster
+// alongside the ones tofu creates
+type ExternalCluster struct {
+	// Name is the name of the cluster in Rancher
+	Name       string `yaml:"name"`
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Context is the kubeconfig context to use, defaults to its current context
+	Context string `yaml:"context"`
+}
+
+// validateExternalClusters returns an error if external clusters cannot be imported
+func validateExternalClusters(clusters []ExternalCluster) error {
+	names := map[string]bool{"upstream": true, "tester": true}
+
+	for _, c := range clusters {
+		if c.Name == "" || c.Kubeconfig == "" {
+			return errors.New("external_clusters: name and kubeconfig must be set")
+		}
+
+		if names[c.Name] {
+			return fmt.Errorf("external_clusters: name %s is reserved or listed twice", c.Name)
+		}
+
+		names[c.Name] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package dart
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Fixture binding scopes and subjects
+const (
+	ScopeProject = "project"
+	ScopeCluster = "cluster"
+
+	SubjectUser  = "user"  // local users created by the fixtures
+	SubjectGroup = "group" // groups of rancher_config.auth_provider
+)
+
+// defaultFixtureBatchSize bounds concurrent Rancher API calls of fixtures that do not set batch_size
+const defaultFixtureBatchSize = 10
+
+// Fixtures declare users, projects and role bindings created in Rancher by `dartboard fixtures`. Objects are named
+// after Prefix and an index, and subjects are assigned round-robin, so runs are deterministic and can be repeated
+type Fixtures struct {
+	// Prefix starts the names of all users and projects, defaults to "fixture"
+	Prefix string `yaml:"prefix"`
+	// Users are local users named <prefix>-user-0, <prefix>-user-1, ... sharing chart_variables.user_password
+	Users int `yaml:"users"`
+	// ProjectsPerCluster are projects named <prefix>-project-0, <prefix>-project-1, ... in each of Clusters
+	ProjectsPerCluster int `yaml:"projects_per_cluster"`
+	// Clusters are Rancher cluster names, including local, or template=<prefix>. Defaults to all clusters
+	Clusters []string         `yaml:"clusters"`
+	Bindings []FixtureBinding `yaml:"bindings"`
+	// BatchSize is how many objects are created concurrently
+	BatchSize int `yaml:"batch_size"`
+}
+
+// FixtureBinding binds PerTarget subjects to a role template in every project, or every cluster, of the fixtures.
+// Subjects are taken in order, continuing from one target to the next, and wrap around when all were bound
+type FixtureBinding struct {
+	RoleTemplate string `yaml:"role_template"`
+	Scope        string `yaml:"scope"`
+	Subject      string `yaml:"subject"`
+	PerTarget    int    `yaml:"per_target"`
+}
+
+// setDefaults fills in optional fields
+func (f *Fixtures) setDefaults() {
+	if f.Prefix == "" {
+		f.Prefix = "fixture"
+	}
+
+	if f.BatchSize == 0 {
+		f.BatchSize = defaultFixtureBatchSize
+	}
+
+	for i := range f.Bindings {
+		if f.Bindings[i].Scope == "" {
+			f.Bindings[i].Scope = ScopeProject
+		}
+
+		if f.Bindings[i].Subject == "" {
+			f.Bindings[i].Subject = SubjectUser
+		}
+
+		if f.Bindings[i].PerTarget == 0 {
+			f.Bindings[i].PerTarget = 1
+		}
+	}
+}
+
+// Validate returns an error if the fixtures cannot be created. groups is the number of auth provider groups
+func (f Fixtures) Validate(userPassword string, groups int) error {
+	if f.Users < 0 || f.ProjectsPerCluster < 0 {
+		return errors.New("fixtures: users and projects_per_cluster must be >= 0")
+	}
+
+	if f.BatchSize < 0 {
+		return fmt.Errorf("fixtures: batch_size must be > 0, got %d", f.BatchSize)
+	}
+
+	if f.Users > 0 && userPassword == "" {
+		return errors.New("fixtures: users need chart_variables.user_password to be set")
+	}
+
+	for _, b := range f.Bindings {
+		if b.RoleTemplate == "" {
+			return errors.New("fixtures: bindings need a role_template")
+		}
+
+		if b.Scope != ScopeProject && b.Scope != ScopeCluster {
+			return fmt.Errorf("fixtures: binding %s: scope must be %q or %q, got %q", b.RoleTemplate, ScopeProject, ScopeCluster, b.Scope)
+		}
+
+		if b.Scope == ScopeProject && f.ProjectsPerCluster == 0 {
+			return fmt.Errorf("fixtures: binding %s: project bindings need projects_per_cluster > 0", b.RoleTemplate)
+		}
+
+		if b.PerTarget < 0 {
+			return fmt.Errorf("fixtures: binding %s: per_target must be > 0, got %d", b.RoleTemplate, b.PerTarget)
+		}
+
+		switch b.Subject {
+		case SubjectUser:
+			if f.Users == 0 {
+				return fmt.Errorf("fixtures: binding %s: user bindings need users > 0", b.RoleTemplate)
+			}
+		case SubjectGroup:
+			if groups == 0 {
+				return fmt.Errorf("fixtures: binding %s: group bindings need a rancher_config auth_provider with groups", b.RoleTemplate)
+			}
+		default:
+			return fmt.Errorf("fixtures: binding %s: subject must be %q or %q, got %q", b.RoleTemplate, SubjectUser, SubjectGroup, b.Subject)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package dart
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Auth provider types, deployed on the tester cluster and enabled in Rancher
+const (
+	AuthOpenLDAP = "openldap"
+	AuthKeycloak = "keycloak" // OIDC
+)
+
+// RancherConfig is applied to Rancher through the management API once it is up
+type RancherConfig struct {
+	// Settings are management Setting names and values, eg. agent-tls-mode: system-store
+	Settings map[string]string `yaml:"settings"`
+	// Features are management Feature names and whether they are enabled
+	Features     map[string]bool `yaml:"features"`
+	AuthProvider *AuthProvider   `yaml:"auth_provider"`
+	GlobalRoles  []GlobalRole    `yaml:"global_roles"`
+}
+
+// AuthProvider is an identity provider seeded with users and groups, all users share chart_variables.user_password
+type AuthProvider struct {
+	Type string `yaml:"type"`
+	// Users are named user-0, user-1, ... and assigned round-robin to Groups, named group-0, group-1, ...
+	Users  int `yaml:"users"`
+	Groups int `yaml:"groups"`
+}
+
+// GlobalRole is a Rancher GlobalRole to create or update
+type GlobalRole struct {
+	Name        string `yaml:"name"`
+	DisplayName string `yaml:"display_name"`
+	// NewUserDefault binds the role to users on their first login
+	NewUserDefault bool         `yaml:"new_user_default"`
+	Rules          []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule mirrors rbacv1.PolicyRule
+type PolicyRule struct {
+	APIGroups       []string `yaml:"api_groups"`
+	Resources       []string `yaml:"resources"`
+	ResourceNames   []string `yaml:"resource_names"`
+	Verbs           []string `yaml:"verbs"`
+	NonResourceURLs []string `yaml:"non_resource_urls"`
+}
+
+// Validate returns an error if the configuration cannot be applied. userPassword is chart_variables.user_password
+func (c RancherConfig) Validate(userPassword string) error {
+	if p := c.AuthProvider; p != nil {
+		if p.Type != AuthOpenLDAP && p.Type != AuthKeycloak {
+			return fmt.Errorf("rancher_config: auth_provider type must be %q or %q, got %q", AuthOpenLDAP, AuthKeycloak, p.Type)
+		}
+
+		if p.Users <= 0 {
+			return fmt.Errorf("rancher_config: auth_provider users must be > 0, got %d", p.Users)
+		}
+
+		// LDAP groups need at least one member
+		if p.Groups < 0 || p.Groups > p.Users {
+			return fmt.Errorf("rancher_config: auth_provider groups must be between 0 and users, got %d", p.Groups)
+		}
+
+		if userPassword == "" {
+			return errors.New("rancher_config: auth_provider needs chart_variables.user_password to be set")
+		}
+	}
+
+	var names []string
+
+	for _, role := range c.GlobalRoles {
+		if role.Name == "" {
+			return errors.New("rancher_config: global_roles need a name")
+		}
+
+		if slices.Contains(names, role.Name) {
+			return fmt.Errorf("rancher_config: global role %s is listed twice", role.Name)
+		}
+
+		names = append(names, role.Name)
+
+		for _, rule := range role.Rules {
+			if len(rule.Verbs) == 0 {
+				return fmt.Errorf("rancher_config: global role %s has a rule without verbs", role.Name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -7,7 +7,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
+	"github.com/rancher/dartboard/internal/airgap"
+	"github.com/rancher/dartboard/internal/chaos"
+	"github.com/rancher/dartboard/internal/retry"
+	"github.com/rancher/dartboard/internal/throttle"
 	yaml "gopkg.in/yaml.v3"
 )
 
@@ -20,8 +25,41 @@ type Dart struct {
 	ClusterTemplates       []ClusterTemplate `yaml:"cluster_templates"`
 	ChartVariables         ChartVariables    `yaml:"chart_variables"`
 	TestVariables          TestVariables     `yaml:"test_variables"`
+	RetryPolicy            retry.Policy      `yaml:"retry_policy"`
+	FailurePolicy          FailurePolicy     `yaml:"failure_policy"`
+	Onboarding             throttle.Policy   `yaml:"onboarding"`
+	Chaos                  []chaos.Event     `yaml:"chaos"`
+	Airgap                 airgap.Config     `yaml:"airgap"`
+	Addons                 []Addon           `yaml:"addons"`
+	RancherConfig          RancherConfig     `yaml:"rancher_config"`
+	Fixtures               Fixtures          `yaml:"fixtures"`
+	ExternalClusters       []ExternalCluster `yaml:"external_clusters"`
 	TofuParallelism        int               `yaml:"tofu_parallelism"`
 	ClusterBatchSize       int               `yaml:"cluster_batch_size"`
+	ImportStrategy         string            `yaml:"import_strategy"`
+	RetryFailedOnly        bool              `yaml:"-"`
+	// Failures counts the downstream clusters that failed during this run of dartboard, across all batches
+	Failures *atomic.Int64 `yaml:"-"`
+}
+
+// Failure policy modes
+const (
+	FailFast = "fail_fast" // abort the run after the first cluster failure
+	Continue = "continue"  // record failed clusters and carry on with the others
+)
+
+// Import strategies, ie. how tofu-created downstream clusters get Rancher's agent
+const (
+	ImportJob      = "job"      // a Job on the downstream cluster applies the registration manifest
+	ImportManifest = "manifest" // dartboard downloads the registration manifest and applies it with server-side apply
+)
+
+// FailurePolicy decides what happens when importing, provisioning or registering a downstream cluster fails
+type FailurePolicy struct {
+	Mode string `yaml:"mode"`
+	// MaxFailures, in Continue mode, stops once that many clusters failed during this run. Failures recorded by
+	// earlier runs do not count. 0 means no limit
+	MaxFailures int `yaml:"max_failures"`
 }
 
 type ClusterTemplate struct {
@@ -65,6 +103,12 @@ func defaultDart() Dart {
 	return Dart{
 		TofuParallelism: 10,
 		TofuVariables:   map[string]any{},
+		RetryPolicy:     retry.DefaultPolicy(),
+		FailurePolicy:   FailurePolicy{Mode: FailFast},
+		ImportStrategy:  ImportJob,
+		Failures:        new(atomic.Int64),
+		Onboarding:      throttle.DefaultPolicy(),
+		Airgap:          airgap.DefaultConfig(),
 		ChartVariables: ChartVariables{
 			RancherReplicas:             1,
 			DownstreamRancherMonitoring: false,
@@ -110,9 +154,91 @@ func Parse(path string) (*Dart, error) {
 	result.ChartVariables.TesterGrafanaVersion = normalizeVersion(result.ChartVariables.TesterGrafanaVersion)
 	result.ChartVariables.ForcePrimeRegistry = result.ChartVariables.ForcePrimeRegistry || needsPrime(result.ChartVariables.RancherVersion)
 
+	if err := result.RetryPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid dart file: %w", err)
+	}
+
+	if err := result.FailurePolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid dart file: %w", err)
+	}
+
+	if result.ImportStrategy != ImportJob && result.ImportStrategy != ImportManifest {
+		return nil, fmt.Errorf("invalid dart file: import_strategy must be %q or %q, got %q", ImportJob, ImportManifest, result.ImportStrategy)
+	}
+
+	if err := validateExternalClusters(result.ExternalClusters); err != nil {
+		return nil, fmt.Errorf("invalid dart file: %w", err)
+	}
+
+	if err := result.Onboarding.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid dart file: %w", err)
+	}
+
+	if err := result.Airgap.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid dart file: %w", err)
+	}
+
+	if err := result.RancherConfig.Validate(result.ChartVariables.UserPassword); err != nil {
+		return nil, fmt.Errorf("invalid dart file: %w", err)
+	}
+
+	groups := 0
+	if result.RancherConfig.AuthProvider != nil {
+		groups = result.RancherConfig.AuthProvider.Groups
+	}
+
+	result.Fixtures.setDefaults()
+
+	if err := result.Fixtures.Validate(result.ChartVariables.UserPassword, groups); err != nil {
+		return nil, fmt.Errorf("invalid dart file: %w", err)
+	}
+
+	names := map[string]bool{}
+
+	for i := range result.Addons {
+		result.Addons[i].setDefaults()
+
+		if err := result.Addons[i].Validate(); err != nil {
+			return nil, fmt.Errorf("invalid dart file: %w", err)
+		}
+
+		// releases are told apart by namespace and name
+		key := result.Addons[i].Namespace + "/" + result.Addons[i].Name
+		if names[key] {
+			return nil, fmt.Errorf("invalid dart file: addon %s is listed twice", key)
+		}
+
+		names[key] = true
+	}
+
+	for _, event := range result.Chaos {
+		if err := event.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid dart file: %w", err)
+		}
+	}
+
 	return &result, nil
 }
 
+// Validate returns an error if the failure policy is not usable
+func (p FailurePolicy) Validate() error {
+	if p.Mode != FailFast && p.Mode != Continue {
+		return fmt.Errorf("failure policy: mode must be %q or %q, got %q", FailFast, Continue, p.Mode)
+	}
+
+	if p.MaxFailures < 0 {
+		return fmt.Errorf("failure policy: max_failures must be >= 0, got %d", p.MaxFailures)
+	}
+
+	return nil
+}
+
+// SetRancherVersion changes the Rancher version to install, eg. for upgrades
+func (cv *ChartVariables) SetRancherVersion(version string) {
+	cv.RancherVersion = normalizeVersion(version)
+	cv.ForcePrimeRegistry = cv.ForcePrimeRegistry || needsPrime(cv.RancherVersion)
+}
+
 // normalizeVersion tolerates versions with an initial spurious v
 func normalizeVersion(version string) string {
 	return strings.TrimPrefix(version, "v")
@@ -17,6 +17,7 @@ limitations under the License.
 package docker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -31,11 +32,11 @@ type Image struct {
 }
 
 // Images returns known docker images matching the image reference
-func Images(image string) ([]string, error) {
+func Images(ctx context.Context, image string) ([]string, error) {
 	args := []string{"images", "--filter=reference=" + image, "--format=json"}
 	log.Printf("Exec: docker %s\n", strings.Join(args, " "))
 
-	cmd := exec.Command("docker", args...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
 
 	var (
 		outStream strings.Builder
@@ -68,3 +69,92 @@ func Images(image string) ([]string, error) {
 
 	return images, nil
 }
+
+// Containers returns the names of running containers whose name matches the regular expression
+func Containers(ctx context.Context, name string) ([]string, error) {
+	args := []string{"ps", "--filter=name=" + name, "--format={{.Names}}"}
+	log.Printf("Exec: docker %s\n", strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+
+	var (
+		outStream strings.Builder
+		errStream strings.Builder
+	)
+
+	cmd.Stdout = &outStream
+
+	cmd.Stderr = &errStream
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v", errStream.String())
+	}
+
+	return strings.Fields(outStream.String()), nil
+}
+
+// Restart restarts the named containers, waiting until they are running again
+func Restart(ctx context.Context, names ...string) error {
+	args := append([]string{"restart"}, names...)
+	log.Printf("Exec: docker %s\n", strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+
+	var errStream strings.Builder
+
+	cmd.Stderr = &errStream
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error while restarting containers %v: %s: %w", names, errStream.String(), err)
+	}
+
+	return nil
+}
+
+// ServerVersion returns the version of the docker daemon, failing if it is not running
+func ServerVersion(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format={{.ServerVersion}}")
+
+	var (
+		outStream strings.Builder
+		errStream strings.Builder
+	)
+
+	cmd.Stdout = &outStream
+	cmd.Stderr = &errStream
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w", strings.TrimSpace(errStream.String()), err)
+	}
+
+	return strings.TrimSpace(outStream.String()), nil
+}
+
+// Pull pulls an image from its registry
+func Pull(ctx context.Context, image string) error {
+	return run(ctx, "pull", "--quiet", image)
+}
+
+// Tag adds the target reference to the source image
+func Tag(ctx context.Context, source, target string) error {
+	return run(ctx, "tag", source, target)
+}
+
+// Push pushes an image to its registry
+func Push(ctx context.Context, image string) error {
+	return run(ctx, "push", "--quiet", image)
+}
+
+// run runs a docker command, returning an error including its standard error
+func run(ctx context.Context, args ...string) error {
+	log.Printf("Exec: docker %s\n", strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+
+	var errStream strings.Builder
+
+	cmd.Stderr = &errStream
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker %s: %s: %w", args[0], strings.TrimSpace(errStream.String()), err)
+	}
+
+	return nil
+}
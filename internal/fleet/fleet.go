@@ -0,0 +1,306 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// Sources of the bundles distributed by a Benchmark
+const (
+	SourceGitRepo = "gitrepo" // GitRepos cloned by Fleet from the git server on the tester cluster
+	SourceBundle  = "bundle"  // Bundles with inline resources, leaving git out of the measurement
+)
+
+// Workspace is the Fleet namespace Rancher puts downstream clusters in
+const Workspace = "fleet-default"
+
+// pollInterval is how often BundleDeployments are listed while waiting for them to be ready, which bounds the
+// precision of measured durations
+const pollInterval = 5 * time.Second
+
+var (
+	clusterResource          = schema.GroupVersionResource{Group: "fleet.cattle.io", Version: "v1alpha1", Resource: "clusters"}
+	gitRepoResource          = schema.GroupVersionResource{Group: "fleet.cattle.io", Version: "v1alpha1", Resource: "gitrepos"}
+	bundleResource           = schema.GroupVersionResource{Group: "fleet.cattle.io", Version: "v1alpha1", Resource: "bundles"}
+	bundleDeploymentResource = schema.GroupVersionResource{Group: "fleet.cattle.io", Version: "v1alpha1", Resource: "bundledeployments"}
+)
+
+// Benchmark distributes Bundles bundles of ConfigMaps ConfigMaps each to Clusters, and measures how long each cluster
+// takes to have all of them ready
+type Benchmark struct {
+	// Name prefixes the GitRepos or Bundles created, and the namespaces their resources are deployed to
+	Name   string
+	Source string
+	// GitURL is the base URL of the git server, repositories are at <GitURL>/bench-<n>.git. Only used by SourceGitRepo
+	GitURL     string
+	Bundles    int
+	ConfigMaps int
+	// Clusters are names of Fleet clusters in Workspace
+	Clusters []string
+	Timeout  time.Duration
+	// Keep leaves GitRepos or Bundles in place after the benchmark
+	Keep bool
+}
+
+// Validate returns an error if the benchmark cannot run
+func (b Benchmark) Validate() error {
+	if b.Source != SourceGitRepo && b.Source != SourceBundle {
+		return fmt.Errorf("fleet: source must be %q or %q, got %q", SourceGitRepo, SourceBundle, b.Source)
+	}
+
+	if b.Source == SourceGitRepo && b.GitURL == "" {
+		return errors.New("fleet: a git server is needed to distribute GitRepos")
+	}
+
+	if b.Bundles <= 0 || b.ConfigMaps <= 0 {
+		return fmt.Errorf("fleet: bundles and configmaps must be > 0, got %d and %d", b.Bundles, b.ConfigMaps)
+	}
+
+	if len(b.Clusters) == 0 {
+		return errors.New("fleet: no clusters to target")
+	}
+
+	return nil
+}
+
+// ReadyClusters returns the names of Fleet clusters in Workspace whose agents are ready
+func ReadyClusters(ctx context.Context, config *rest.Config) ([]string, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("fleet: failed to create client: %w", err)
+	}
+
+	list, err := client.Resource(clusterResource).Namespace(Workspace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("fleet: failed to list clusters: %w", err)
+	}
+
+	var result []string
+
+	for _, cluster := range list.Items {
+		if conditionStatus(cluster, "Ready") == "True" {
+			result = append(result, cluster.GetName())
+		}
+	}
+
+	return result, nil
+}
+
+// Run creates the benchmark's GitRepos or Bundles and waits for them to be ready on all clusters, or for the timeout
+func Run(ctx context.Context, config *rest.Config, b Benchmark) (*Report, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("fleet: failed to create client: %w", err)
+	}
+
+	resource, objects := b.objects()
+	report := newReport(b, time.Now())
+
+	if !b.Keep {
+		defer cleanup(client.Resource(resource).Namespace(Workspace), objects)
+	}
+
+	for _, obj := range objects {
+		if _, err := client.Resource(resource).Namespace(Workspace).Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("fleet: failed to create %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+
+	logrus.Infof("Created %d %s resources targeting %d clusters, waiting for their BundleDeployments", len(objects), b.Source, len(b.Clusters))
+
+	waitCtx, cancel := context.WithTimeout(ctx, b.Timeout)
+	defer cancel()
+
+	err = waitReady(waitCtx, client, b, report)
+	report.finish()
+
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return report, fmt.Errorf("fleet: %d of %d clusters not ready after %s", report.NotReady, len(b.Clusters), b.Timeout)
+	}
+
+	return report, err
+}
+
+// waitReady records in report when each BundleDeployment of the benchmark becomes ready, until all are
+func waitReady(ctx context.Context, client dynamic.Interface, b Benchmark, report *Report) error {
+	prefix := b.Name + "-"
+	selector := "fleet.cattle.io/bundle-namespace=" + Workspace
+
+	for {
+		list, err := client.Resource(bundleDeploymentResource).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			// transient errors are retried until the timeout
+			logrus.Debugf("fleet: failed to list BundleDeployments: %v", err)
+		} else {
+			// readiness is timed by the local clock when it is observed, as the start of the benchmark is: condition
+			// timestamps come from the clock of the upstream cluster, which may be skewed
+			observed := time.Now()
+
+			for _, bd := range list.Items {
+				labels := bd.GetLabels()
+				if !strings.HasPrefix(labels["fleet.cattle.io/bundle-name"], prefix) || !isReady(bd) {
+					continue
+				}
+
+				report.observe(labels["fleet.cattle.io/cluster"], labels["fleet.cattle.io/bundle-name"], observed)
+			}
+
+			if report.complete() {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// isReady returns true if a BundleDeployment applied its current deployment and all its resources are ready
+func isReady(bd unstructured.Unstructured) bool {
+	deploymentID, _, _ := unstructured.NestedString(bd.Object, "spec", "deploymentID")
+	appliedID, _, _ := unstructured.NestedString(bd.Object, "status", "appliedDeploymentID")
+	ready, _, _ := unstructured.NestedBool(bd.Object, "status", "ready")
+
+	return ready && deploymentID != "" && deploymentID == appliedID
+}
+
+// conditionStatus returns the status of the condition of type t of obj, or "" if it has none
+func conditionStatus(obj unstructured.Unstructured, t string) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]any); ok && condition["type"] == t {
+			status, _ := condition["status"].(string)
+			return status
+		}
+	}
+
+	return ""
+}
+
+// objects returns the GitRepos or Bundles to create, and their resource
+func (b Benchmark) objects() (schema.GroupVersionResource, []*unstructured.Unstructured) {
+	targets := make([]any, 0, len(b.Clusters))
+	for _, name := range b.Clusters {
+		targets = append(targets, map[string]any{"clusterName": name})
+	}
+
+	resource := bundleResource
+	if b.Source == SourceGitRepo {
+		resource = gitRepoResource
+	}
+
+	objects := make([]*unstructured.Unstructured, 0, b.Bundles)
+
+	for i := range b.Bundles {
+		name := fmt.Sprintf("%s-%d", b.Name, i)
+
+		var obj *unstructured.Unstructured
+		if b.Source == SourceGitRepo {
+			obj = b.gitRepo(name, i, targets)
+		} else {
+			obj = b.bundle(name, i, targets)
+		}
+
+		objects = append(objects, obj)
+	}
+
+	return resource, objects
+}
+
+// gitRepo returns a GitRepo for the repository bench-i.git. Bundles of a GitRepo are named after it, and
+// targetNamespace overrides the defaultNamespace of the repository's fleet.yaml
+func (b Benchmark) gitRepo(name string, i int, targets []any) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "fleet.cattle.io/v1alpha1",
+		"kind":       "GitRepo",
+		"metadata":   map[string]any{"name": name, "namespace": Workspace},
+		"spec": map[string]any{
+			"repo":            fmt.Sprintf("%s/bench-%d.git", strings.TrimSuffix(b.GitURL, "/"), i),
+			"branch":          "main",
+			"targetNamespace": name,
+			"targets":         targets,
+		},
+	}}
+}
+
+// bundle returns a Bundle with the same ConfigMaps the git server has in bench-i.git
+func (b Benchmark) bundle(name string, i int, targets []any) *unstructured.Unstructured {
+	resources := make([]any, 0, b.ConfigMaps)
+	for j := range b.ConfigMaps {
+		resources = append(resources, map[string]any{
+			"name":    fmt.Sprintf("configmap-%d.yaml", j),
+			"content": ConfigMap(i, j),
+		})
+	}
+
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "fleet.cattle.io/v1alpha1",
+		"kind":       "Bundle",
+		"metadata":   map[string]any{"name": name, "namespace": Workspace},
+		"spec": map[string]any{
+			"defaultNamespace": name,
+			"resources":        resources,
+			"targets":          targets,
+		},
+	}}
+}
+
+// ConfigMap returns the manifest of the j-th ConfigMap of bundle i, as generated by the git-server chart
+func ConfigMap(i, j int) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: fleet-bench-%d-%d
+data:
+  payload: %q
+`, i, j, strings.Repeat("x", 1024))
+}
+
+// cleanup deletes the objects created by a benchmark. Fleet then removes their resources from all clusters
+func cleanup(client dynamic.ResourceInterface, objects []*unstructured.Unstructured) {
+	// the benchmark context may be over, cleanup gets its own
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, obj := range slices.Backward(objects) {
+		err := client.Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			logrus.Warnf("fleet: could not delete %s %s: %v", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fleet
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+)
+
+// Report is the outcome of a Benchmark
+type Report struct {
+	Name       string    `yaml:"name"`
+	Source     string    `yaml:"source"`
+	Bundles    int       `yaml:"bundles"`
+	ConfigMaps int       `yaml:"config_maps"`
+	Start      time.Time `yaml:"start"`
+	// Latency are percentiles of the time clusters took to have all bundles ready, over ready clusters
+	Latency  Percentiles     `yaml:"latency"`
+	NotReady int             `yaml:"not_ready"`
+	Clusters []ClusterResult `yaml:"clusters"`
+
+	// ready maps cluster names to the time each of their bundles got ready
+	ready map[string]map[string]time.Time
+}
+
+// ClusterResult is how long one cluster took to have all bundles of a Benchmark ready
+type ClusterResult struct {
+	Name  string `yaml:"name"`
+	Ready bool   `yaml:"ready"`
+	// Duration is the time from the start of the benchmark to the last bundle being ready, 0 if not ready
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Percentiles summarize a distribution of durations
+type Percentiles struct {
+	P50 time.Duration `yaml:"p50"`
+	P90 time.Duration `yaml:"p90"`
+	P95 time.Duration `yaml:"p95"`
+	P99 time.Duration `yaml:"p99"`
+	Max time.Duration `yaml:"max"`
+}
+
+func newReport(b Benchmark, start time.Time) *Report {
+	r := &Report{
+		Name:       b.Name,
+		Source:     b.Source,
+		Bundles:    b.Bundles,
+		ConfigMaps: b.ConfigMaps,
+		Start:      start,
+		ready:      map[string]map[string]time.Time{},
+	}
+
+	for _, name := range b.Clusters {
+		r.ready[name] = map[string]time.Time{}
+	}
+
+	return r
+}
+
+// observe records that bundle got ready on cluster at t, unless that was already observed
+func (r *Report) observe(cluster, bundle string, t time.Time) {
+	bundles, ok := r.ready[cluster]
+	if !ok {
+		// not a targeted cluster
+		return
+	}
+
+	if _, ok := bundles[bundle]; !ok {
+		bundles[bundle] = t
+	}
+}
+
+// complete returns true if all bundles are ready on all clusters
+func (r *Report) complete() bool {
+	for _, bundles := range r.ready {
+		if len(bundles) < r.Bundles {
+			return false
+		}
+	}
+
+	return true
+}
+
+// finish computes per-cluster results and percentiles from what was observed
+func (r *Report) finish() {
+	r.Clusters = nil
+	r.NotReady = 0
+
+	var durations []time.Duration
+
+	for name, bundles := range r.ready {
+		result := ClusterResult{Name: name, Ready: len(bundles) >= r.Bundles}
+
+		if result.Ready {
+			for _, t := range bundles {
+				result.Duration = max(result.Duration, t.Sub(r.Start).Round(time.Second))
+			}
+
+			durations = append(durations, result.Duration)
+		} else {
+			r.NotReady++
+		}
+
+		r.Clusters = append(r.Clusters, result)
+	}
+
+	slices.SortFunc(r.Clusters, func(a, b ClusterResult) int {
+		if a.Ready != b.Ready {
+			// not ready clusters first, they are the interesting ones
+			if b.Ready {
+				return -1
+			}
+
+			return 1
+		}
+
+		return cmp.Compare(b.Duration, a.Duration)
+	})
+
+	r.Latency = percentiles(durations)
+}
+
+// percentiles returns nearest-rank percentiles of durations
+func percentiles(durations []time.Duration) Percentiles {
+	if len(durations) == 0 {
+		return Percentiles{}
+	}
+
+	slices.Sort(durations)
+
+	rank := func(p float64) time.Duration {
+		i := int(math.Ceil(p/100*float64(len(durations)))) - 1
+		return durations[max(i, 0)]
+	}
+
+	return Percentiles{
+		P50: rank(50),
+		P90: rank(90),
+		P95: rank(95),
+		P99: rank(99),
+		Max: durations[len(durations)-1],
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Client talks to the Grafana HTTP API of the tester cluster
+type Client struct {
+	url      string
+	user     string
+	password string
+	http     *http.Client
+}
+
+// Annotation marks a point in time, or a time range if End is set, on all Grafana dashboards
+type Annotation struct {
+	Time time.Time
+	End  time.Time
+	Tags []string
+	Text string
+}
+
+// annotationRequest is the body of POST /api/annotations
+type annotationRequest struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd,omitempty"`
+	Tags    []string `json:"tags"`
+	Text    string   `json:"text"`
+}
+
+// Annotate posts a with c, tagged "dartboard". Failures are logged, as annotations are best effort. A nil c does nothing
+func Annotate(ctx context.Context, c *Client, a Annotation) {
+	a.Tags = withDartboardTag(a.Tags)
+
+	// phases that were interrupted are still worth marking
+	if err := c.Annotate(context.WithoutCancel(ctx), a); err != nil {
+		logrus.Warnf("could not annotate Grafana: %v", err)
+	}
+}
+
+// withDartboardTag returns tags with "dartboard" first, so that all annotations of dartboard can be filtered
+func withDartboardTag(tags []string) []string {
+	return append([]string{"dartboard"}, tags...)
+}
+
+// Queue posts annotations with a client in the background, so that concurrent jobs never wait on Grafana.
+// It is best effort: annotations are dropped when its buffer is full, and after the first one that cannot be posted
+type Queue struct {
+	client      *Client
+	annotations chan Annotation
+	done        chan struct{}
+}
+
+// NewQueue starts a Queue posting with c, buffering up to size annotations. Close must be called once no more are
+// added. A nil c drops all annotations
+func NewQueue(c *Client, size int) *Queue {
+	q := &Queue{client: c, annotations: make(chan Annotation, max(size, 1)), done: make(chan struct{})}
+
+	go func() {
+		defer close(q.done)
+
+		failed := false
+
+		for a := range q.annotations {
+			if failed {
+				continue
+			}
+
+			if err := q.client.Annotate(context.Background(), a); err != nil {
+				logrus.Warnf("could not annotate Grafana, dropping further annotations of this batch: %v", err)
+
+				failed = true
+			}
+		}
+	}()
+
+	return q
+}
+
+// Add queues a, tagged "dartboard", without blocking
+func (q *Queue) Add(a Annotation) {
+	if q.client == nil {
+		return
+	}
+
+	a.Tags = withDartboardTag(a.Tags)
+
+	select {
+	case q.annotations <- a:
+	default:
+		logrus.Debugf("Grafana annotation queue full, dropping %q", a.Text)
+	}
+}
+
+// Close waits for queued annotations to be posted
+func (q *Queue) Close() {
+	close(q.annotations)
+	<-q.done
+}
+
+// Phase runs f and annotates the time range it took with c, tagged "phase", marking failures
+func Phase(ctx context.Context, c *Client, text string, tags []string, f func() error) error {
+	start := time.Now()
+	err := f()
+
+	a := Annotation{Time: start, End: time.Now(), Tags: append([]string{"phase"}, tags...), Text: text}
+	if err != nil {
+		a.Tags = append(a.Tags, "failed")
+		a.Text += ": " + err.Error()
+	}
+
+	Annotate(ctx, c, a)
+
+	return err
+}
+
+// NewClient returns a client for the Grafana instance at url, authenticating with basic auth
+func NewClient(url, user, password string) *Client {
+	return &Client{
+		url:      strings.TrimSuffix(url, "/"),
+		user:     user,
+		password: password,
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Health returns an error if Grafana cannot be reached or is not ready
+func (c *Client) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/api/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create Grafana health request: %w", err)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Grafana: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("grafana is not ready: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Annotate creates an organization-wide annotation. A nil Client does nothing, so callers
+// do not need to special-case runs without a tester cluster
+func (c *Client) Annotate(ctx context.Context, a Annotation) error {
+	if c == nil {
+		return nil
+	}
+
+	body := annotationRequest{Time: a.Time.UnixMilli(), Tags: a.Tags, Text: a.Text}
+	if !a.End.IsZero() {
+		body.TimeEnd = a.End.UnixMilli()
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Grafana annotation: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/api/annotations", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create Grafana annotation request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.user, c.password)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to create Grafana annotation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to create Grafana annotation: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// annotationServer records the annotations posted to it, answering with status
+func annotationServer(t *testing.T, status int) (*httptest.Server, func() []annotationRequest) {
+	var (
+		mu       sync.Mutex
+		received []annotationRequest
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body annotationRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid annotation: %v", err)
+		}
+
+		mu.Lock()
+		received = append(received, body)
+		mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []annotationRequest {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return received
+	}
+}
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   int
+	}{
+		{name: "all posted", status: http.StatusOK, want: 3},
+		{name: "dropped after the first failure", status: http.StatusInternalServerError, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, received := annotationServer(t, tt.status)
+
+			q := NewQueue(NewClient(srv.URL, "admin", "admin"), 3)
+			for range 3 {
+				q.Add(Annotation{Time: time.Now(), Tags: []string{"import"}, Text: "import downstream-0-0"})
+			}
+
+			q.Close()
+
+			got := received()
+			if len(got) != tt.want {
+				t.Fatalf("Grafana received %d annotations, want %d", len(got), tt.want)
+			}
+
+			if tags := got[0].Tags; len(tags) != 2 || tags[0] != "dartboard" || tags[1] != "import" {
+				t.Errorf("annotation tags = %v, want [dartboard import]", tags)
+			}
+		})
+	}
+}
+
+func TestQueueWithoutClient(t *testing.T) {
+	q := NewQueue(nil, 1)
+
+	// neither blocks nor fails without a tester cluster, even beyond the buffer size
+	for range 3 {
+		q.Add(Annotation{Time: time.Now(), Text: "import"})
+	}
+
+	q.Close()
+}
@@ -385,17 +385,31 @@ func validateNetworkExists(c *harvclient.Clientset, namespace, name string) erro
 
 // initializeOverCommitSettings retrieves and initializes the overcommit settings
 func initializeOverCommitSettings(c *harvclient.Clientset) error {
-	overCommitSetting, err := c.HarvesterhciV1beta1().Settings().Get(context.TODO(), defaultOverCommitSettingName, k8smetav1.GetOptions{})
+	settings, err := GetOverCommitSettings(context.TODO(), c)
 	if err != nil {
-		return fmt.Errorf("encountered issue when querying Harvester for setting %s: %w", defaultOverCommitSettingName, err)
+		return err
+	}
+
+	overCommitSettingMap = settings
+
+	return nil
+}
+
+// GetOverCommitSettings returns the overcommit percentages of Harvester, keyed by resource (cpu, memory, storage)
+func GetOverCommitSettings(ctx context.Context, c *harvclient.Clientset) (map[string]int, error) {
+	overCommitSetting, err := c.HarvesterhciV1beta1().Settings().Get(ctx, defaultOverCommitSettingName, k8smetav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("encountered issue when querying Harvester for setting %s: %w", defaultOverCommitSettingName, err)
 	}
 
-	err = json.Unmarshal([]byte(overCommitSetting.Default), &overCommitSettingMap)
+	var settings map[string]int
+
+	err = json.Unmarshal([]byte(overCommitSetting.Default), &settings)
 	if err != nil {
-		return fmt.Errorf("encountered issue when unmarshaling setting value %s: %w", defaultOverCommitSettingName, err)
+		return nil, fmt.Errorf("encountered issue when unmarshaling setting value %s: %w", defaultOverCommitSettingName, err)
 	}
 
-	return nil
+	return settings, nil
 }
 
 // createVMFromImage creates a VM from a VM Image using the CLI command context to get information
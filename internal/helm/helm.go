@@ -17,85 +17,338 @@ limitations under the License.
 package helm
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"strings"
+	"slices"
+	"time"
 
-	"github.com/rancher/dartboard/internal/vendored"
+	"github.com/rancher/dartboard/internal/shared"
+	"github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
 )
 
-func Install(kubecfg, chartLocation, releaseName, namespace string, vals map[string]any, extraArgs ...string) error {
-	args := []string{
-		"--kubeconfig=" + kubecfg,
-		"upgrade",
-		"--install",
-		"--namespace=" + namespace,
-		releaseName,
-		chartLocation,
-		"--create-namespace",
+// defaultTimeout bounds hooks and, with WithWait, waiting for resources, as helm's --timeout default does
+const defaultTimeout = 5 * time.Minute
+
+// ErrReleaseNotFound is returned when a release does not exist in its namespace
+var ErrReleaseNotFound = driver.ErrReleaseNotFound
+
+// ReleaseError is returned when installing or upgrading a release fails
+type ReleaseError struct {
+	// Op is "install" or "upgrade"
+	Op        string
+	Release   string
+	Namespace string
+	// RolledBack is true if a failed upgrade was rolled back to the previous revision,
+	// or a failed first install was uninstalled
+	RolledBack bool
+	Err        error
+}
+
+func (e *ReleaseError) Error() string {
+	msg := fmt.Sprintf("helm %s of %s/%s failed", e.Op, e.Namespace, e.Release)
+	if e.RolledBack {
+		msg += " and was rolled back"
 	}
 
-	if vals != nil {
-		valueString := ""
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *ReleaseError) Unwrap() error {
+	return e.Err
+}
+
+// Release is one revision of a release, as listed by History
+type Release struct {
+	Revision     int
+	Status       string
+	Chart        string
+	AppVersion   string
+	Updated      time.Time
+	Description  string
+	ChartVersion string
+}
+
+// options are the settings of Install, see Option
+type options struct {
+	repo        string
+	version     string
+	valuesFiles []string
+	wait        bool
+	timeout     time.Duration
+}
+
+// Option changes how Install finds a chart or installs it
+type Option func(*options)
+
+// WithRepo looks the chart up by name in the chart repository at url, like --repo
+func WithRepo(url string) Option {
+	return func(o *options) { o.repo = url }
+}
+
+// WithVersion installs a specific chart version from a repository or OCI registry, like --version
+func WithVersion(version string) Option {
+	return func(o *options) { o.version = version }
+}
 
-		for k, v := range vals {
-			jsonVal, err := json.Marshal(v)
-			if err != nil {
-				return err
+// WithValuesFiles merges values files, in order, under the values passed to Install, like -f
+func WithValuesFiles(paths ...string) Option {
+	return func(o *options) { o.valuesFiles = append(o.valuesFiles, paths...) }
+}
+
+// WithWait waits up to timeout for all resources of the release to be ready, like --wait --timeout
+func WithWait(timeout time.Duration) Option {
+	return func(o *options) {
+		o.wait = true
+		o.timeout = timeout
+	}
+}
+
+// Install installs a chart as releaseName in namespace, or upgrades the release if it exists.
+// chartLocation is a local path, a .tgz URL, an oci:// reference or, with WithRepo, a chart name.
+// A failed upgrade is rolled back and a failed first install is removed, so that retries start from a clean state
+func Install(ctx context.Context, kubecfg, chartLocation, releaseName, namespace string, vals map[string]any, opts ...Option) error {
+	o := options{timeout: defaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	settings := newSettings(kubecfg, namespace)
+
+	chrt, err := loadChart(chartLocation, o, settings)
+	if err != nil {
+		return err
+	}
+
+	values, err := mergeValues(o.valuesFiles, vals)
+	if err != nil {
+		return fmt.Errorf("values of %s/%s: %w", namespace, releaseName, err)
+	}
+
+	// upgrade --install is idempotent, so transient failures can safely be retried
+	return shared.Retry().Do(ctx, "helm install of "+namespace+"/"+releaseName, func() error {
+		cfg, err := newConfiguration(settings, namespace)
+		if err != nil {
+			return err
+		}
+
+		rel, err := upgradeOrInstall(ctx, cfg, chrt, releaseName, namespace, values, o)
+		if err != nil {
+			return err
+		}
+
+		logrus.Infof("Release %s/%s: revision %d %s (chart %s)", namespace, releaseName, rel.Version, rel.Info.Status, chrt.Metadata.Version)
+
+		return nil
+	})
+}
+
+// upgradeOrInstall upgrades the release if it was deployed before, or installs it, cleaning up after failures
+func upgradeOrInstall(ctx context.Context, cfg *action.Configuration, chrt *chart.Chart, name, namespace string, vals map[string]any, o options) (*release.Release, error) {
+	deployed, err := hasDeployedRevision(cfg, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !deployed {
+		// a previous first install failed or was interrupted, it cannot be upgraded
+		if err := uninstall(cfg, name); err != nil && !errors.Is(err, ErrReleaseNotFound) {
+			return nil, fmt.Errorf("failed to remove failed release %s/%s: %w", namespace, name, err)
+		}
+
+		install := action.NewInstall(cfg)
+		install.ReleaseName = name
+		install.Namespace = namespace
+		install.CreateNamespace = true
+		install.Wait = o.wait
+		install.Timeout = o.timeout
+
+		rel, err := install.RunWithContext(ctx, chrt, vals)
+		if err != nil {
+			uninstallErr := uninstall(cfg, name)
+			if uninstallErr != nil && !errors.Is(uninstallErr, ErrReleaseNotFound) {
+				logrus.Warnf("could not remove failed release %s/%s: %v", namespace, name, uninstallErr)
 			}
 
-			valueString += k + "=" + string(jsonVal) + ","
+			return nil, &ReleaseError{Op: "install", Release: name, Namespace: namespace, RolledBack: uninstallErr == nil, Err: err}
 		}
 
-		args = append(args, "--set-json="+valueString)
+		return rel, nil
 	}
 
-	args = append(args, extraArgs...)
+	upgrade := action.NewUpgrade(cfg)
+	upgrade.Namespace = namespace
+	upgrade.Wait = o.wait
+	upgrade.Timeout = o.timeout
+
+	rel, err := upgrade.RunWithContext(ctx, name, chrt, vals)
+	if err != nil {
+		rollback := action.NewRollback(cfg)
+		rollback.Timeout = o.timeout
+
+		rollbackErr := rollback.Run(name)
+		if rollbackErr != nil {
+			logrus.Warnf("could not roll back release %s/%s: %v", namespace, name, rollbackErr)
+		}
+
+		return nil, &ReleaseError{Op: "upgrade", Release: name, Namespace: namespace, RolledBack: rollbackErr == nil, Err: err}
+	}
 
-	cmd := vendored.Command("helm", args...)
+	return rel, nil
+}
 
-	var errStream strings.Builder
+// hasDeployedRevision returns true if any revision of the release was deployed successfully
+func hasDeployedRevision(cfg *action.Configuration, name string) (bool, error) {
+	revisions, err := action.NewHistory(cfg).Run(name)
+	if errors.Is(err, ErrReleaseNotFound) {
+		return false, nil
+	}
 
-	cmd.Stdout = os.Stdout
+	if err != nil {
+		return false, fmt.Errorf("failed to get history of release %s: %w", name, err)
+	}
 
-	cmd.Stderr = &errStream
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%v", errStream.String())
+	for _, rel := range revisions {
+		if rel.Info.Status == release.StatusDeployed || rel.Info.Status == release.StatusSuperseded {
+			return true, nil
+		}
 	}
 
-	return nil
+	return false, nil
 }
 
 // UninstallIfPresent removes a Helm release from the given namespace.
 // If the release does not exist, it returns nil without error.
-func UninstallIfPresent(kubecfg, releaseName, namespace string) error {
-	args := []string{
-		"--kubeconfig=" + kubecfg,
-		"uninstall",
-		releaseName,
-		"--namespace=" + namespace,
-	}
+func UninstallIfPresent(ctx context.Context, kubecfg, releaseName, namespace string) error {
+	settings := newSettings(kubecfg, namespace)
 
-	cmd := vendored.Command("helm", args...)
+	// uninstalling a release that is already gone succeeds, so transient failures can safely be retried
+	return shared.Retry().Do(ctx, "helm uninstall of "+namespace+"/"+releaseName, func() error {
+		cfg, err := newConfiguration(settings, namespace)
+		if err != nil {
+			return err
+		}
 
-	var errStream strings.Builder
+		if err := uninstall(cfg, releaseName); err != nil && !errors.Is(err, ErrReleaseNotFound) {
+			return fmt.Errorf("failed to uninstall Helm release %q in namespace %q: %w", releaseName, namespace, err)
+		}
 
-	cmd.Stdout = os.Stdout
+		return nil
+	})
+}
 
-	cmd.Stderr = &errStream
-	if err := cmd.Run(); err != nil {
-		errMsg := strings.TrimSpace(strings.ToLower(errStream.String()))
-		if strings.Contains(errMsg, "release: not found") || strings.Contains(errMsg, "release not loaded") {
-			return nil
-		}
+// uninstall removes all revisions of a release
+func uninstall(cfg *action.Configuration, name string) error {
+	u := action.NewUninstall(cfg)
+	u.Timeout = defaultTimeout
 
-		if errMsg == "" {
-			return fmt.Errorf("failed to uninstall Helm release %q in namespace %q: %v", releaseName, namespace, err)
+	_, err := u.Run(name)
+
+	return err
+}
+
+// History returns all revisions of a release, oldest first, or ErrReleaseNotFound
+func History(ctx context.Context, kubecfg, releaseName, namespace string) ([]Release, error) {
+	settings := newSettings(kubecfg, namespace)
+
+	var revisions []*release.Release
+
+	err := shared.Retry().Do(ctx, "helm history of "+namespace+"/"+releaseName, func() error {
+		cfg, err := newConfiguration(settings, namespace)
+		if err != nil {
+			return err
 		}
 
-		return fmt.Errorf("failed to uninstall Helm release %q in namespace %q: %s", releaseName, namespace, errMsg)
+		revisions, err = action.NewHistory(cfg).Run(releaseName)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history of Helm release %q in namespace %q: %w", releaseName, namespace, err)
+	}
+
+	slices.SortFunc(revisions, func(a, b *release.Release) int { return a.Version - b.Version })
+
+	result := make([]Release, 0, len(revisions))
+	for _, rel := range revisions {
+		result = append(result, Release{
+			Revision:     rel.Version,
+			Status:       rel.Info.Status.String(),
+			Chart:        rel.Chart.Metadata.Name,
+			ChartVersion: rel.Chart.Metadata.Version,
+			AppVersion:   rel.Chart.Metadata.AppVersion,
+			Updated:      rel.Info.LastDeployed.Time,
+			Description:  rel.Info.Description,
+		})
+	}
+
+	return result, nil
+}
+
+// Template renders a chart locally, without contacting any cluster, and returns the resulting manifests
+func Template(ctx context.Context, chartLocation, releaseName, namespace string, vals map[string]any) (string, error) {
+	settings := newSettings("", namespace)
+
+	chrt, err := loadChart(chartLocation, options{}, settings)
+	if err != nil {
+		return "", err
+	}
+
+	install := action.NewInstall(&action.Configuration{Log: logrus.Debugf})
+	install.ReleaseName = releaseName
+	install.Namespace = namespace
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	install.IncludeCRDs = true
+
+	rel, err := install.RunWithContext(ctx, chrt, vals)
+	if err != nil {
+		return "", fmt.Errorf("failed to template chart %s: %w", chartLocation, err)
+	}
+
+	return rel.Manifest, nil
+}
+
+// newSettings returns helm environment settings targeting the cluster of kubecfg
+func newSettings(kubecfg, namespace string) *cli.EnvSettings {
+	settings := cli.New()
+	settings.KubeConfig = kubecfg
+	settings.SetNamespace(namespace)
+
+	return settings
+}
+
+// newConfiguration returns an action configuration storing releases as secrets, as the helm CLI does
+func newConfiguration(settings *cli.EnvSettings, namespace string) (*action.Configuration, error) {
+	cfg := &action.Configuration{}
+
+	if err := cfg.Init(settings.RESTClientGetter(), namespace, "secret", logrus.Debugf); err != nil {
+		return nil, fmt.Errorf("failed to set up Helm for namespace %s: %w", namespace, err)
+	}
+
+	return cfg, nil
+}
+
+// loadChart downloads the chart at location, if remote, and loads it
+func loadChart(location string, o options, settings *cli.EnvSettings) (*chart.Chart, error) {
+	pathOptions := action.ChartPathOptions{RepoURL: o.repo, Version: o.version}
+
+	path, err := pathOptions.LocateChart(location, settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate chart %s: %w", location, err)
+	}
+
+	chrt, err := loader.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart %s: %w", location, err)
 	}
 
-	return nil
+	return chrt, nil
 }
@@ -0,0 +1,92 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// mergeValues merges values files in order, then vals on top. Nested maps are merged key by key,
+// other values replace what was there, as with helm -f and --set
+func mergeValues(valuesFiles []string, vals map[string]any) (map[string]any, error) {
+	result := map[string]any{}
+
+	for _, path := range valuesFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values file: %w", err)
+		}
+
+		fileVals := map[string]any{}
+		if err := yaml.Unmarshal(content, &fileVals); err != nil {
+			return nil, fmt.Errorf("failed to parse values file %s: %w", path, err)
+		}
+
+		result = mergeMaps(result, fileVals)
+	}
+
+	normalized, err := normalize(vals)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeMaps(result, normalized), nil
+}
+
+// mergeMaps returns a copy of a with b merged on top of it
+func mergeMaps(a, b map[string]any) map[string]any {
+	result := make(map[string]any, len(a))
+	for k, v := range a {
+		result[k] = v
+	}
+
+	for k, v := range b {
+		if bMap, ok := v.(map[string]any); ok {
+			if aMap, ok := result[k].(map[string]any); ok {
+				result[k] = mergeMaps(aMap, bMap)
+				continue
+			}
+		}
+
+		result[k] = v
+	}
+
+	return result
+}
+
+// normalize converts values built in Go, eg. []map[string]any or ints, to the types helm gets from YAML,
+// so that charts see the same values no matter where they come from
+func normalize(vals map[string]any) (map[string]any, error) {
+	result := map[string]any{}
+	if len(vals) == 0 {
+		return result, nil
+	}
+
+	content, err := yaml.Marshal(vals)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal values: %w", err)
+	}
+
+	if err := yaml.Unmarshal(content, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal values: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeMaps(t *testing.T) {
+	tests := []struct {
+		name string
+		a    map[string]any
+		b    map[string]any
+		want map[string]any
+	}{
+		{name: "both empty", a: nil, b: nil, want: map[string]any{}},
+		{name: "disjoint keys", a: map[string]any{"x": 1}, b: map[string]any{"y": 2}, want: map[string]any{"x": 1, "y": 2}},
+		{name: "b replaces scalars", a: map[string]any{"x": 1}, b: map[string]any{"x": 2}, want: map[string]any{"x": 2}},
+		{
+			name: "nested maps merge key by key",
+			a:    map[string]any{"image": map[string]any{"repository": "rancher", "tag": "v1"}},
+			b:    map[string]any{"image": map[string]any{"tag": "v2"}},
+			want: map[string]any{"image": map[string]any{"repository": "rancher", "tag": "v2"}},
+		},
+		{
+			name: "lists are replaced, not appended",
+			a:    map[string]any{"args": []any{"a", "b"}},
+			b:    map[string]any{"args": []any{"c"}},
+			want: map[string]any{"args": []any{"c"}},
+		},
+		{
+			name: "map replaces scalar",
+			a:    map[string]any{"x": "plain"},
+			b:    map[string]any{"x": map[string]any{"y": 1}},
+			want: map[string]any{"x": map[string]any{"y": 1}},
+		},
+		{
+			name: "scalar replaces map",
+			a:    map[string]any{"x": map[string]any{"y": 1}},
+			b:    map[string]any{"x": nil},
+			want: map[string]any{"x": nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeMaps(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeMaps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeMapsDoesNotModifyArguments(t *testing.T) {
+	a := map[string]any{"image": map[string]any{"tag": "v1"}}
+	b := map[string]any{"image": map[string]any{"tag": "v2"}, "replicas": 3}
+
+	mergeMaps(a, b)
+
+	if want := (map[string]any{"image": map[string]any{"tag": "v1"}}); !reflect.DeepEqual(a, want) {
+		t.Errorf("mergeMaps() modified a to %v", a)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	type resources struct {
+		CPU string `json:"cpu"`
+	}
+
+	tests := []struct {
+		name string
+		vals map[string]any
+		want map[string]any
+	}{
+		{name: "nil", vals: nil, want: map[string]any{}},
+		{name: "ints become float64", vals: map[string]any{"replicas": 3}, want: map[string]any{"replicas": float64(3)}},
+		{
+			name: "typed slices become []any",
+			vals: map[string]any{"tolerations": []map[string]any{{"key": "a"}}, "args": []string{"x"}},
+			want: map[string]any{"tolerations": []any{map[string]any{"key": "a"}}, "args": []any{"x"}},
+		},
+		{
+			name: "structs follow json tags",
+			vals: map[string]any{"resources": resources{CPU: "1"}},
+			want: map[string]any{"resources": map[string]any{"cpu": "1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalize(tt.vals)
+			if err != nil {
+				t.Fatalf("normalize() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalize() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	dir := t.TempDir()
+
+	first := filepath.Join(dir, "first.yaml")
+	if err := os.WriteFile(first, []byte("image:\n  repository: rancher\n  tag: v1\nreplicas: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	second := filepath.Join(dir, "second.yaml")
+	if err := os.WriteFile(second, []byte("image:\n  tag: v2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := mergeValues([]string{first, second}, map[string]any{"replicas": 3})
+	if err != nil {
+		t.Fatalf("mergeValues() error = %v", err)
+	}
+
+	want := map[string]any{
+		"image":    map[string]any{"repository": "rancher", "tag": "v2"},
+		"replicas": float64(3),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeValues() = %v, want %v", got, want)
+	}
+
+	if _, err := mergeValues([]string{filepath.Join(dir, "missing.yaml")}, nil); err == nil {
+		t.Error("mergeValues() with a missing values file succeeded, want an error")
+	}
+}
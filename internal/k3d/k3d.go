@@ -17,6 +17,7 @@ limitations under the License.
 package k3d
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -24,10 +25,10 @@ import (
 	"github.com/rancher/dartboard/internal/vendored"
 )
 
-func ImageImport(k3dClusterName string, image string) error {
+func ImageImport(ctx context.Context, k3dClusterName string, image string) error {
 	args := []string{"image", "import", "--cluster", k3dClusterName, image}
 
-	cmd := vendored.Command("k3d", args...)
+	cmd := vendored.Command(ctx, "k3d", args...)
 
 	var errStream strings.Builder
 
@@ -18,6 +18,7 @@ package kubectl
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,17 +27,20 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"al.essio.dev/pkg/shellescape"
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/shared"
 	"github.com/rancher/dartboard/internal/vendored"
 )
 
 const (
 	// renovate: datasource=github-releases depName=grafana/k6 digestVersion=1.7.1
-	k6Image                    = "grafana/k6:1.7.1@sha256:4fd3a694926b064d3491d9b02b01cde886583c4931f1223816e3d9a7bdfa7e0f"
+	K6Image                    = "grafana/k6:1.7.1@sha256:4fd3a694926b064d3491d9b02b01cde886583c4931f1223816e3d9a7bdfa7e0f"
 	K6Namespace                = "tester"
 	K6KubeSecretName           = "kube"
 	mimirURL                   = "http://mimir.tester:9009/mimir"
@@ -224,9 +228,58 @@ func getCachedEntries(root string, exts map[string]bool) ([]FileEntry, error) {
 	return cachedEntries, cacheErr
 }
 
-func Exec(kubepath string, output io.Writer, args ...string) error {
+// Exec runs kubectl. Commands that can safely be repeated, see isRepeatable, are retried on transient failures
+// according to the default retry policy, and only the output of their last attempt is written to output
+func Exec(ctx context.Context, kubepath string, output io.Writer, args ...string) error {
+	if !isRepeatable(args) {
+		return execOnce(ctx, kubepath, output, args...)
+	}
+
+	var attemptOutput bytes.Buffer
+
+	err := shared.Retry().Do(ctx, "kubectl "+strings.Join(args, " "), func() error {
+		attemptOutput.Reset()
+		return execOnce(ctx, kubepath, &attemptOutput, args...)
+	})
+
+	if output != nil {
+		if _, writeErr := output.Write(attemptOutput.Bytes()); writeErr != nil && err == nil {
+			err = fmt.Errorf("error while writing kubectl output: %w", writeErr)
+		}
+	}
+
+	return err
+}
+
+// isRepeatable returns true if the kubectl command in args only reads or converges state, so that running it again
+// after a transient failure has no side effects. Global flags before the command must use the --flag=value form
+func isRepeatable(args []string) bool {
+	var command []string
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			command = append(command, arg)
+		}
+	}
+
+	if len(command) == 0 {
+		return false
+	}
+
+	switch command[0] {
+	case "get", "apply", "wait":
+		return true
+	case "rollout":
+		return len(command) > 1 && command[1] == "status"
+	default:
+		return false
+	}
+}
+
+// execOnce runs kubectl once, for commands that must not be repeated
+func execOnce(ctx context.Context, kubepath string, output io.Writer, args ...string) error {
 	fullArgs := append([]string{"--kubeconfig=" + kubepath}, args...)
-	cmd := vendored.Command("kubectl", fullArgs...)
+	cmd := vendored.Command(ctx, "kubectl", fullArgs...)
 
 	var errStream strings.Builder
 
@@ -244,27 +297,11 @@ func Exec(kubepath string, output io.Writer, args ...string) error {
 	return nil
 }
 
-func Apply(kubePath, filePath string) error {
-	return Exec(kubePath, log.Writer(), "apply", "-f", filePath)
+func Apply(ctx context.Context, kubePath, filePath string) error {
+	return Exec(ctx, kubePath, log.Writer(), "apply", "-f", filePath)
 }
 
-func WaitRancher(kubePath string) error {
-	err := WaitForReadyCondition(kubePath, "deployment", "rancher", "cattle-system", "available", 20)
-	if err != nil {
-		return err
-	}
-
-	err = WaitForReadyCondition(kubePath, "deployment", "rancher-webhook", "cattle-system", "available", 3)
-	if err != nil {
-		return err
-	}
-
-	err = WaitForReadyCondition(kubePath, "deployment", "fleet-controller", "cattle-fleet-system", "available", 5)
-
-	return err
-}
-
-func WaitForReadyCondition(kubePath, resource, name, namespace string, condition string, minutes int) error {
+func WaitForReadyCondition(ctx context.Context, kubePath, resource, name, namespace string, condition string, minutes int) error {
 	var err error
 
 	args := []string{"wait", resource, name}
@@ -277,14 +314,19 @@ func WaitForReadyCondition(kubePath, resource, name, namespace string, condition
 
 	maxRetries := minutes * 30
 	for i := 1; i < maxRetries; i++ {
-		err = Exec(kubePath, log.Writer(), args...)
+		err = Exec(ctx, kubePath, log.Writer(), args...)
 		if err == nil {
 			return nil
 		}
 		// Check if by chance the resource is not yet available
 		if strings.Contains(err.Error(), fmt.Sprintf("%q not found", name)) {
 			log.Printf("resource %s/%s not available yet, retry %d/%d\n", namespace, name, i, maxRetries)
-			time.Sleep(2 * time.Second)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
 		} else {
 			return err
 		}
@@ -293,10 +335,16 @@ func WaitForReadyCondition(kubePath, resource, name, namespace string, condition
 	return err
 }
 
-func GetRancherFQDNFromLoadBalancer(kubePath string) (string, error) {
+// RolloutStatus waits until the latest rollout of a resource, eg. a deployment, is complete
+func RolloutStatus(ctx context.Context, kubePath, resource, name, namespace string, minutes int) error {
+	return Exec(ctx, kubePath, log.Writer(), "rollout", "status", resource+"/"+name, "--namespace", namespace,
+		fmt.Sprintf("--timeout=%dm", minutes))
+}
+
+func GetRancherFQDNFromLoadBalancer(ctx context.Context, kubePath string) (string, error) {
 	ingress := map[string]string{}
 
-	err := Get(kubePath, "services", "", "", ".items[0].status.loadBalancer.ingress[0]", &ingress)
+	err := Get(ctx, kubePath, "services", "", "", ".items[0].status.loadBalancer.ingress[0]", &ingress)
 	if err != nil {
 		return "", err
 	}
@@ -312,7 +360,7 @@ func GetRancherFQDNFromLoadBalancer(kubePath string) (string, error) {
 	return "", nil
 }
 
-func Get(kubePath string, kind string, name string, namespace string, jsonpath string, out any) error {
+func Get(ctx context.Context, kubePath string, kind string, name string, namespace string, jsonpath string, out any) error {
 	output := new(bytes.Buffer)
 
 	args := []string{
@@ -331,7 +379,7 @@ func Get(kubePath string, kind string, name string, namespace string, jsonpath s
 
 	args = append(args, "-o", fmt.Sprintf("jsonpath={%s}", jsonpath))
 
-	if err := Exec(kubePath, output, args...); err != nil {
+	if err := Exec(ctx, kubePath, output, args...); err != nil {
 		return fmt.Errorf("failed to kubectl get %v: %w", name, err)
 	}
 
@@ -342,10 +390,10 @@ func Get(kubePath string, kind string, name string, namespace string, jsonpath s
 	return nil
 }
 
-func GetStatus(kubepath, kind, name, namespace string) (map[string]any, error) {
+func GetStatus(ctx context.Context, kubepath, kind, name, namespace string) (map[string]any, error) {
 	out := map[string]any{}
 
-	err := Get(kubepath, kind, name, namespace, ".status", &out)
+	err := Get(ctx, kubepath, kind, name, namespace, ".status", &out)
 	if err != nil {
 		return nil, err
 	}
@@ -353,7 +401,8 @@ func GetStatus(kubepath, kind, name, namespace string) (map[string]any, error) {
 	return out, nil
 }
 
-func K6run(kubeconfig, testPath string, envVars, tags map[string]string, printLogs bool, localBaseURL string, record bool) error {
+// K6run runs a k6 test script in a pod of the tester cluster, from image, eg. K6Image or its air-gapped copy
+func K6run(ctx context.Context, kubeconfig, image, testPath string, envVars, tags map[string]string, printLogs bool, localBaseURL string, record bool) error {
 	// gather file entries
 	root := "./charts/k6-files/test-files"
 	exts := map[string]bool{".js": true, ".mjs": true, ".sh": true, ".env": true}
@@ -388,12 +437,12 @@ func K6run(kubeconfig, testPath string, envVars, tags map[string]string, printLo
 
 	// if a kubeconfig is specified, upload it as secret to later mount it
 	if path, ok := envVars["KUBECONFIG"]; ok {
-		err := Exec(kubeconfig, nil, "--namespace="+K6Namespace, "delete", "secret", K6KubeSecretName, "--ignore-not-found")
+		err := Exec(ctx, kubeconfig, nil, "--namespace="+K6Namespace, "delete", "secret", K6KubeSecretName, "--ignore-not-found")
 		if err != nil {
 			return err
 		}
 
-		err = Exec(kubeconfig, nil, "--namespace="+K6Namespace, "create", "secret", "generic", K6KubeSecretName,
+		err = Exec(ctx, kubeconfig, nil, "--namespace="+K6Namespace, "create", "secret", "generic", K6KubeSecretName,
 			"--from-file=config="+path)
 		if err != nil {
 			return err
@@ -425,7 +474,7 @@ func K6run(kubeconfig, testPath string, envVars, tags map[string]string, printLo
 	// Always disable color output for cleaner logs in CI
 	args = append(args, "--no-color")
 
-	overrideJSON, err := buildK6PodOverride(args, entries, envVars)
+	overrideJSON, err := buildK6PodOverride(image, args, entries, envVars)
 	if err != nil {
 		return err
 	}
@@ -435,7 +484,22 @@ func K6run(kubeconfig, testPath string, envVars, tags map[string]string, printLo
 		output = os.Stdout
 	}
 
-	err = Exec(kubeconfig, output, "run", "k6", "--image="+k6Image, "--namespace=tester", "--rm", "--stdin", "--restart=Never", "--overrides="+string(overrideJSON))
+	start := time.Now()
+
+	// k6 runs are not retried, as a partial run would have already generated load
+	err = execOnce(ctx, kubeconfig, output, "run", "k6", "--image="+image, "--namespace=tester", "--rm", "--stdin", "--restart=Never", "--overrides="+string(overrideJSON))
+	annotateK6Run(ctx, relTestPath, tags, start, err)
+
+	if err != nil && ctx.Err() != nil {
+		// kubectl was interrupted before it could --rm the k6 pod, do it now
+		cleanupErr := execOnce(context.WithoutCancel(ctx), kubeconfig, nil, "--namespace="+K6Namespace, "delete", "pod", "k6", "--ignore-not-found", "--wait=false")
+		if cleanupErr != nil {
+			log.Printf("WARNING: failed to clean up k6 pod after interruption: %v", cleanupErr)
+		}
+
+		return ctx.Err()
+	}
+
 	if err != nil {
 		// k6 exit code 99 means thresholds were crossed but all iterations completed.
 		// Treat this as a warning rather than a fatal error.
@@ -451,7 +515,33 @@ func K6run(kubeconfig, testPath string, envVars, tags map[string]string, printLo
 	return nil
 }
 
-func buildK6PodOverride(args []string, entries []FileEntry, envVars map[string]string) ([]byte, error) {
+// annotateK6Run marks the time range of a k6 run on Grafana dashboards, tagged like the run's metrics
+func annotateK6Run(ctx context.Context, testPath string, tags map[string]string, start time.Time, err error) {
+	annotationTags := make([]string, 0, len(tags)+2)
+	for k, v := range tags {
+		annotationTags = append(annotationTags, k+":"+v)
+	}
+
+	slices.Sort(annotationTags)
+
+	text := "k6 " + testPath
+
+	var exitErr *exec.ExitError
+
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == K6ThresholdsHaveFailed:
+		annotationTags = append(annotationTags, "thresholds_crossed")
+		text += ": thresholds crossed"
+	default:
+		annotationTags = append(annotationTags, "failed")
+		text += ": " + err.Error()
+	}
+
+	grafana.Annotate(ctx, shared.Grafana(), grafana.Annotation{Time: start, End: time.Now(), Tags: append([]string{"k6"}, annotationTags...), Text: text})
+}
+
+func buildK6PodOverride(image string, args []string, entries []FileEntry, envVars map[string]string) ([]byte, error) {
 	volumes := []any{
 		map[string]any{"name": "k6-test-files", "configMap": map[string]string{"name": "k6-test-files"}},
 	}
@@ -476,7 +566,7 @@ func buildK6PodOverride(args []string, entries []FileEntry, envVars map[string]s
 			"containers": []any{
 				map[string]any{
 					"name":       "k6",
-					"image":      k6Image,
+					"image":      image,
 					"stdin":      true,
 					"tty":        true,
 					"args":       args,
@@ -0,0 +1,395 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/dartboard/internal/docker"
+	"github.com/rancher/dartboard/internal/harvester"
+	"github.com/rancher/dartboard/internal/tofu"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// portVariables are the tofu variables of the first local port of each range, one port per cluster
+var portVariables = []string{"first_kubernetes_api_port", "first_app_http_port", "first_app_https_port"}
+
+// clusterSize holds the tofu variables that determine how many nodes of which size a cluster, or template, needs
+type clusterSize struct {
+	ClusterCount        int `json:"cluster_count"`
+	ServerCount         int `json:"server_count"`
+	AgentCount          int `json:"agent_count"`
+	NodeModuleVariables struct {
+		CPU int64 `json:"cpu"`
+	} `json:"node_module_variables"`
+}
+
+// checkPorts verifies that local ports of all clusters are free and that port ranges do not overlap
+func checkPorts(_ context.Context, opts Options) (Status, string) {
+	downstreams, err := tofu.DownstreamClusters(opts.Variables)
+	if err != nil {
+		return Fail, err.Error()
+	}
+
+	// upstream and tester, then imported downstream clusters. Custom cluster nodes do not get local ports
+	count := 2
+
+	for _, downstream := range downstreams {
+		if !strings.HasPrefix(downstream.Name, "downstream-custom-") {
+			count++
+		}
+	}
+
+	var (
+		ranges [][2]int
+		busy   []string
+	)
+
+	for _, variable := range portVariables {
+		first, ok := intVariable(opts.Variables, variable)
+		if !ok {
+			first, ok = intVariable(opts.Defaults, variable)
+		}
+
+		if !ok {
+			continue
+		}
+
+		for _, r := range ranges {
+			if first < r[1] && r[0] < first+count {
+				return Fail, fmt.Sprintf("%s range %d-%d overlaps with another port range", variable, first, first+count-1)
+			}
+		}
+
+		ranges = append(ranges, [2]int{first, first + count})
+
+		for port := first; port < first+count; port++ {
+			listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+			if err != nil {
+				busy = append(busy, strconv.Itoa(port))
+				continue
+			}
+
+			listener.Close()
+		}
+	}
+
+	if len(ranges) == 0 {
+		return Pass, "no local ports configured"
+	}
+
+	if len(busy) > 0 {
+		message := fmt.Sprintf("ports %s are in use", strings.Join(busy, ", "))
+		if opts.Applied {
+			return Warn, message + ", which is expected if they belong to this environment"
+		}
+
+		return Fail, message + ", change first_*_port tofu variables or stop what uses them"
+	}
+
+	return Pass, fmt.Sprintf("%d ports per range free", count)
+}
+
+// checkDocker verifies that the docker daemon k3d runs on is reachable
+func checkDocker(ctx context.Context, _ Options) (Status, string) {
+	version, err := docker.ServerVersion(ctx)
+	if err != nil {
+		return Fail, fmt.Sprintf("docker is not running or not reachable: %v", err)
+	}
+
+	return Pass, "docker " + version
+}
+
+// checkSSHKeys verifies that the ssh keys used to reach nodes exist and are usable by ssh
+func checkSSHKeys(_ context.Context, opts Options) (Status, string) {
+	privateKey, ok := opts.Variables["ssh_private_key_path"].(string)
+	if !ok || privateKey == "" {
+		return Fail, "ssh_private_key_path is not set"
+	}
+
+	info, err := os.Stat(expandHome(privateKey))
+	if err != nil {
+		return Fail, fmt.Sprintf("cannot read ssh_private_key_path: %v", err)
+	}
+
+	if info.Mode().Perm()&0o077 != 0 {
+		return Fail, fmt.Sprintf("%s is accessible by other users (%s), ssh will refuse it: chmod 600 it", privateKey, info.Mode().Perm())
+	}
+
+	if publicKey, ok := opts.Variables["ssh_public_key_path"].(string); ok && publicKey != "" {
+		if _, err := os.Stat(expandHome(publicKey)); err != nil {
+			return Fail, fmt.Sprintf("cannot read ssh_public_key_path: %v", err)
+		}
+	}
+
+	return Pass, privateKey
+}
+
+// checkAWSCredentials verifies that AWS credentials, eg. an SSO session of aws_profile, are valid
+func checkAWSCredentials(ctx context.Context, opts Options) (Status, string) {
+	args := []string{"sts", "get-caller-identity", "--output", "text", "--query", "Arn"}
+
+	profile, _ := opts.Variables["aws_profile"].(string)
+	if profile != "" {
+		args = append(args, "--profile", profile)
+	}
+
+	if region, ok := opts.Variables["region"].(string); ok && region != "" {
+		args = append(args, "--region", region)
+	}
+
+	out, err := runCLI(ctx, "aws", args...)
+	if errors.Is(err, exec.ErrNotFound) {
+		return Warn, "aws CLI not found, credentials not checked"
+	}
+
+	if err != nil {
+		message := fmt.Sprintf("invalid or expired credentials: %v", err)
+		if profile != "" {
+			message += fmt.Sprintf(", try `aws sso login --profile %s`", profile)
+		}
+
+		return Fail, message
+	}
+
+	return Pass, out
+}
+
+// checkAzureCredentials verifies that the Azure CLI is logged in
+func checkAzureCredentials(ctx context.Context, _ Options) (Status, string) {
+	out, err := runCLI(ctx, "az", "account", "show", "--output", "tsv", "--query", "name")
+	if errors.Is(err, exec.ErrNotFound) {
+		return Warn, "az CLI not found, credentials not checked"
+	}
+
+	if err != nil {
+		return Fail, fmt.Sprintf("not logged in: %v, try `az login`", err)
+	}
+
+	return Pass, out
+}
+
+// checkHarvesterKubeconfig verifies that the Harvester API is reachable with the configured kubeconfig
+func checkHarvesterKubeconfig(ctx context.Context, opts Options) (Status, string) {
+	path := harvesterKubeconfig(opts)
+
+	client, err := harvester.GetKubeClient(path)
+	if err != nil {
+		return Fail, fmt.Sprintf("cannot load %s: %v", path, err)
+	}
+
+	if err := client.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		return Fail, fmt.Sprintf("Harvester unreachable with %s: %v", path, err)
+	}
+
+	return Pass, path
+}
+
+// checkHarvesterCPU verifies that Harvester has enough unreserved CPU for all VMs, taking CPU overcommit into account
+func checkHarvesterCPU(ctx context.Context, opts Options) (Status, string) {
+	path := harvesterKubeconfig(opts)
+
+	harvClient, err := harvester.GetHarvesterClient(path)
+	if err != nil {
+		return Fail, fmt.Sprintf("cannot load %s: %v", path, err)
+	}
+
+	kubeClient, err := harvester.GetKubeClient(path)
+	if err != nil {
+		return Fail, fmt.Sprintf("cannot load %s: %v", path, err)
+	}
+
+	settings, err := harvester.GetOverCommitSettings(ctx, harvClient)
+	if err != nil {
+		return Fail, err.Error()
+	}
+
+	sizes, err := clusterSizes(opts.Variables)
+	if err != nil {
+		return Fail, err.Error()
+	}
+
+	var required int64
+
+	for _, size := range sizes {
+		request := harvester.HandleCPUOverCommitment(settings, size.NodeModuleVariables.CPU)
+		nodes := int64(size.ClusterCount * (size.ServerCount + size.AgentCount))
+		required += nodes * request.MilliValue()
+	}
+
+	free, err := freeCPU(ctx, kubeClient)
+	if err != nil {
+		return Fail, err.Error()
+	}
+
+	message := fmt.Sprintf("VMs request %dm CPU, %dm free (cpu overcommit %d%%)", required, free, settings["cpu"])
+
+	switch {
+	case required <= free:
+		return Pass, message
+	case opts.Applied:
+		return Warn, message + ", which is expected if VMs of this environment already run"
+	default:
+		return Fail, message
+	}
+}
+
+// freeCPU returns the CPU millicores allocatable on nodes and not requested by running pods
+func freeCPU(ctx context.Context, client *kubernetes.Clientset) (int64, error) {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list Harvester nodes: %w", err)
+	}
+
+	var free int64
+
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+
+		free += node.Status.Allocatable.Cpu().MilliValue()
+	}
+
+	pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: "status.phase!=" + string(corev1.PodSucceeded) + ",status.phase!=" + string(corev1.PodFailed),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list Harvester pods: %w", err)
+	}
+
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			free -= container.Resources.Requests.Cpu().MilliValue()
+		}
+	}
+
+	return free, nil
+}
+
+// clusterSizes returns the sizes of upstream, tester and all downstream cluster templates
+func clusterSizes(variables map[string]any) ([]clusterSize, error) {
+	var sizes []clusterSize
+
+	for _, name := range []string{"upstream_cluster", "tester_cluster"} {
+		var size clusterSize
+		if err := convert(variables[name], &size); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+
+		size.ClusterCount = 1
+		sizes = append(sizes, size)
+	}
+
+	var templates []clusterSize
+	if err := convert(variables["downstream_cluster_templates"], &templates); err != nil {
+		return nil, fmt.Errorf("failed to parse downstream_cluster_templates: %w", err)
+	}
+
+	return append(sizes, templates...), nil
+}
+
+// convert converts a tofu variable to out, via its JSON representation
+func convert(variable any, out any) error {
+	if variable == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(variable)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// harvesterKubeconfig returns the path of the kubeconfig tofu uses to reach Harvester
+func harvesterKubeconfig(opts Options) string {
+	if path, ok := opts.Variables["kubeconfig"].(string); ok && path != "" {
+		return expandHome(path)
+	}
+
+	if path := os.Getenv("KUBECONFIG"); path != "" {
+		return path
+	}
+
+	return expandHome("~/.kube/config")
+}
+
+// intVariable returns a numeric tofu variable
+func intVariable(variables map[string]any, name string) (int, bool) {
+	switch v := variables[name].(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
+
+// expandHome replaces a leading ~ with the home directory of the current user
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[2:])
+}
+
+// runCLI runs a command, returning its trimmed output or an error including its standard error
+func runCLI(ctx context.Context, name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+
+	var (
+		outStream strings.Builder
+		errStream strings.Builder
+	)
+
+	cmd.Stdout = &outStream
+	cmd.Stderr = &errStream
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(errStream.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(outStream.String()), nil
+}
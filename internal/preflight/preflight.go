@@ -0,0 +1,109 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preflight
+
+import (
+	"context"
+	"time"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// checkTimeout bounds how long a single check may take, eg. when a remote API does not answer
+const checkTimeout = 30 * time.Second
+
+// Options describe the environment about to be deployed
+type Options struct {
+	// Provider is the name of the tofu main directory, eg. k3d, aws, azure or harvester
+	Provider string
+	// Variables are the dart's tofu variables
+	Variables map[string]any
+	// Defaults are the defaults of the provider's tofu variables, which apply where Variables do not set them
+	Defaults map[string]any
+	// Applied is true if the tofu workspace was applied before, so some resources may be in use by its own clusters
+	Applied bool
+}
+
+// Check verifies one prerequisite of a deployment
+type Check struct {
+	Name string
+	Run  func(ctx context.Context, opts Options) (Status, string)
+}
+
+// Result is the outcome of a Check
+type Result struct {
+	Check   string
+	Status  Status
+	Message string
+}
+
+// Checks returns the checks that apply to the provider in opts
+func Checks(opts Options) []Check {
+	checks := []Check{{Name: "local ports", Run: checkPorts}}
+
+	switch opts.Provider {
+	case "k3d":
+		checks = append(checks, Check{Name: "docker", Run: checkDocker})
+	case "aws":
+		checks = append(checks, Check{Name: "ssh keys", Run: checkSSHKeys}, Check{Name: "aws credentials", Run: checkAWSCredentials})
+	case "azure":
+		checks = append(checks, Check{Name: "ssh keys", Run: checkSSHKeys}, Check{Name: "azure credentials", Run: checkAzureCredentials})
+	case "harvester":
+		checks = append(checks,
+			Check{Name: "ssh keys", Run: checkSSHKeys},
+			Check{Name: "harvester kubeconfig", Run: checkHarvesterKubeconfig},
+			Check{Name: "harvester cpu", Run: checkHarvesterCPU},
+		)
+	}
+
+	return checks
+}
+
+// Run runs all checks in order
+func Run(ctx context.Context, opts Options, checks []Check) []Result {
+	results := make([]Result, 0, len(checks))
+
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		status, message := check.Run(checkCtx, opts)
+		cancel()
+
+		results = append(results, Result{Check: check.Name, Status: status, Message: message})
+	}
+
+	return results
+}
+
+// Count returns the number of results with status
+func Count(results []Result, status Status) int {
+	n := 0
+
+	for _, result := range results {
+		if result.Status == status {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,201 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var managementClusterResource = schema.GroupVersionResource{Group: "management.cattle.io", Version: "v3", Resource: "clusters"}
+
+// RancherGates are the gates deploy and upgrade wait for before using Rancher: its pods serve, the API answers, the
+// webhook admits requests, Fleet and CAPI controllers run and the local cluster is Active
+func RancherGates() []Gate {
+	return []Gate{
+		DeploymentAvailable("cattle-system", "rancher", 20*time.Minute),
+		Ping(5 * time.Minute),
+		DeploymentAvailable("cattle-system", "rancher-webhook", 5*time.Minute),
+		EndpointsReady("cattle-system", "rancher-webhook", 3*time.Minute),
+		DeploymentAvailable("cattle-fleet-system", "fleet-controller", 5*time.Minute),
+		Optional(DeploymentAvailable("cattle-provisioning-capi-system", "capi-controller-manager", 5*time.Minute)),
+		LocalClusterActive(10 * time.Minute),
+		APIResponding("/v3", 5*time.Minute),
+	}
+}
+
+// Optional returns gate, made optional
+func Optional(gate Gate) Gate {
+	gate.Optional = true
+	return gate
+}
+
+// DeploymentAvailable passes once a Deployment observed its latest generation, is Available and has all replicas updated
+func DeploymentAvailable(namespace, name string, timeout time.Duration) Gate {
+	return Gate{
+		Name:    fmt.Sprintf("deployment %s/%s available", namespace, name),
+		Timeout: timeout,
+		Check: func(ctx context.Context, t *Target) error {
+			d, err := t.kubernetes.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			if d.Status.ObservedGeneration < d.Generation {
+				return fmt.Errorf("generation %d not observed yet", d.Generation)
+			}
+
+			if d.Spec.Replicas != nil && d.Status.UpdatedReplicas < *d.Spec.Replicas {
+				return fmt.Errorf("%d of %d replicas updated", d.Status.UpdatedReplicas, *d.Spec.Replicas)
+			}
+
+			for _, c := range d.Status.Conditions {
+				if c.Type == appsv1.DeploymentAvailable && c.Status == corev1.ConditionTrue {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("not available, %d of %d replicas ready", d.Status.ReadyReplicas, d.Status.Replicas)
+		},
+	}
+}
+
+// EndpointsReady passes once a Service has at least one ready endpoint, ie. requests to it can be served
+func EndpointsReady(namespace, service string, timeout time.Duration) Gate {
+	return Gate{
+		Name:    fmt.Sprintf("service %s/%s endpoints ready", namespace, service),
+		Timeout: timeout,
+		Check: func(ctx context.Context, t *Target) error {
+			slices, err := t.kubernetes.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+				LabelSelector: discoveryv1.LabelServiceName + "=" + service,
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, slice := range slices.Items {
+				for _, endpoint := range slice.Endpoints {
+					if endpoint.Conditions.Ready != nil && *endpoint.Conditions.Ready {
+						return nil
+					}
+				}
+			}
+
+			return fmt.Errorf("no ready endpoints in %d EndpointSlices", len(slices.Items))
+		},
+	}
+}
+
+// Ping passes once Rancher's /ping answers pong
+func Ping(timeout time.Duration) Gate {
+	return Gate{
+		Name:    "rancher /ping",
+		Timeout: timeout,
+		Check: func(ctx context.Context, t *Target) error {
+			status, body, err := get(ctx, t, "/ping")
+			if err != nil {
+				return err
+			}
+
+			if status != http.StatusOK || strings.TrimSpace(body) != "pong" {
+				return fmt.Errorf("got %d %q", status, body)
+			}
+
+			return nil
+		},
+	}
+}
+
+// APIResponding passes once Rancher answers path without a server error. Unauthenticated requests get 401, which
+// still proves the API is served
+func APIResponding(path string, timeout time.Duration) Gate {
+	return Gate{
+		Name:    "rancher " + path + " responding",
+		Timeout: timeout,
+		Check: func(ctx context.Context, t *Target) error {
+			status, body, err := get(ctx, t, path)
+			if err != nil {
+				return err
+			}
+
+			if status >= http.StatusInternalServerError || status == http.StatusNotFound {
+				return fmt.Errorf("got %d %q", status, body)
+			}
+
+			return nil
+		},
+	}
+}
+
+// LocalClusterActive passes once Rancher's local management Cluster is Ready, shown as Active in the UI
+func LocalClusterActive(timeout time.Duration) Gate {
+	return Gate{
+		Name:    "local cluster active",
+		Timeout: timeout,
+		Check: func(ctx context.Context, t *Target) error {
+			cluster, err := t.dynamic.Resource(managementClusterResource).Get(ctx, "local", metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			conditions, _, _ := unstructured.NestedSlice(cluster.Object, "status", "conditions")
+			for _, c := range conditions {
+				if condition, ok := c.(map[string]any); ok && condition["type"] == "Ready" {
+					if condition["status"] == "True" {
+						return nil
+					}
+
+					return fmt.Errorf("not ready: %v", condition["message"])
+				}
+			}
+
+			return fmt.Errorf("no Ready condition yet")
+		},
+	}
+}
+
+// get requests path from Rancher, returning status and a prefix of the body
+func get(ctx context.Context, t *Target, path string) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(t.URL, "/")+path, nil)
+	if err != nil {
+		return 0, "", err
+	}
+
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return 0, "", err
+	}
+
+	return resp.StatusCode, string(body), nil
+}
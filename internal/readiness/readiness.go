@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package readiness
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// pollInterval is how often a gate is checked until it passes
+const pollInterval = 5 * time.Second
+
+// requestTimeout bounds a single HTTP request of a gate
+const requestTimeout = 10 * time.Second
+
+// Gate is one condition Rancher must meet before it can be relied upon
+type Gate struct {
+	Name    string
+	Timeout time.Duration
+	// Optional gates only log a warning if they do not pass, eg. for components older Rancher versions do not have
+	Optional bool
+	// Check returns nil if the condition is met, otherwise why it is not
+	Check func(ctx context.Context, t *Target) error
+}
+
+// Target is the Rancher installation gates are checked against
+type Target struct {
+	// URL is Rancher's URL as reachable from this machine
+	URL string
+
+	kubernetes kubernetes.Interface
+	dynamic    dynamic.Interface
+	http       *http.Client
+}
+
+// NewTarget returns a Target for the Rancher at url, installed on the cluster of the kubeconfig at kubePath
+func NewTarget(kubePath, url string) (*Target, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubePath)
+	if err != nil {
+		return nil, fmt.Errorf("readiness: failed to read kubeconfig %s: %w", kubePath, err)
+	}
+
+	return newTarget(config, url)
+}
+
+func newTarget(config *rest.Config, url string) (*Target, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("readiness: failed to create client: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("readiness: failed to create client: %w", err)
+	}
+
+	return &Target{
+		URL:        url,
+		kubernetes: clientset,
+		dynamic:    dynamicClient,
+		http: &http.Client{
+			Timeout: requestTimeout,
+			// Rancher's certificate is self-signed in most test environments
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, //nolint:gosec
+		},
+	}, nil
+}
+
+// Wait checks gates in order, each until it passes or its timeout expires. It returns at the first required gate
+// that did not pass
+func Wait(ctx context.Context, t *Target, gates []Gate) error {
+	for _, gate := range gates {
+		start := time.Now()
+
+		err := wait(ctx, t, gate)
+		if err == nil {
+			logrus.Infof("Readiness gate %q passed after %s", gate.Name, time.Since(start).Round(time.Second))
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if gate.Optional {
+			logrus.Warnf("Optional readiness gate %q did not pass: %v", gate.Name, err)
+			continue
+		}
+
+		return fmt.Errorf("readiness gate %q did not pass within %s: %w", gate.Name, gate.Timeout, err)
+	}
+
+	return nil
+}
+
+// wait checks gate every pollInterval until it passes, returning the last check error if it times out
+func wait(ctx context.Context, t *Target, gate Gate) error {
+	gateCtx, cancel := context.WithTimeout(ctx, gate.Timeout)
+	defer cancel()
+
+	for {
+		err := gate.Check(gateCtx, t)
+		if err == nil {
+			return nil
+		}
+
+		logrus.Debugf("Readiness gate %q: %v", gate.Name, err)
+
+		select {
+		case <-gateCtx.Done():
+			if errors.Is(gateCtx.Err(), context.DeadlineExceeded) {
+				return err
+			}
+
+			return gateCtx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Names of the built-in retryable error classifiers, as used in the dart
+const (
+	APITimeout      = "api_timeout"
+	WebhookNotReady = "webhook_not_ready"
+	TLSHandshake    = "tls_handshake"
+)
+
+// Classifier returns true if err is a transient failure worth retrying
+type Classifier func(err error) bool
+
+var classifiers = map[string]Classifier{
+	APITimeout:      isAPITimeout,
+	WebhookNotReady: isWebhookNotReady,
+	TLSHandshake:    isTLSHandshake,
+}
+
+// Policy describes how many times, and how often, an operation failing with a transient error is retried
+type Policy struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Factor         float64       `yaml:"factor"`
+	Jitter         float64       `yaml:"jitter"`
+	RetryOn        []string      `yaml:"retry_on"`
+}
+
+// DefaultPolicy returns the policy used when the dart does not specify one
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    5,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		Factor:         2,
+		Jitter:         0.1,
+		RetryOn:        []string{APITimeout, WebhookNotReady, TLSHandshake},
+	}
+}
+
+// Validate returns an error if the policy cannot be used
+func (p Policy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("retry policy: max_attempts must be >= 1, got %d", p.MaxAttempts)
+	}
+
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("retry policy: backoff durations must not be negative")
+	}
+
+	if p.Factor < 1 {
+		return fmt.Errorf("retry policy: factor must be >= 1, got %v", p.Factor)
+	}
+
+	for _, name := range p.RetryOn {
+		if _, ok := classifiers[name]; !ok {
+			return fmt.Errorf("retry policy: unknown retry_on classifier %q", name)
+		}
+	}
+
+	return nil
+}
+
+// IsRetryable returns true if err matches any of the classifiers enabled in the policy
+func (p Policy) IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	for _, name := range p.RetryOn {
+		if classify, ok := classifiers[name]; ok && classify(err) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Do calls fn until it succeeds, fails with a non-retryable error, MaxAttempts is reached or ctx is done.
+// what is a short description of the operation, used for logging
+func (p Policy) Do(ctx context.Context, what string, fn func() error) error {
+	backoff := p.InitialBackoff
+
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.MaxAttempts || !p.IsRetryable(err) {
+			return err
+		}
+
+		delay := backoff
+		if p.Jitter > 0 {
+			delay += time.Duration(rand.Float64() * p.Jitter * float64(backoff))
+		}
+
+		logrus.Warnf("%s failed with a transient error, retrying in %v (attempt %d/%d): %v", what, delay.Round(time.Millisecond), attempt, p.MaxAttempts, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w (last error: %w)", what, ctx.Err(), err)
+		case <-time.After(delay):
+		}
+
+		backoff = time.Duration(float64(backoff) * p.Factor)
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// containsAny returns true if the lowercased error message contains any of the given substrings
+func containsAny(err error, substrings ...string) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range substrings {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAPITimeout matches Kubernetes/Rancher API timeouts, throttling and dropped connections.
+// Errors from vendored binaries only carry their stderr, so message matching is needed in addition to typed checks
+func isAPITimeout(err error) bool {
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return containsAny(err,
+		"i/o timeout",
+		"client.timeout exceeded",
+		"request timed out",
+		"etcdserver: request timed out",
+		"the server was unable to return a response in the time allotted",
+		"the server is currently unable to handle the request",
+		"too many requests",
+		"connection reset by peer",
+		"http2: client connection lost",
+		"unexpected eof",
+		"503 service unavailable",
+		"504 gateway timeout",
+	)
+}
+
+// isWebhookNotReady matches admission webhooks (typically rancher-webhook or cert-manager's) not being served yet
+func isWebhookNotReady(err error) bool {
+	return containsAny(err,
+		"failed calling webhook",
+		"no endpoints available for service",
+		"service \"rancher-webhook\" not found",
+		"service \"cert-manager-webhook\" not found",
+	)
+}
+
+// isTLSHandshake matches TLS handshakes failing while certificates or load balancers are being set up
+func isTLSHandshake(err error) bool {
+	return containsAny(err,
+		"tls handshake timeout",
+		"tls: handshake failure",
+		"remote error: tls:",
+		"tls: internal error",
+	)
+}
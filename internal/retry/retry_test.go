@@ -0,0 +1,212 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "dial tcp 10.0.0.1:443: operation timed out" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var transient = errors.New("read tcp 10.0.0.1:443: connection reset by peer")
+
+// testPolicy retries without waiting
+func testPolicy(maxAttempts int) Policy {
+	p := DefaultPolicy()
+	p.MaxAttempts = maxAttempts
+	p.InitialBackoff = 0
+	p.MaxBackoff = 0
+	p.Jitter = 0
+
+	return p
+}
+
+func TestIsRetryable(t *testing.T) {
+	clusters := schema.GroupResource{Group: "provisioning.cattle.io", Resource: "clusters"}
+
+	tests := []struct {
+		name    string
+		retryOn []string
+		err     error
+		want    bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "wrapped canceled", err: fmt.Errorf("get: %w", context.Canceled), want: false},
+		{name: "not found", err: apierrors.NewNotFound(clusters, "c1"), want: false},
+		{name: "conflict", err: apierrors.NewConflict(clusters, "c1", errors.New("modified")), want: false},
+		{name: "plain error", err: errors.New("invalid value for field spec"), want: false},
+		{name: "server timeout", err: apierrors.NewServerTimeout(clusters, "get", 1), want: true},
+		{name: "too many requests", err: apierrors.NewTooManyRequests("slow down", 1), want: true},
+		{name: "net timeout", err: timeoutError{}, want: true},
+		{name: "wrapped net timeout", err: fmt.Errorf("get: %w", timeoutError{}), want: true},
+		{name: "i/o timeout text", err: errors.New("dial tcp 10.0.0.1:443: I/O Timeout"), want: true},
+		{name: "connection reset", err: transient, want: true},
+		{name: "unavailable", err: errors.New("503 Service Unavailable"), want: true},
+		{name: "webhook", err: errors.New(`Internal error occurred: failed calling webhook "rancher.cattle.io"`), want: true},
+		{name: "webhook endpoints", err: errors.New(`no endpoints available for service "rancher-webhook"`), want: true},
+		{name: "tls handshake", err: errors.New("net/http: TLS handshake timeout"), want: true},
+		{name: "tls remote", err: errors.New("remote error: tls: bad certificate"), want: true},
+		{name: "classifier disabled", retryOn: []string{TLSHandshake}, err: transient, want: false},
+		{name: "classifier enabled", retryOn: []string{TLSHandshake}, err: errors.New("tls: handshake failure"), want: true},
+		{name: "no classifiers", retryOn: []string{}, err: transient, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultPolicy()
+			if tt.retryOn != nil {
+				p.RetryOn = tt.retryOn
+			}
+
+			if got := p.IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	permanent := errors.New("invalid value for field spec")
+
+	tests := []struct {
+		name         string
+		maxAttempts  int
+		errs         []error // returned by successive attempts, nil once exhausted
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "success", maxAttempts: 3, errs: nil, wantAttempts: 1},
+		{name: "transient then success", maxAttempts: 3, errs: []error{transient, transient}, wantAttempts: 3},
+		{name: "permanent", maxAttempts: 3, errs: []error{permanent}, wantAttempts: 1, wantErr: permanent},
+		{name: "transient then permanent", maxAttempts: 3, errs: []error{transient, permanent}, wantAttempts: 2, wantErr: permanent},
+		{name: "attempts exhausted", maxAttempts: 3, errs: []error{transient, transient, transient, transient}, wantAttempts: 3, wantErr: transient},
+		{name: "single attempt", maxAttempts: 1, errs: []error{transient}, wantAttempts: 1, wantErr: transient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+
+			err := testPolicy(tt.maxAttempts).Do(context.Background(), tt.name, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+
+				return nil
+			})
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("Do() made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	p := testPolicy(5)
+	p.InitialBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- p.Do(ctx, "canceled", func() error {
+			attempts++
+			cancel()
+
+			return transient
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Do() error = %v, want context.Canceled", err)
+		}
+
+		if !errors.Is(err, transient) {
+			t.Errorf("Do() error = %v, want it to wrap the last error", err)
+		}
+
+		if attempts != 1 {
+			t.Errorf("Do() made %d attempts, want 1", attempts)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Do() did not return after ctx was canceled")
+	}
+}
+
+func TestDoBackoff(t *testing.T) {
+	p := testPolicy(4)
+	p.InitialBackoff = 10 * time.Millisecond
+	p.MaxBackoff = 20 * time.Millisecond
+
+	start := time.Now()
+
+	// waits 10ms, then 20ms, then 20ms capped by MaxBackoff
+	_ = p.Do(context.Background(), "backoff", func() error { return transient })
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Do() took %v, want about 50ms", elapsed)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(p *Policy)
+		wantErr bool
+	}{
+		{name: "default", modify: func(*Policy) {}},
+		{name: "zero attempts", modify: func(p *Policy) { p.MaxAttempts = 0 }, wantErr: true},
+		{name: "negative backoff", modify: func(p *Policy) { p.InitialBackoff = -time.Second }, wantErr: true},
+		{name: "factor below one", modify: func(p *Policy) { p.Factor = 0.5 }, wantErr: true},
+		{name: "unknown classifier", modify: func(p *Policy) { p.RetryOn = []string{"dns"} }, wantErr: true},
+		{name: "no classifiers", modify: func(p *Policy) { p.RetryOn = nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultPolicy()
+			tt.modify(&p)
+
+			if err := p.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"github.com/rancher/dartboard/internal/grafana"
+	"github.com/rancher/dartboard/internal/retry"
+	"github.com/rancher/dartboard/internal/throttle"
+)
+
+// This is the one registry of settings of the current dart that are needed deep in call stacks, where passing them
+// explicitly would add a parameter to nearly every helm, kubectl and Rancher API call. Everything else is passed
+// explicitly, mostly on dart.Dart.
+//
+// The CLI sets each setting once, before any concurrent work starts, and they are only read afterwards. Code that runs
+// without the CLI, eg. tests, gets defaults.
+var (
+	retryPolicy = retry.DefaultPolicy()
+	limiter     = throttle.New(throttle.DefaultPolicy())
+	grafanaAPI  *grafana.Client
+)
+
+// SetRetry sets the policy of retried helm, kubectl and Rancher API calls, see dart.Dart.RetryPolicy
+func SetRetry(p retry.Policy) {
+	retryPolicy = p
+}
+
+// Retry returns the policy of retried helm, kubectl and Rancher API calls
+func Retry() retry.Policy {
+	return retryPolicy
+}
+
+// SetLimiter sets the limiter shared by all jobs onboarding or changing downstream clusters, see dart.Dart.Onboarding
+func SetLimiter(l *throttle.Limiter) {
+	limiter = l
+}
+
+// Limiter returns the limiter shared by all jobs onboarding or changing downstream clusters
+func Limiter() *throttle.Limiter {
+	return limiter
+}
+
+// SetGrafana sets the client of the tester cluster's Grafana, once it is known to be reachable
+func SetGrafana(c *grafana.Client) {
+	grafanaAPI = c
+}
+
+// Grafana returns the client annotations are posted with, nil if there is no reachable Grafana
+func Grafana() *grafana.Client {
+	return grafanaAPI
+}
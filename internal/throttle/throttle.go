@@ -0,0 +1,287 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// minObservations is the number of API calls observed before adaptive mode kicks in
+	minObservations = 5
+	// ewmaWeight is the weight of the newest observation in latency and error rate averages
+	ewmaWeight = 0.2
+	// latencyTolerance is how many times the baseline latency is considered normal
+	latencyTolerance = 1.5
+	// errorWeight is the extra slowdown when all recent calls failed with transient errors
+	errorWeight = 4
+	// maxSlowdown caps how much adaptive mode can slow onboarding down
+	maxSlowdown = 10
+)
+
+// Policy describes how fast downstream clusters are onboarded into Rancher
+type Policy struct {
+	// Rate is the target number of clusters started per minute, 0 means no limit
+	Rate float64 `yaml:"rate"`
+	// MaxInFlight is the maximum number of clusters being onboarded at the same time, 0 means twice the CPUs
+	MaxInFlight int `yaml:"max_in_flight"`
+	// Adaptive slows down rate and concurrency when Rancher API latency or transient error rate rise
+	Adaptive bool `yaml:"adaptive"`
+}
+
+// DefaultPolicy returns the policy used when the dart does not specify one
+func DefaultPolicy() Policy {
+	return Policy{}
+}
+
+// Validate returns an error if the policy cannot be used
+func (p Policy) Validate() error {
+	if p.Rate < 0 {
+		return fmt.Errorf("onboarding: rate must be >= 0, got %v", p.Rate)
+	}
+
+	if p.MaxInFlight < 0 {
+		return fmt.Errorf("onboarding: max_in_flight must be >= 0, got %d", p.MaxInFlight)
+	}
+
+	return nil
+}
+
+// Limiter paces and caps concurrent jobs according to a Policy
+type Limiter struct {
+	policy      Policy
+	maxInFlight int
+
+	mu       sync.Mutex
+	inFlight int
+	// nextStart is the earliest time the next job may start
+	nextStart time.Time
+	// released is closed and replaced whenever a job finishes, waking up waiters
+	released chan struct{}
+
+	observations int
+	// latency and baseline are in seconds, errorRate in [0, 1]
+	latency      float64
+	baseline     float64
+	errorRate    float64
+	lastSlowdown float64
+}
+
+// New returns a Limiter enforcing p
+func New(p Policy) *Limiter {
+	maxInFlight := p.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = runtime.GOMAXPROCS(0) * 2
+	}
+
+	return &Limiter{
+		policy:       p,
+		maxInFlight:  maxInFlight,
+		released:     make(chan struct{}),
+		lastSlowdown: 1,
+	}
+}
+
+// MaxInFlight returns the maximum number of jobs that can ever run at the same time
+func (l *Limiter) MaxInFlight() int {
+	return l.maxInFlight
+}
+
+// Paced returns true if a target rate is set, in which case no further pauses between batches are needed
+func (l *Limiter) Paced() bool {
+	return l.policy.Rate > 0
+}
+
+// Acquire blocks until a job may start, or ctx is done. The returned function must be called when the job is over
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	for {
+		l.mu.Lock()
+
+		slowdown := l.slowdown()
+		if l.inFlight < max(1, int(float64(l.maxInFlight)/slowdown)) {
+			l.inFlight++
+
+			start := time.Now()
+			if l.nextStart.After(start) {
+				start = l.nextStart
+			}
+
+			if l.policy.Rate > 0 {
+				l.nextStart = start.Add(time.Duration(float64(time.Minute) / l.policy.Rate * slowdown))
+			}
+
+			l.mu.Unlock()
+
+			var once sync.Once
+
+			release := func() { once.Do(l.release) }
+
+			select {
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			case <-time.After(time.Until(start)):
+				return release, nil
+			}
+		}
+
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// Each calls fn for indexes 0 to n-1 concurrently, at most size at a time, starting each call only once the limiter
+// allows it. It returns all errors. Once ctx is done, or with failFast after the first error, no new call is started
+func (l *Limiter) Each(ctx context.Context, size, n int, failFast bool, fn func(i int) error) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []error
+		failed atomic.Bool
+	)
+
+	sem := make(chan struct{}, max(size, 1))
+
+	for i := range n {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return errors.Join(append(errs, ctx.Err())...)
+		case sem <- struct{}{}:
+		}
+
+		release, err := l.Acquire(ctx)
+		if err == nil && ctx.Err() != nil {
+			// Acquire may succeed concurrently with ctx being done
+			release()
+
+			err = ctx.Err()
+		}
+
+		if err != nil {
+			wg.Wait()
+			return errors.Join(append(errs, err)...)
+		}
+
+		if failFast && failed.Load() {
+			release()
+			break
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer release()
+
+			if err := fn(i); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+
+				failed.Store(true)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// release marks a job as finished and wakes up waiters
+func (l *Limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// Observe records the latency of an API call, and whether it failed with a transient error
+func (l *Limiter) Observe(latency time.Duration, transientErr bool) {
+	if !l.policy.Adaptive {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	failed := 0.0
+	if transientErr {
+		failed = 1
+	}
+
+	if l.observations == 0 {
+		l.latency = latency.Seconds()
+		l.errorRate = failed
+	} else {
+		l.latency = ewmaWeight*latency.Seconds() + (1-ewmaWeight)*l.latency
+		l.errorRate = ewmaWeight*failed + (1-ewmaWeight)*l.errorRate
+	}
+
+	l.observations++
+
+	if l.observations >= minObservations && (l.baseline == 0 || l.latency < l.baseline) {
+		l.baseline = l.latency
+	}
+
+	slowdown := l.slowdown()
+	if math.Abs(slowdown-l.lastSlowdown) >= 0.5 {
+		logrus.Infof("Rancher API latency %v (baseline %v), transient error rate %.0f%%: onboarding slowed down %.1fx",
+			time.Duration(l.latency*float64(time.Second)).Round(time.Millisecond),
+			time.Duration(l.baseline*float64(time.Second)).Round(time.Millisecond),
+			l.errorRate*100, slowdown)
+
+		l.lastSlowdown = slowdown
+	}
+}
+
+// slowdown returns how many times slower than configured jobs should be started: 1 when the API is healthy,
+// growing with latency above the baseline and with the transient error rate. Must be called with mu held
+func (l *Limiter) slowdown() float64 {
+	if !l.policy.Adaptive || l.observations < minObservations || l.baseline <= 0 {
+		return 1
+	}
+
+	result := 1.0
+	if ratio := l.latency / l.baseline; ratio > latencyTolerance {
+		result = ratio / latencyTolerance
+	}
+
+	result *= 1 + errorWeight*l.errorRate
+
+	return min(result, maxSlowdown)
+}
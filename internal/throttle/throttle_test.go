@@ -0,0 +1,130 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEach(t *testing.T) {
+	failing := errors.New("failed")
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		size        int
+		n           int
+		failFast    bool
+		fail        map[int]bool
+		wantCalls   int
+		wantErrs    int
+		wantMaxConc int
+	}{
+		{name: "all succeed", policy: Policy{MaxInFlight: 8}, size: 3, n: 10, wantCalls: 10, wantMaxConc: 3},
+		{name: "limiter caps concurrency", policy: Policy{MaxInFlight: 2}, size: 5, n: 10, wantCalls: 10, wantMaxConc: 2},
+		{name: "no calls", policy: Policy{MaxInFlight: 2}, size: 5, n: 0},
+		{name: "errors are collected", policy: Policy{MaxInFlight: 1}, size: 1, n: 5, fail: map[int]bool{1: true, 3: true}, wantCalls: 5, wantErrs: 2, wantMaxConc: 1},
+		{name: "fail fast", policy: Policy{MaxInFlight: 1}, size: 1, n: 5, failFast: true, fail: map[int]bool{1: true}, wantCalls: 2, wantErrs: 1, wantMaxConc: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				calls, running atomic.Int32
+				mu             sync.Mutex
+				maxConc        int
+			)
+
+			err := New(tt.policy).Each(context.Background(), tt.size, tt.n, tt.failFast, func(i int) error {
+				calls.Add(1)
+
+				current := int(running.Add(1))
+				defer running.Add(-1)
+
+				mu.Lock()
+				maxConc = max(maxConc, current)
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				if tt.fail[i] {
+					return failing
+				}
+
+				return nil
+			})
+
+			if got := int(calls.Load()); got != tt.wantCalls {
+				t.Errorf("Each() made %d calls, want %d", got, tt.wantCalls)
+			}
+
+			var joined interface{ Unwrap() []error }
+
+			switch {
+			case tt.wantErrs == 0 && err != nil:
+				t.Errorf("Each() error = %v, want nil", err)
+			case tt.wantErrs > 0 && (!errors.As(err, &joined) || len(joined.Unwrap()) != tt.wantErrs):
+				t.Errorf("Each() error = %v, want %d errors", err, tt.wantErrs)
+			}
+
+			if maxConc > tt.wantMaxConc {
+				t.Errorf("Each() ran %d calls at the same time, want at most %d", maxConc, tt.wantMaxConc)
+			}
+		})
+	}
+}
+
+func TestEachPaced(t *testing.T) {
+	// 600 calls per minute start one every 100ms
+	l := New(Policy{Rate: 600, MaxInFlight: 10})
+
+	start := time.Now()
+
+	if err := l.Each(context.Background(), 10, 4, false, func(int) error { return nil }); err != nil {
+		t.Fatalf("Each() error = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Each() took %v, want about 300ms", elapsed)
+	}
+}
+
+func TestEachCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls atomic.Int32
+
+	err := New(Policy{MaxInFlight: 1}).Each(ctx, 1, 5, false, func(int) error {
+		calls.Add(1)
+		cancel()
+
+		return nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Each() error = %v, want context.Canceled", err)
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Each() made %d calls after ctx was canceled, want 1", got)
+	}
+}
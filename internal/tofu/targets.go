@@ -0,0 +1,216 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tofu
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// testEnvironmentModule is the address of the generic test_environment module, used by all mains
+const testEnvironmentModule = "module.test_environment"
+
+var (
+	// UpstreamAddresses are the addresses of all upstream cluster resources
+	UpstreamAddresses = []string{
+		testEnvironmentModule + ".module.upstream_cluster",
+		testEnvironmentModule + ".module.upstream_postgres",
+	}
+	// TesterAddresses are the addresses of all tester cluster resources
+	TesterAddresses = []string{testEnvironmentModule + ".module.tester_cluster"}
+)
+
+// DownstreamCluster is a downstream cluster created by tofu, along with the addresses of its resources
+type DownstreamCluster struct {
+	Name      string
+	Addresses []string
+}
+
+// downstreamClusterTemplate holds the downstream_cluster_templates fields that determine cluster names and addresses
+type downstreamClusterTemplate struct {
+	ClusterCount    int  `json:"cluster_count"`
+	ServerCount     int  `json:"server_count"`
+	IsCustomCluster bool `json:"is_custom_cluster"`
+}
+
+// DownstreamClusters returns the downstream clusters created by tofu for the given variables. Names and
+// indexes mirror the locals in the test_environment module, and must be kept in sync with them
+func DownstreamClusters(variables map[string]any) ([]DownstreamCluster, error) {
+	templates, err := parseDownstreamClusterTemplates(variables)
+	if err != nil {
+		return nil, err
+	}
+
+	return downstreamClusters(templates), nil
+}
+
+// AppliedDownstreamClusters is like DownstreamClusters, but uses the cluster counts found in outputs of the last
+// apply instead of the ones in variables, which might have been changed since
+func AppliedDownstreamClusters(variables map[string]any, clusters map[string]Cluster, customClusters []CustomCluster) ([]DownstreamCluster, error) {
+	templates, err := parseDownstreamClusterTemplates(variables)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[int]int{}
+
+	for name := range clusters {
+		var i, j int
+		if n, _ := fmt.Sscanf(name, "downstream-%d-%d", &i, &j); n == 2 {
+			counts[i] = max(counts[i], j+1)
+		}
+	}
+
+	for _, customCluster := range customClusters {
+		var i int
+		if n, _ := fmt.Sscanf(customCluster.Name, "downstream-custom-%d", &i); n != 1 {
+			return nil, fmt.Errorf("unexpected custom cluster name %q in tofu outputs", customCluster.Name)
+		}
+
+		if i >= len(templates) {
+			return nil, fmt.Errorf("custom cluster template %d was removed from downstream_cluster_templates", i)
+		}
+
+		if customCluster.ServerCount != templates[i].ServerCount {
+			return nil, fmt.Errorf("server_count of custom cluster template %d changed from %d to %d, which requires a full redeploy",
+				i, customCluster.ServerCount, templates[i].ServerCount)
+		}
+
+		counts[i] = customCluster.ClusterCount
+	}
+
+	for i := range templates {
+		templates[i].ClusterCount = counts[i]
+		delete(counts, i)
+	}
+
+	for i := range counts {
+		return nil, fmt.Errorf("downstream cluster template %d was removed from downstream_cluster_templates", i)
+	}
+
+	return downstreamClusters(templates), nil
+}
+
+func parseDownstreamClusterTemplates(variables map[string]any) ([]downstreamClusterTemplate, error) {
+	data, err := json.Marshal(variables["downstream_cluster_templates"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal downstream_cluster_templates: %w", err)
+	}
+
+	var templates []downstreamClusterTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("failed to parse downstream_cluster_templates: %w", err)
+	}
+
+	return templates, nil
+}
+
+func downstreamClusters(templates []downstreamClusterTemplate) []DownstreamCluster {
+	var (
+		result      []DownstreamCluster
+		clusterIdx  int
+		customNodes int
+	)
+
+	for i, template := range templates {
+		if template.ClusterCount <= 0 {
+			continue
+		}
+
+		if !template.IsCustomCluster {
+			for j := range template.ClusterCount {
+				result = append(result, DownstreamCluster{
+					Name:      fmt.Sprintf("downstream-%d-%d", i, j),
+					Addresses: []string{fmt.Sprintf("%s.module.downstream_clusters[%d]", testEnvironmentModule, clusterIdx)},
+				})
+				clusterIdx++
+			}
+
+			continue
+		}
+
+		// custom cluster nodes are split evenly among the template's clusters, see RegisterCustomClustersInBatches
+		for j := range template.ClusterCount {
+			cluster := DownstreamCluster{Name: fmt.Sprintf("downstream-custom-%d-%d", i, j)}
+
+			for range template.ServerCount {
+				cluster.Addresses = append(cluster.Addresses, fmt.Sprintf("%s.module.nodes[%d]", testEnvironmentModule, customNodes))
+				customNodes++
+			}
+
+			result = append(result, cluster)
+		}
+	}
+
+	return result
+}
+
+// StateMove is a `tofu state mv` operation
+type StateMove struct {
+	From string
+	To   string
+}
+
+// StateMoves returns the moves that renumber resources of clusters in both before and after, so that they are not
+// recreated when the layout changes. Moves are ordered so that none overwrites a resource that still has to be moved
+func StateMoves(before, after []DownstreamCluster) []StateMove {
+	addresses := map[string][]string{}
+	for _, cluster := range after {
+		addresses[cluster.Name] = cluster.Addresses
+	}
+
+	var down, up []StateMove
+
+	for _, cluster := range before {
+		for k, from := range cluster.Addresses {
+			if k >= len(addresses[cluster.Name]) {
+				break
+			}
+
+			to := addresses[cluster.Name][k]
+
+			switch {
+			case addressIndex(to) < addressIndex(from):
+				down = append(down, StateMove{From: from, To: to})
+			case addressIndex(to) > addressIndex(from):
+				up = append(up, StateMove{From: from, To: to})
+			}
+		}
+	}
+
+	// clusters keep their relative order, so freeing lower indexes first when moving down, and higher ones first
+	// when moving up, never overwrites another cluster
+	slices.SortFunc(down, func(a, b StateMove) int { return addressIndex(a.From) - addressIndex(b.From) })
+	slices.SortFunc(up, func(a, b StateMove) int { return addressIndex(b.From) - addressIndex(a.From) })
+
+	return append(down, up...)
+}
+
+// addressIndex returns the count index at the end of a resource address, eg. 3 for module.nodes[3]
+func addressIndex(address string) int {
+	i := strings.LastIndex(address, "[")
+
+	index, err := strconv.Atoi(strings.TrimSuffix(address[i+1:], "]"))
+	if err != nil {
+		return -1
+	}
+
+	return index
+}
@@ -0,0 +1,252 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tofu
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	clustersAddress = testEnvironmentModule + ".module.downstream_clusters"
+	nodesAddress    = testEnvironmentModule + ".module.nodes"
+)
+
+// templates builds a downstream_cluster_templates variable, as parsed from a dart file
+func templates(ts ...map[string]any) map[string]any {
+	list := make([]any, 0, len(ts))
+	for _, t := range ts {
+		list = append(list, t)
+	}
+
+	return map[string]any{"downstream_cluster_templates": list}
+}
+
+func TestDownstreamClusters(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables map[string]any
+		want      []DownstreamCluster
+		wantErr   bool
+	}{
+		{name: "no templates", variables: map[string]any{}, want: nil},
+		{
+			name: "imported clusters are numbered across templates",
+			variables: templates(
+				map[string]any{"cluster_count": 2, "server_count": 1},
+				map[string]any{"cluster_count": 0, "server_count": 1},
+				map[string]any{"cluster_count": 1, "server_count": 3},
+			),
+			want: []DownstreamCluster{
+				{Name: "downstream-0-0", Addresses: []string{clustersAddress + "[0]"}},
+				{Name: "downstream-0-1", Addresses: []string{clustersAddress + "[1]"}},
+				{Name: "downstream-2-0", Addresses: []string{clustersAddress + "[2]"}},
+			},
+		},
+		{
+			name: "custom clusters own one node per server",
+			variables: templates(
+				map[string]any{"cluster_count": 1, "server_count": 1},
+				map[string]any{"cluster_count": 2, "server_count": 2, "is_custom_cluster": true},
+			),
+			want: []DownstreamCluster{
+				{Name: "downstream-0-0", Addresses: []string{clustersAddress + "[0]"}},
+				{Name: "downstream-custom-1-0", Addresses: []string{nodesAddress + "[0]", nodesAddress + "[1]"}},
+				{Name: "downstream-custom-1-1", Addresses: []string{nodesAddress + "[2]", nodesAddress + "[3]"}},
+			},
+		},
+		{name: "invalid templates", variables: map[string]any{"downstream_cluster_templates": "two"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DownstreamClusters(tt.variables)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownstreamClusters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DownstreamClusters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppliedDownstreamClusters(t *testing.T) {
+	variables := templates(
+		map[string]any{"cluster_count": 5, "server_count": 1},
+		map[string]any{"cluster_count": 3, "server_count": 1, "is_custom_cluster": true},
+	)
+
+	tests := []struct {
+		name           string
+		variables      map[string]any
+		clusters       map[string]Cluster
+		customClusters []CustomCluster
+		want           []string
+		wantErr        bool
+	}{
+		{name: "never applied", variables: variables, want: nil},
+		{
+			name:      "counts come from outputs",
+			variables: variables,
+			clusters:  map[string]Cluster{"upstream": {}, "downstream-0-0": {}, "downstream-0-1": {}},
+			customClusters: []CustomCluster{
+				{Name: "downstream-custom-1", ClusterCount: 1, ServerCount: 1},
+			},
+			want: []string{"downstream-0-0", "downstream-0-1", "downstream-custom-1-0"},
+		},
+		{
+			name:      "removed template",
+			variables: templates(map[string]any{"cluster_count": 1, "server_count": 1}),
+			clusters:  map[string]Cluster{"downstream-0-0": {}, "downstream-1-0": {}},
+			wantErr:   true,
+		},
+		{
+			name:           "changed server count",
+			variables:      variables,
+			customClusters: []CustomCluster{{Name: "downstream-custom-1", ClusterCount: 1, ServerCount: 3}},
+			wantErr:        true,
+		},
+		{
+			name:           "unexpected custom cluster name",
+			variables:      variables,
+			customClusters: []CustomCluster{{Name: "custom", ClusterCount: 1, ServerCount: 1}},
+			wantErr:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters, err := AppliedDownstreamClusters(tt.variables, tt.clusters, tt.customClusters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AppliedDownstreamClusters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, cluster := range clusters {
+				got = append(got, cluster.Name)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AppliedDownstreamClusters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateMoves(t *testing.T) {
+	layout := func(ts ...map[string]any) []DownstreamCluster {
+		clusters, err := DownstreamClusters(templates(ts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return clusters
+	}
+
+	tests := []struct {
+		name   string
+		before []DownstreamCluster
+		after  []DownstreamCluster
+		want   []StateMove
+	}{
+		{
+			name:   "unchanged",
+			before: layout(map[string]any{"cluster_count": 2, "server_count": 1}),
+			after:  layout(map[string]any{"cluster_count": 2, "server_count": 1}),
+			want:   nil,
+		},
+		{
+			name:   "clusters added at the end",
+			before: layout(map[string]any{"cluster_count": 1, "server_count": 1}),
+			after:  layout(map[string]any{"cluster_count": 3, "server_count": 1}),
+			want:   nil,
+		},
+		{
+			name: "first template grows, later clusters move up highest first",
+			before: layout(
+				map[string]any{"cluster_count": 1, "server_count": 1},
+				map[string]any{"cluster_count": 2, "server_count": 1},
+			),
+			after: layout(
+				map[string]any{"cluster_count": 2, "server_count": 1},
+				map[string]any{"cluster_count": 2, "server_count": 1},
+			),
+			want: []StateMove{
+				{From: clustersAddress + "[2]", To: clustersAddress + "[3]"},
+				{From: clustersAddress + "[1]", To: clustersAddress + "[2]"},
+			},
+		},
+		{
+			name: "first template shrinks, later clusters move down lowest first",
+			before: layout(
+				map[string]any{"cluster_count": 2, "server_count": 1},
+				map[string]any{"cluster_count": 2, "server_count": 1},
+			),
+			after: layout(
+				map[string]any{"cluster_count": 1, "server_count": 1},
+				map[string]any{"cluster_count": 2, "server_count": 1},
+			),
+			want: []StateMove{
+				{From: clustersAddress + "[2]", To: clustersAddress + "[1]"},
+				{From: clustersAddress + "[3]", To: clustersAddress + "[2]"},
+			},
+		},
+		{
+			name: "custom cluster nodes are renumbered",
+			before: layout(
+				map[string]any{"cluster_count": 1, "server_count": 2, "is_custom_cluster": true},
+				map[string]any{"cluster_count": 1, "server_count": 1, "is_custom_cluster": true},
+			),
+			after: layout(
+				map[string]any{"cluster_count": 2, "server_count": 2, "is_custom_cluster": true},
+				map[string]any{"cluster_count": 1, "server_count": 1, "is_custom_cluster": true},
+			),
+			want: []StateMove{
+				{From: nodesAddress + "[2]", To: nodesAddress + "[4]"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StateMoves(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StateMoves() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressIndex(t *testing.T) {
+	tests := []struct {
+		address string
+		want    int
+	}{
+		{address: nodesAddress + "[3]", want: 3},
+		{address: clustersAddress + "[12]", want: 12},
+		{address: testEnvironmentModule + ".module.upstream_cluster", want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := addressIndex(tt.address); got != tt.want {
+				t.Errorf("addressIndex(%q) = %d, want %d", tt.address, got, tt.want)
+			}
+		})
+	}
+}
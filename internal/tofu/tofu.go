@@ -18,6 +18,7 @@ package tofu
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 
@@ -117,11 +119,13 @@ type Tofu struct {
 	dir       string
 	workspace string
 	variables []string
-	threads   int
-	verbose   bool
+	// out receives the standard output of tofu commands, unless captured
+	out     io.Writer
+	threads int
 }
 
-func New(variableMap map[string]interface{}, dir string, ws string, parallelism int, verbose bool) (*Tofu, error) {
+// New initializes tofu in dir. Output of tofu commands goes to out, or is discarded if out is nil
+func New(ctx context.Context, variableMap map[string]interface{}, dir string, ws string, parallelism int, out io.Writer) (*Tofu, error) {
 	var variables []string
 
 	for k, v := range variableMap {
@@ -133,16 +137,16 @@ func New(variableMap map[string]interface{}, dir string, ws string, parallelism
 		dir:       dir,
 		workspace: ws,
 		threads:   parallelism,
-		verbose:   verbose,
+		out:       out,
 		variables: variables,
 	}
 
-	args := []string{"init", "-upgrade"}
+	args := []string{"init", "-upgrade", "-input=false"}
 	for _, variable := range t.variables {
 		args = append(args, "-var", variable)
 	}
 
-	if err := t.exec(nil, args...); err != nil {
+	if err := t.exec(ctx, nil, args...); err != nil {
 		return nil, err
 	}
 
@@ -150,18 +154,20 @@ func New(variableMap map[string]interface{}, dir string, ws string, parallelism
 }
 
 // exec runs Tofu with the correct chdir parameter
-func (t *Tofu) exec(output io.Writer, args ...string) error {
+func (t *Tofu) exec(ctx context.Context, output io.Writer, args ...string) error {
 	fullArgs := append([]string{"-chdir=" + t.dir}, args...)
-	cmd := vendored.Command("tofu", fullArgs...)
+	cmd := vendored.Command(ctx, "tofu", fullArgs...)
+	// Run in a separate process group, so that a Ctrl-C in the terminal reaches tofu only once, via ctx.
+	// A second interrupt would make tofu exit immediately without persisting its state.
+	// A background process group must not read the terminal, so stdin is not attached, and commands that
+	// could prompt for input get -input=false
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	var errStream strings.Builder
 
 	cmd.Stderr = &errStream
-	cmd.Stdin = os.Stdin
 
-	if t.verbose {
-		cmd.Stdout = os.Stdout
-	}
+	cmd.Stdout = t.out
 
 	if output != nil {
 		cmd.Stdout = output
@@ -174,31 +180,31 @@ func (t *Tofu) exec(output io.Writer, args ...string) error {
 	return nil
 }
 
-func (t *Tofu) handleWorkspace() error {
+func (t *Tofu) handleWorkspace(ctx context.Context) error {
 	if !(len(t.workspace) > 0) {
 		t.workspace = "default"
 	}
 
-	wsExists, err := t.workspaceExists()
+	wsExists, err := t.workspaceExists(ctx)
 	if err != nil {
 		return err
 	}
 
 	if wsExists {
 		log.Printf("Found existing tofu workspace: %s", t.workspace)
-		return t.selectWorkspace()
+		return t.selectWorkspace(ctx)
 	}
 
 	log.Printf("Creating new tofu workspace: %s", t.workspace)
 
-	if err = t.newWorkspace(); err != nil {
+	if err = t.newWorkspace(ctx); err != nil {
 		return err
 	}
 
-	return t.selectWorkspace()
+	return t.selectWorkspace(ctx)
 }
 
-func (t *Tofu) workspaceExists() (bool, error) {
+func (t *Tofu) workspaceExists(ctx context.Context) (bool, error) {
 	args := []string{"workspace", "list"}
 
 	var (
@@ -206,7 +212,7 @@ func (t *Tofu) workspaceExists() (bool, error) {
 		err error
 	)
 
-	if err = t.exec(&out, args...); err != nil {
+	if err = t.exec(ctx, &out, args...); err != nil {
 		return false, fmt.Errorf("failed to list workspaces: %v", err)
 	}
 
@@ -215,35 +221,36 @@ func (t *Tofu) workspaceExists() (bool, error) {
 	return wsExists, err
 }
 
-func (t *Tofu) selectWorkspace() error {
+func (t *Tofu) selectWorkspace(ctx context.Context) error {
 	args := []string{"workspace", "select", t.workspace}
 
-	return t.exec(nil, args...)
+	return t.exec(ctx, nil, args...)
 }
 
-func (t *Tofu) newWorkspace() error {
+func (t *Tofu) newWorkspace(ctx context.Context) error {
 	args := []string{"workspace", "new", t.workspace}
 
-	return t.exec(nil, args...)
+	return t.exec(ctx, nil, args...)
 }
 
-func (t *Tofu) Apply(skipRefresh bool) error {
-	err := t.handleWorkspace()
+// Apply runs tofu apply, limited to targets if any are given
+func (t *Tofu) Apply(ctx context.Context, skipRefresh bool, targets ...string) error {
+	err := t.handleWorkspace(ctx)
 	if err != nil {
 		return err
 	}
 
-	args := t.commonArgs("apply")
+	args := t.commonArgs("apply", targets)
 
 	if skipRefresh {
 		args = append(args, "-refresh=false")
 	}
 
-	return t.exec(nil, args...)
+	return t.exec(ctx, nil, args...)
 }
 
-func (t *Tofu) Output(out io.Writer, jsonFormat bool) error {
-	err := t.handleWorkspace()
+func (t *Tofu) Output(ctx context.Context, out io.Writer, jsonFormat bool) error {
+	err := t.handleWorkspace(ctx)
 	if err != nil {
 		return err
 	}
@@ -262,39 +269,54 @@ func (t *Tofu) Output(out io.Writer, jsonFormat bool) error {
 		writer = os.Stdout
 	}
 
-	return t.exec(writer, args...)
+	return t.exec(ctx, writer, args...)
 }
 
-func (t *Tofu) Destroy() error {
-	err := t.handleWorkspace()
+// Destroy runs tofu destroy, limited to targets if any are given
+func (t *Tofu) Destroy(ctx context.Context, targets ...string) error {
+	err := t.handleWorkspace(ctx)
 	if err != nil {
 		return err
 	}
 
-	args := t.commonArgs("destroy")
+	args := t.commonArgs("destroy", targets)
 
-	return t.exec(nil, args...)
+	return t.exec(ctx, nil, args...)
+}
+
+// MoveState moves a resource to a new address in the state, without changing infrastructure
+func (t *Tofu) MoveState(ctx context.Context, move StateMove) error {
+	err := t.handleWorkspace(ctx)
+	if err != nil {
+		return err
+	}
+
+	return t.exec(ctx, nil, "state", "mv", move.From, move.To)
 }
 
 // commonArgs formats arguments common to multiple commands
-func (t *Tofu) commonArgs(command string) []string {
-	args := []string{command, "-parallelism", strconv.Itoa(t.threads), "-auto-approve"}
+func (t *Tofu) commonArgs(command string, targets []string) []string {
+	args := []string{command, "-parallelism", strconv.Itoa(t.threads), "-auto-approve", "-input=false"}
 
 	for _, variable := range t.variables {
 		args = append(args, "-var", variable)
 	}
 
+	for _, target := range targets {
+		args = append(args, "-target="+target)
+	}
+
 	return args
 }
 
-func (t *Tofu) ParseOutputs() (map[string]Cluster, []CustomCluster, error) {
-	err := t.handleWorkspace()
+func (t *Tofu) ParseOutputs(ctx context.Context) (map[string]Cluster, []CustomCluster, error) {
+	err := t.handleWorkspace(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	buffer := new(bytes.Buffer)
-	if err := t.Output(buffer, true); err != nil {
+	if err := t.Output(ctx, buffer, true); err != nil {
 		return nil, nil, err
 	}
 
@@ -306,9 +328,40 @@ func (t *Tofu) ParseOutputs() (map[string]Cluster, []CustomCluster, error) {
 	return output.Clusters.Value, output.CustomClusters.Value, nil
 }
 
+// StatePath returns the path of the local tofu state file of workspace ws in main directory dir
+func StatePath(dir string, ws string) string {
+	if ws == "" || ws == "default" {
+		return filepath.Join(dir, "terraform.tfstate")
+	}
+
+	return filepath.Join(dir, "terraform.tfstate.d", ws, "terraform.tfstate")
+}
+
+// HasResources returns true if the local tofu state of workspace ws in main directory dir records any resource,
+// that is, if the workspace was applied and not destroyed since. It does not run tofu
+func HasResources(dir string, ws string) (bool, error) {
+	data, err := os.ReadFile(StatePath(dir, ws))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to read tofu state: %w", err)
+	}
+
+	state := struct {
+		Resources []json.RawMessage `json:"resources"`
+	}{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, fmt.Errorf("failed to parse tofu state: %w", err)
+	}
+
+	return len(state.Resources) > 0, nil
+}
+
 // PrintVersion prints the Tofu version information
-func (t *Tofu) PrintVersion() error {
-	return t.exec(log.Writer(), "version")
+func (t *Tofu) PrintVersion(ctx context.Context) error {
+	return t.exec(ctx, log.Writer(), "version")
 }
 
 // IsK3d determines if the current main is k3d
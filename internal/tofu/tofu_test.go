@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tofu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHasResources(t *testing.T) {
+	tests := []struct {
+		name      string
+		workspace string
+		state     string // empty if the state file does not exist
+		want      bool
+		wantErr   bool
+	}{
+		{name: "never applied", workspace: "default"},
+		{name: "applied", workspace: "default", state: `{"version": 4, "resources": [{"type": "null_resource"}]}`, want: true},
+		{name: "destroyed", workspace: "default", state: `{"version": 4, "resources": []}`},
+		{name: "applied workspace", workspace: "scale", state: `{"version": 4, "resources": [{"type": "null_resource"}]}`, want: true},
+		{name: "corrupt", workspace: "default", state: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			if tt.state != "" {
+				path := StatePath(dir, tt.workspace)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(path, []byte(tt.state), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the workspace directory written by dartboard does not count as applied
+			if err := os.MkdirAll(filepath.Join(dir, tt.workspace+"_config"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := HasResources(dir, tt.workspace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HasResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("HasResources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tofu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	variableBlock   = regexp.MustCompile(`^variable\s+"([^"]+)"\s*\{`)
+	defaultArgument = regexp.MustCompile(`^\s*default\s*=\s*(.+?)\s*$`)
+)
+
+// VariableDefaults returns the scalar defaults (numbers, strings and bools) declared in variables.tf of a tofu
+// main directory. Variables without a default, or with a list, map or object default, are omitted
+func VariableDefaults(dir string) (map[string]any, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "variables.tf"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tofu variables: %w", err)
+	}
+
+	return parseVariableDefaults(data), nil
+}
+
+// parseVariableDefaults extracts scalar defaults from top-level arguments of variable blocks
+func parseVariableDefaults(data []byte) map[string]any {
+	defaults := map[string]any{}
+
+	var (
+		variable string
+		depth    int
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if depth == 0 {
+			if match := variableBlock.FindStringSubmatch(line); match != nil {
+				variable = match[1]
+			}
+		} else if match := defaultArgument.FindStringSubmatch(line); depth == 1 && match != nil {
+			if value, ok := parseScalar(match[1]); ok {
+				defaults[variable] = value
+			}
+		}
+
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+		if depth <= 0 {
+			depth = 0
+			variable = ""
+		}
+	}
+
+	return defaults
+}
+
+// parseScalar converts an HCL number, string or bool literal. Numbers are float64, as if decoded from JSON
+func parseScalar(literal string) (any, bool) {
+	if number, err := strconv.ParseFloat(literal, 64); err == nil {
+		return number, true
+	}
+
+	if value, err := strconv.Unquote(literal); err == nil && strings.HasPrefix(literal, `"`) {
+		return value, true
+	}
+
+	if value, err := strconv.ParseBool(literal); err == nil {
+		return value, true
+	}
+
+	return nil, false
+}
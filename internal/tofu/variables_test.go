@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 SUSE LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tofu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVariableDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		tf   string
+		want map[string]any
+	}{
+		{
+			name: "scalars",
+			tf: `variable "first_kubernetes_api_port" {
+  description = "Port number"
+  default     = 6445
+}
+
+variable "ssh_user" {
+  default = "root"
+}
+
+variable "enabled" {
+  type    = bool
+  default = true
+}`,
+			want: map[string]any{"first_kubernetes_api_port": 6445.0, "ssh_user": "root", "enabled": true},
+		},
+		{
+			name: "no default",
+			tf: `variable "region" {
+  description = "AWS region"
+}`,
+			want: map[string]any{},
+		},
+		{
+			name: "nested defaults are ignored",
+			tf: `variable "upstream_cluster" {
+  default = {
+    server_count = 1
+    node_module_variables = {
+      default = 3
+    }
+  }
+}
+
+variable "first_app_http_port" {
+  default = 8080
+}`,
+			want: map[string]any{"first_app_http_port": 8080.0},
+		},
+		{
+			name: "list default",
+			tf: `variable "downstream_cluster_templates" {
+  default = [
+  ]
+}`,
+			want: map[string]any{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseVariableDefaults([]byte(tt.tf))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVariableDefaults() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariableDefaultsOfMains(t *testing.T) {
+	for _, provider := range []string{"k3d", "aws", "azure", "harvester"} {
+		t.Run(provider, func(t *testing.T) {
+			defaults, err := VariableDefaults("../../tofu/main/" + provider)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, variable := range []string{"first_kubernetes_api_port", "first_app_http_port", "first_app_https_port"} {
+				if _, ok := defaults[variable].(float64); !ok {
+					t.Errorf("%s has no numeric default for %s", provider, variable)
+				}
+			}
+		})
+	}
+}
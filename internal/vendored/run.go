@@ -1,17 +1,25 @@
 package vendored
 
 import (
+	"context"
 	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"al.essio.dev/pkg/shellescape"
 )
 
-// Command runs exec.Command assuming name is one of the vendored binaries
-// it also prints the command in copypastable form
-func Command(name string, args ...string) *exec.Cmd {
+// gracePeriod is how long a vendored binary has to exit after being interrupted, before it is killed
+const gracePeriod = 2 * time.Minute
+
+// Command runs exec.CommandContext assuming name is one of the vendored binaries
+// it also prints the command in copypastable form.
+// When ctx is done the process is interrupted, giving it a chance to clean up (eg. tofu persisting its state),
+// then killed if it did not exit within gracePeriod
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
 	vendoredName := filepath.Join(".bin", name)
 
 	quotedArgs := make([]string, len(args))
@@ -21,5 +29,11 @@ func Command(name string, args ...string) *exec.Cmd {
 
 	log.Printf("Running command: \n%s %s\n", vendoredName, strings.Join(quotedArgs, " "))
 
-	return exec.Command(vendoredName, args...)
+	cmd := exec.CommandContext(ctx, vendoredName, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = gracePeriod
+
+	return cmd
 }
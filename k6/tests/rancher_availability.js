@@ -0,0 +1,68 @@
+import { check } from 'k6';
+import http from 'k6/http';
+import { Rate } from 'k6/metrics';
+import { customHandleSummary } from '../generic/k6_utils.js';
+
+// Parameters
+const baseUrl = __ENV.BASE_URL
+const username = __ENV.USERNAME
+const password = __ENV.PASSWORD
+const rate = parseInt(__ENV.RATE || 2)
+const duration = __ENV.DURATION || '30m'
+const resource = __ENV.RESOURCE || "management.cattle.io.setting"
+
+export const handleSummary = customHandleSummary;
+
+// Metrics
+const availability = new Rate('availability')
+
+// Option setting
+export const options = {
+  insecureSkipTLSVerify: true,
+
+  scenarios: {
+    poll: {
+      executor: 'constant-arrival-rate',
+      exec: 'poll',
+      rate: rate,
+      timeUnit: '1s',
+      duration: duration,
+      preAllocatedVUs: rate * 5,
+      maxVUs: rate * 20,
+    }
+  },
+  thresholds: {
+    availability: ['rate>0.95']
+  }
+}
+
+// Test functions, in order of execution
+
+export function setup() {
+  // log in before Rancher goes down, the session survives restarts
+  const res = http.post(`${baseUrl}/v3-public/localProviders/local?action=login`, JSON.stringify({
+    "description": "availability session",
+    "responseType": "cookie",
+    "username": username,
+    "password": password
+  }))
+
+  check(res, {
+    'logging in returns status 200': (r) => r.status === 200,
+  })
+
+  return http.cookieJar().cookiesForURL(res.url)
+}
+
+// Checks that Rancher answers both unauthenticated and authenticated API calls
+export function poll(cookies) {
+  const ping = http.get(`${baseUrl}/ping`, { tags: { endpoint: 'ping' }, timeout: '10s' })
+  availability.add(check(ping, {
+    '/ping returns status 200': (r) => r.status === 200,
+  }), { endpoint: 'ping' })
+
+  const list = http.get(`${baseUrl}/v1/${resource}`, { cookies: cookies, tags: { endpoint: 'steve' }, timeout: '10s' })
+  availability.add(check(list, {
+    'Steve list returns status 200': (r) => r.status === 200,
+  }), { endpoint: 'steve' })
+}
@@ -5,8 +5,9 @@ provider "k3d" {
 }
 
 module "network" {
-  source       = "../../modules/k3d/network"
-  project_name = var.project_name
+  source               = "../../modules/k3d/network"
+  project_name         = var.project_name
+  airgap_registry_port = var.airgap_registry_port
 }
 
 module "test_environment" {
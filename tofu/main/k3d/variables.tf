@@ -49,3 +49,9 @@ variable "first_app_https_port" {
   description = "Port number where the first server's port 443 is published locally. Other clusters' ports are published in successive ports"
   default     = 8443
 }
+
+variable "airgap_registry_port" {
+  description = "Port number where a local registry for air-gapped deployments is published, see the airgap section of darts/k3d.yaml. Null to not create it"
+  type        = number
+  default     = null
+}
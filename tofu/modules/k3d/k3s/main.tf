@@ -266,10 +266,7 @@ resource "k3d_cluster" "cluster" {
 
   registries {
     config = yamlencode({
-      mirrors = {
-        for registry in var.network_config.pull_proxy_registries :
-        registry.name => { endpoints = ["http://${registry.address}"] }
-      }
+      mirrors = local.registry_mirrors
     })
   }
 
@@ -330,6 +327,16 @@ locals {
   k3d_cluster_name         = "${var.project_name}-${var.name}"
 }
 
+locals {
+  // the air-gapped registry is plain HTTP, like pull proxies
+  airgap_registry = try(var.network_config.airgap_registry, null)
+
+  registry_mirrors = merge(
+    { for registry in var.network_config.pull_proxy_registries : registry.name => { endpoints = ["http://${registry.address}"] } },
+    local.airgap_registry != null ? { (local.airgap_registry) = { endpoints = ["http://${local.airgap_registry}"] } } : {},
+  )
+}
+
 resource "local_file" "kubeconfig" {
   count = var.server_count > 0 ? 1 : 0
   content = yamlencode({
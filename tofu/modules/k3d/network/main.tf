@@ -31,3 +31,18 @@ resource "k3d_registry" "proxy" {
     destination = "/var/lib/registry"
   }
 }
+
+resource "k3d_registry" "airgap" {
+  count = var.airgap_registry_port != null ? 1 : 0
+
+  name = "${var.project_name}-airgap"
+  port {
+    host_port = var.airgap_registry_port
+  }
+  network = docker_network.network.name
+
+  volume {
+    source      = "/tmp/k3d-${var.project_name}-airgap"
+    destination = "/var/lib/registry"
+  }
+}
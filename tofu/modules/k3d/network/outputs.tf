@@ -8,5 +8,6 @@ output "config" {
         address = "k3d-${k3d_registry.proxy[i].name}:5000"
       }
     ],
+    airgap_registry : length(k3d_registry.airgap) > 0 ? "k3d-${k3d_registry.airgap[0].name}:5000" : null,
   }
 }
@@ -27,3 +27,9 @@ variable "registry_pull_proxies" {
     },
   ]
 }
+
+variable "airgap_registry_port" {
+  description = "Port to publish a local registry holding all images of air-gapped deployments on. Null to not create it"
+  type        = number
+  default     = null
+}